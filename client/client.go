@@ -2,15 +2,17 @@ package client
 
 import (
 	"bytes"
-	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
+	"mime"
+	"path/filepath"
 	"slices"
 	"strconv"
+	"strings"
 	"syscall"
 
 	"github.com/tclairet/merklestore/files"
@@ -20,124 +22,989 @@ import (
 
 const rootFileName = "root.json"
 
+// ErrAbsent is returned when the server proves a named batch holds no file
+// of the requested name.
+var ErrAbsent = errors.New("proven absent")
+
 var _ Server = server.Client{}
 
 type Server interface {
-	Upload(root string, index, total int, file io.Reader) error
-	Request(root string, index int) (io.Reader, *merkletree.Proof, error)
+	Upload(batch server.Batch, index int, file io.Reader) error
+	UploadNamed(batch server.Batch, index int, name string, file io.Reader) error
+	UploadWithInfo(batch server.Batch, index int, info server.FileInfo, file io.Reader) error
+	UploadStored(batch server.Batch, index int, info server.FileInfo, hash []byte) error
+	Have(batch server.Batch, hashes [][]byte) ([]bool, error)
+	Request(root string, index int) (io.ReadCloser, *merkletree.Proof, error)
+	RequestBlock(root string, index, block int) (io.ReadCloser, *server.BlockProof, error)
+	RequestName(root, name string) (io.ReadCloser, *merkletree.SparseProof, error)
+	NameProof(root, name string) (*merkletree.SparseProof, []byte, error)
+	RequestMany(root string, indexes []int, save func(index int, file io.Reader) error) (*merkletree.MultiProof, error)
+	Batch(root string) (server.Batch, error)
+	Status(root string) (server.Status, error)
+	Hashes(root string) ([][]byte, error)
+	Infos(root string) ([]server.FileInfo, error)
+	Consistency(old, root string) (*merkletree.ConsistencyProof, error)
 }
 
 type Uploader struct {
 	server Server
 
 	fileHandler files.Handler
+	blockSize   int
+	hash        string
+	oddNode     merkletree.OddNode
+	named       bool
+	metadata    bool
+}
+
+type Option func(*Uploader)
+
+// WithBlockSize makes the uploader split every file in blocks of size bytes,
+// allowing the server to serve and prove each block on its own.
+func WithBlockSize(size int) Option {
+	return func(u *Uploader) {
+		u.blockSize = size
+	}
+}
+
+// WithHash selects the hash algorithm of the uploaded batches by name, see
+// merkletree.HashNames.
+func WithHash(name string) Option {
+	return func(u *Uploader) {
+		u.hash = name
+	}
+}
+
+// WithOddNode selects how the trees of the uploaded batches handle the last
+// node of odd levels, see merkletree.OddNodes.
+func WithOddNode(strategy merkletree.OddNode) Option {
+	return func(u *Uploader) {
+		u.oddNode = strategy
+	}
+}
+
+// WithNames addresses the files of the uploaded batches by their path
+// instead of their index, the root is then the one of a sparse tree mapping
+// every path to the hash of its file.
+func WithNames() Option {
+	return func(u *Uploader) {
+		u.named = true
+	}
+}
+
+// WithMetadata commits the name, size, content type and modification time of
+// every file in its leaf, the server serves them with the file.
+func WithMetadata() Option {
+	return func(u *Uploader) {
+		u.metadata = true
+	}
 }
 
-func NewUploader(handler files.Handler, server Server) *Uploader {
-	return &Uploader{
+func NewUploader(handler files.Handler, server Server, options ...Option) *Uploader {
+	u := &Uploader{
 		server:      server,
 		fileHandler: handler,
 	}
+	for _, option := range options {
+		option(u)
+	}
+	return u
 }
 
 func (u Uploader) Upload(paths []string) (string, error) {
-	root, err := u.root(paths)
+	root, hashes, err := u.root(paths)
 	if err != nil {
 		return "", err
 	}
-	for i, path := range paths {
-		if err := u.upload(root, path, i, len(paths)); err != nil {
+	batch := u.batch(root, len(paths))
+	if err := u.uploadMissing(batch, paths, hashes, 0, nil); err != nil {
+		return "", err
+	}
+	return root, nil
+}
+
+// UploadDir uploads the files of manifest, read from dir, followed by the
+// manifest itself and returns the root of the batch. The leaf hash of every
+// file is recorded in the manifest.
+func (u Uploader) UploadDir(dir string, manifest Manifest) (string, error) {
+	if err := manifest.validate(); err != nil {
+		return "", err
+	}
+	batch := u.batch("", len(manifest.Files)+1)
+	if batch.Named || batch.Metadata {
+		return "", fmt.Errorf("directories are uploaded in batches addressed by index, their metadata is in the manifest")
+	}
+	batch.Manifest = true
+	manifest.Files = slices.Clone(manifest.Files)
+	paths := make([]string, len(manifest.Files))
+	hashes := make([][]byte, len(manifest.Files))
+	builder := merkletree.NewIndexedBuilder(batch.Total, batch.Options()...)
+	for i, entry := range manifest.Files {
+		paths[i] = filepath.Join(dir, filepath.FromSlash(entry.Path))
+		h, err := u.fileHash(batch, paths[i])
+		if err != nil {
 			return "", err
 		}
-		if err := u.delete(path); err != nil {
+		hashes[i] = h
+		manifest.Files[i].Leaf = hex.EncodeToString(merkletree.LeafHash(i, h, batch.Options()...))
+		if _, err := builder.AddHash(i, h); err != nil {
 			return "", err
 		}
 	}
-	return root, nil
+	encoded, err := json.Marshal(manifest)
+	if err != nil {
+		return "", err
+	}
+	hasher, sum := batch.FileHasher()
+	_, _ = hasher.Write(encoded)
+	h, err := sum()
+	if err != nil {
+		return "", err
+	}
+	if _, err := builder.AddHash(len(paths), h); err != nil {
+		return "", err
+	}
+	tree, err := builder.Build()
+	if err != nil {
+		return "", err
+	}
+
+	batch.Root = hex.EncodeToString(tree.Root())
+	if err := u.saveRoot(batch.Root); err != nil {
+		return "", err
+	}
+	if err := u.uploadMissing(batch, paths, hashes, 0, nil); err != nil {
+		return "", err
+	}
+	if err := u.server.Upload(batch, len(paths), bytes.NewReader(encoded)); err != nil {
+		return "", err
+	}
+	return batch.Root, nil
 }
 
-func (u Uploader) root(paths []string) (string, error) {
-	builder := merkletree.NewIndexedBuilder(len(paths))
+// Append uploads paths as the next files of the batch of parent and returns
+// the root of the extended batch. The hashes of the parent files are fetched
+// from the server and checked against parent before computing the new root.
+func (u Uploader) Append(parent string, paths []string) (string, error) {
+	batch, err := u.server.Batch(parent)
+	if err != nil {
+		return "", err
+	}
+	if batch.Named || batch.Manifest {
+		return "", fmt.Errorf("batch %s cannot be extended", parent)
+	}
+	hashes, err := u.server.Hashes(parent)
+	if err != nil {
+		return "", err
+	}
+	builder := merkletree.NewIndexedBuilder(batch.Total, batch.Options()...)
+	for i, h := range hashes {
+		if _, err := builder.AddHash(i, h); err != nil {
+			return "", err
+		}
+	}
+	tree, err := builder.Build()
+	if err != nil {
+		return "", err
+	}
+	if got := hex.EncodeToString(tree.Root()); got != parent {
+		return "", fmt.Errorf("hashes of %s have root %s", parent, got)
+	}
+
+	fileHashes := make([][]byte, len(paths))
 	for i, path := range paths {
-		file, err := u.fileHandler.Open(path)
+		if fileHashes[i], err = u.fileHash(batch, path); err != nil {
+			return "", err
+		}
+		h, err := u.committedHash(batch, path, fileHashes[i])
 		if err != nil {
 			return "", err
 		}
-		if _, err := builder.Add(i, file); err != nil {
+		if err := tree.Append(merkletree.LeafHash(batch.Total+i, h, batch.Options()...)); err != nil {
 			return "", err
 		}
 	}
+	root := hex.EncodeToString(tree.Root())
+	if err := u.saveRoot(root); err != nil {
+		return "", err
+	}
+
+	extended := server.Batch{
+		Root:      root,
+		Total:     batch.Total + len(paths),
+		BlockSize: batch.BlockSize,
+		Hash:      batch.Hash,
+		OddNode:   batch.OddNode,
+		Metadata:  batch.Metadata,
+		Parent:    parent,
+	}
+	if err := u.uploadMissing(extended, paths, fileHashes, batch.Total, nil); err != nil {
+		return "", err
+	}
+	return root, nil
+}
+
+// VerifyConsistency checks that the batch of old is a previous version of the
+// batch of root: its files are the first files of root, unchanged.
+func (u Uploader) VerifyConsistency(old, root string) error {
+	oldBatch, err := u.server.Batch(old)
+	if err != nil {
+		return err
+	}
+	batch, err := u.server.Batch(root)
+	if err != nil {
+		return err
+	}
+	proof, err := u.server.Consistency(old, root)
+	if err != nil {
+		return err
+	}
+	if proof.OldSize() != oldBatch.Total || proof.Size() != batch.Total {
+		return fmt.Errorf("proof is for %d files of %d", proof.OldSize(), proof.Size())
+	}
+	oldRoot, err := hex.DecodeString(old)
+	if err != nil {
+		return err
+	}
+	newRoot, err := hex.DecodeString(root)
+	if err != nil {
+		return err
+	}
+	return proof.Verify(oldRoot, newRoot)
+}
+
+// Resume finishes an interrupted upload of root, paths must be the same list
+// given to Upload. Only the indexes the server did not receive are sent.
+func (u Uploader) Resume(root string, paths []string) error {
+	batch := u.batch(root, len(paths))
+	var received []int
+	status, err := u.server.Status(root)
+	switch {
+	case errors.Is(err, server.ErrUnknownRoot):
+	case err != nil:
+		return err
+	case status.Complete:
+		return nil
+	default:
+		if status.Total != len(paths) {
+			return fmt.Errorf("root %s expects %d files, got %d", root, status.Total, len(paths))
+		}
+		batch = status.Batch
+		received = status.Received
+	}
+	return u.uploadMissing(batch, paths, nil, 0, received)
+}
+
+// batch returns the batch of total files uploaded with the settings of u.
+func (u Uploader) batch(root string, total int) server.Batch {
+	return server.Batch{Root: root, Total: total, BlockSize: u.blockSize, Hash: u.hash, OddNode: u.oddNode, Named: u.named, Metadata: u.metadata}
+}
+
+// uploadMissing uploads paths as the files of batch starting at index first.
+// The server is first asked which files it already stores, those are added
+// from their hash without sending their content. hashes are the hashes of
+// the files when already computed, nil otherwise.
+func (u Uploader) uploadMissing(batch server.Batch, paths []string, hashes [][]byte, first int, received []int) error {
+	done := make(map[int]struct{}, len(received))
+	for _, index := range received {
+		done[index] = struct{}{}
+	}
+	var indexes []int
+	var missing [][]byte
+	for i, path := range paths {
+		if _, exist := done[first+i]; exist {
+			continue
+		}
+		var h []byte
+		if hashes != nil {
+			h = hashes[i]
+		} else {
+			var err error
+			if h, err = u.fileHash(batch, path); err != nil {
+				return err
+			}
+		}
+		indexes, missing = append(indexes, i), append(missing, h)
+	}
+	if len(indexes) == 0 {
+		return nil
+	}
+	have, err := u.server.Have(batch, missing)
+	if err != nil {
+		return err
+	}
+	for j, i := range indexes {
+		var stored []byte
+		if have[j] {
+			stored = missing[j]
+		}
+		if err := u.upload(batch, paths[i], first+i, stored); err != nil {
+			return err
+		}
+		if err := u.delete(paths[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// root returns the root of the batch of paths along with the hashes of the
+// files, hashed in parallel.
+func (u Uploader) root(paths []string) (string, [][]byte, error) {
+	batch := u.batch("", len(paths))
+	if batch.Named && batch.Metadata {
+		return "", nil, fmt.Errorf("named batches keep no file metadata")
+	}
+	if batch.Named {
+		return u.namedRoot(batch, paths)
+	}
+	hashes := make([][]byte, len(paths))
+	builder := merkletree.NewIndexedBuilder(len(paths), batch.Options()...)
+	err := builder.AddAll(func(index int) ([]byte, error) {
+		h, err := u.fileHash(batch, paths[index])
+		if err != nil {
+			return nil, err
+		}
+		hashes[index] = h
+		return u.committedHash(batch, paths[index], h)
+	})
+	if err != nil {
+		return "", nil, err
+	}
 	tree, err := builder.Build()
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
 	root := hex.EncodeToString(tree.Root())
 	if err := u.saveRoot(root); err != nil {
-		return "", err
+		return "", nil, err
 	}
-	return root, nil
+	return root, hashes, nil
+}
+
+// namedRoot returns the root of the sparse tree mapping the name of every
+// path to the hash of its file, along with those hashes.
+func (u Uploader) namedRoot(batch server.Batch, paths []string) (string, [][]byte, error) {
+	entries := make(map[string][]byte, len(paths))
+	hashes := make([][]byte, len(paths))
+	for i, path := range paths {
+		name, err := nameOf(path)
+		if err != nil {
+			return "", nil, err
+		}
+		if _, exist := entries[name]; exist {
+			return "", nil, fmt.Errorf("name %s given twice", name)
+		}
+		if hashes[i], err = u.fileHash(batch, path); err != nil {
+			return "", nil, err
+		}
+		entries[name] = hashes[i]
+	}
+	tree, err := merkletree.NewSparse(entries, batch.Options()...)
+	if err != nil {
+		return "", nil, err
+	}
+
+	root := hex.EncodeToString(tree.Root())
+	if err := u.saveRoot(root); err != nil {
+		return "", nil, err
+	}
+	return root, hashes, nil
+}
+
+// nameOf returns the name of path in a named batch, its slash separated form.
+// It must stay under the download directory, so absolute paths and paths
+// going up are rejected.
+func nameOf(path string) (string, error) {
+	if !filepath.IsLocal(path) {
+		return "", fmt.Errorf("%s is not a local path", path)
+	}
+	return filepath.ToSlash(filepath.Clean(path)), nil
+}
+
+// committedHash returns the hash of path committed in its leaf from the hash
+// h of its content, the one of its content along with its metadata for a
+// Metadata batch.
+func (u Uploader) committedHash(batch server.Batch, path string, h []byte) ([]byte, error) {
+	if !batch.Metadata {
+		return h, nil
+	}
+	info, err := u.fileInfo(path)
+	if err != nil {
+		return nil, err
+	}
+	return info.Commit(batch, h)
+}
+
+// fileInfo returns the metadata of path. The size and modification time come
+// from the file handler when it is a files.Stater, the size is read otherwise
+// and the modification time left unknown.
+func (u Uploader) fileInfo(path string) (server.FileInfo, error) {
+	info := server.FileInfo{
+		Name:        filepath.Base(path),
+		ContentType: mime.TypeByExtension(filepath.Ext(path)),
+	}
+	if stater, ok := u.fileHandler.(files.Stater); ok {
+		stat, err := stater.Stat(path)
+		if err != nil {
+			return server.FileInfo{}, err
+		}
+		info.Size, info.ModTime = stat.Size(), stat.ModTime().Unix()
+		return info, nil
+	}
+	file, err := u.fileHandler.Open(path)
+	if err != nil {
+		return server.FileInfo{}, err
+	}
+	defer file.Close()
+	if info.Size, err = io.Copy(io.Discard, file); err != nil {
+		return server.FileInfo{}, err
+	}
+	return info, nil
+}
+
+func (u Uploader) fileHash(batch server.Batch, path string) ([]byte, error) {
+	file, err := u.fileHandler.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	hasher, sum := batch.FileHasher()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return nil, err
+	}
+	return sum()
 }
 
 func (u Uploader) Download(root string, indexes ...int) error {
-	roots, err := u.getRoots()
+	batch, err := u.remoteBatch(root)
 	if err != nil {
 		return err
 	}
-	if !slices.Contains(roots, root) {
-		return fmt.Errorf("unknown root hash")
+	if len(indexes) > 1 {
+		return u.downloadMany(batch, indexes, func(index int) string {
+			return fmt.Sprintf("%s/%d", batch.Root, index)
+		}, nil)
 	}
 	for _, index := range indexes {
-		if err := u.downloadIndex(root, index); err != nil {
+		if err := u.downloadIndex(batch, index); err != nil {
 			return fmt.Errorf("index %d: %w", index, err)
 		}
 	}
 	return nil
 }
 
-func (u Uploader) downloadIndex(root string, index int) error {
-	file, proof, err := u.server.Request(root, index)
+// DownloadDir restores the directory uploaded with UploadDir under root:
+// every file is saved at its path in the manifest, with its mode when the
+// file handler is a files.Chmoder, once the manifest and the file are proven.
+func (u Uploader) DownloadDir(root string) error {
+	batch, err := u.remoteBatch(root)
+	if err != nil {
+		return err
+	}
+	if !batch.Manifest {
+		return fmt.Errorf("batch %s has no manifest", root)
+	}
+	manifest, err := u.manifest(batch)
+	if err != nil {
+		return fmt.Errorf("manifest: %w", err)
+	}
+	if len(manifest.Files) == 0 {
+		return nil
+	}
+	pathOf := func(index int) string {
+		return filepath.Join(root, filepath.FromSlash(manifest.Files[index].Path))
+	}
+	indexes := make([]int, len(manifest.Files))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	err = u.downloadMany(batch, indexes, pathOf, func(index int, leaf []byte, size int64) error {
+		entry := manifest.Files[index]
+		if hex.EncodeToString(leaf) != entry.Leaf || size != entry.Size {
+			return fmt.Errorf("%s does not match the manifest", entry.Path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	chmoder, ok := u.fileHandler.(files.Chmoder)
+	if !ok {
+		return nil
+	}
+	for i, entry := range manifest.Files {
+		if err := chmoder.Chmod(pathOf(i), entry.Mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// manifest fetches and verifies the manifest of batch, its last file.
+func (u Uploader) manifest(batch remoteBatch) (Manifest, error) {
+	index := batch.Total - 1
+	file, proof, err := u.server.Request(batch.Root, index)
+	if err != nil {
+		return Manifest{}, err
+	}
+	defer file.Close()
+	hasher, sum := batch.FileHasher()
+	b, err := io.ReadAll(io.TeeReader(file, hasher))
+	if err != nil {
+		return Manifest{}, err
+	}
+	h, err := sum()
+	if err != nil {
+		return Manifest{}, err
+	}
+	if err := verifyProof(batch, index, proof, h); err != nil {
+		return Manifest{}, err
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		return Manifest{}, err
+	}
+	if len(manifest.Files) != index {
+		return Manifest{}, fmt.Errorf("got %d files for a batch of %d", len(manifest.Files), index)
+	}
+	return manifest, manifest.validate()
+}
+
+// DownloadNamed fetches the files of names from the named batch of root, every
+// file is saved under root at its name. ErrAbsent is returned once the server
+// proved a name is not in the batch.
+func (u Uploader) DownloadNamed(root string, names ...string) error {
+	batch, err := u.remoteBatch(root)
 	if err != nil {
 		return err
 	}
+	if !batch.Named {
+		return fmt.Errorf("batch %s is addressed by index", root)
+	}
+	for _, name := range names {
+		if err := u.downloadName(batch.Batch, name); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
 
-	if err := u.fileHandler.Save(fmt.Sprintf("%s/%d", root, index), file); err != nil {
+func (u Uploader) downloadName(batch server.Batch, name string) error {
+	if _, err := nameOf(filepath.FromSlash(name)); err != nil {
+		return err
+	}
+	b, err := hex.DecodeString(batch.Root)
+	if err != nil {
 		return err
 	}
+	file, proof, err := u.server.RequestName(batch.Root, name)
+	if errors.Is(err, server.ErrUnknownName) {
+		return u.verifyAbsence(batch, name)
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
 
-	reader, err := u.fileHandler.Open(fmt.Sprintf("%s/%d", root, index))
+	path := filepath.Join(batch.Root, filepath.FromSlash(name))
+	hasher, sum := batch.FileHasher()
+	if err := u.fileHandler.Save(path, io.TeeReader(file, hasher)); err != nil {
+		return err
+	}
+	h, err := sum()
 	if err != nil {
 		return err
 	}
+	if err := proof.Verify(name, h, b); err != nil {
+		_ = u.fileHandler.Delete(path)
+		return err
+	}
+	return nil
+}
 
-	hasher := sha256.New()
-	if _, err := io.Copy(hasher, reader); err != nil {
+// verifyAbsence checks the server proof that name is not in batch.
+func (u Uploader) verifyAbsence(batch server.Batch, name string) error {
+	proof, hash, err := u.server.NameProof(batch.Root, name)
+	if err != nil {
 		return err
 	}
-	h1 := hasher.Sum(nil)
-	hasher.Reset()
-	hasher.Write([]byte(strconv.Itoa(index)))
-	hasher.Write(h1)
-	b, err := hex.DecodeString(root)
+	if hash != nil {
+		return fmt.Errorf("server holds a file of hash %x but does not serve it", hash)
+	}
+	b, err := hex.DecodeString(batch.Root)
 	if err != nil {
 		return err
 	}
-	return proof.Verify(hasher.Sum(nil), b)
+	if err := proof.VerifyAbsence(name, b); err != nil {
+		return err
+	}
+	return ErrAbsent
 }
 
-func (u Uploader) upload(root, path string, i, total int) error {
-	file, err := u.fileHandler.Open(path)
+// downloadMany fetches several files with a single proof and saves each one
+// at pathOf(index), every file is removed when the proof does not verify or
+// check, when given, fails for one of them.
+func (u Uploader) downloadMany(batch remoteBatch, indexes []int, pathOf func(index int) string, check func(index int, leaf []byte, size int64) error) error {
+	var paths []string
+	var leaves [][]byte
+	proof, err := u.server.RequestMany(batch.Root, indexes, func(index int, file io.Reader) error {
+		path := pathOf(index)
+		paths = append(paths, path)
+		hasher, sum := batch.FileHasher()
+		counter := &countingReader{Reader: file}
+		if err := u.fileHandler.Save(path, io.TeeReader(counter, hasher)); err != nil {
+			return err
+		}
+		h, err := sum()
+		if err != nil {
+			return err
+		}
+		leaf, err := batch.leaf(index, h)
+		if err != nil {
+			return err
+		}
+		leaves = append(leaves, leaf)
+		if check != nil {
+			return check(index, leaf, counter.n)
+		}
+		return nil
+	})
+	if err == nil {
+		err = verifyMany(batch.Batch, indexes, proof, leaves)
+	}
+	if err != nil {
+		for _, path := range paths {
+			_ = u.fileHandler.Delete(path)
+		}
+		return err
+	}
+	return nil
+}
+
+func verifyMany(batch server.Batch, indexes []int, proof *merkletree.MultiProof, leaves [][]byte) error {
+	expected := slices.Clone(indexes)
+	slices.Sort(expected)
+	expected = slices.Compact(expected)
+	if !slices.Equal(proof.Indexes(), expected) || proof.Size() != batch.Total {
+		return fmt.Errorf("proof is for files %v of %d", proof.Indexes(), proof.Size())
+	}
+	b, err := hex.DecodeString(batch.Root)
 	if err != nil {
 		return err
 	}
+	return proof.Verify(leaves, b)
+}
 
+func (u Uploader) downloadIndex(batch remoteBatch, index int) error {
+	file, proof, err := u.server.Request(batch.Root, index)
+	if err != nil {
+		return err
+	}
 	defer file.Close()
-	if err := u.server.Upload(root, i, total, file); err != nil {
+
+	path := fmt.Sprintf("%s/%d", batch.Root, index)
+	hasher, sum := batch.FileHasher()
+	if err := u.fileHandler.Save(path, io.TeeReader(file, hasher)); err != nil {
+		return err
+	}
+
+	h, err := sum()
+	if err != nil {
 		return err
 	}
+	if err := verifyProof(batch, index, proof, h); err != nil {
+		_ = u.fileHandler.Delete(path)
+		return err
+	}
+	return nil
+}
+
+// DownloadBlocks downloads the file at index of a chunked batch one block at
+// a time, a block is kept only once its proof verifies. Blocks are kept under
+// ROOT/INDEX.blocks until the file is complete, so a download interrupted by
+// an error resumes with the missing blocks.
+func (u Uploader) DownloadBlocks(root string, index int) error {
+	batch, err := u.remoteBatch(root)
+	if err != nil {
+		return err
+	}
+	if batch.BlockSize == 0 {
+		return fmt.Errorf("batch %s is not chunked", root)
+	}
+	dir := fmt.Sprintf("%s/%d.blocks", root, index)
+	blocks, err := u.blockCount(dir)
+	if err != nil {
+		return err
+	}
+	for block := 0; blocks == 0 || block < blocks; block++ {
+		path := fmt.Sprintf("%s/%d", dir, block)
+		if blocks != 0 {
+			exist, err := u.exists(path)
+			if err != nil {
+				return err
+			}
+			if exist {
+				continue
+			}
+		}
+		count, err := u.downloadBlock(batch, index, block, path)
+		if err != nil {
+			return fmt.Errorf("block %d: %w", block, err)
+		}
+		if blocks != 0 && count != blocks {
+			return fmt.Errorf("block %d: proof is for a file of %d blocks, not %d", block, count, blocks)
+		}
+		if blocks == 0 {
+			blocks = count
+			if err := u.fileHandler.Save(dir+"/count", strings.NewReader(strconv.Itoa(count))); err != nil {
+				return err
+			}
+		}
+	}
+
+	reader, writer := io.Pipe()
+	defer reader.Close()
+	go func() {
+		writer.CloseWithError(u.copyBlocks(writer, dir, blocks))
+	}()
+	if err := u.fileHandler.Save(fmt.Sprintf("%s/%d", root, index), reader); err != nil {
+		return err
+	}
+	return u.fileHandler.Delete(dir)
+}
+
+// downloadBlock saves block of the file at index of batch to path once its
+// proof verifies, and returns the number of blocks of the file.
+func (u Uploader) downloadBlock(batch remoteBatch, index, block int, path string) (int, error) {
+	content, proof, err := u.server.RequestBlock(batch.Root, index, block)
+	if err != nil {
+		return 0, err
+	}
+	defer content.Close()
+	b, err := io.ReadAll(io.LimitReader(content, int64(batch.BlockSize)+1))
+	if err != nil {
+		return 0, err
+	}
+	if len(b) > batch.BlockSize {
+		return 0, fmt.Errorf("block is larger than %d bytes", batch.BlockSize)
+	}
+	newHash, err := batch.NewHash()
+	if err != nil {
+		return 0, err
+	}
+	h := newHash()
+	h.Write(b)
+	if proof.Block.Index() != block {
+		return 0, fmt.Errorf("proof is for block %d", proof.Block.Index())
+	}
+	if err := proof.Block.Verify(merkletree.LeafHash(block, h.Sum(nil), batch.Options()...), proof.FileHash); err != nil {
+		return 0, err
+	}
+	if err := verifyProof(batch, index, proof.File, proof.FileHash); err != nil {
+		return 0, err
+	}
+	if err := u.fileHandler.Save(path, bytes.NewReader(b)); err != nil {
+		return 0, err
+	}
+	return proof.Block.Size(), nil
+}
+
+// blockCount returns the number of blocks of the file downloaded under dir,
+// 0 until its first block is downloaded.
+func (u Uploader) blockCount(dir string) (int, error) {
+	file, err := u.fileHandler.Open(dir + "/count")
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	b, err := io.ReadAll(file)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(string(b))
+}
+
+func (u Uploader) exists(path string) (bool, error) {
+	file, err := u.fileHandler.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, file.Close()
+}
+
+// copyBlocks writes the blocks downloaded under dir to w, in order.
+func (u Uploader) copyBlocks(w io.Writer, dir string, blocks int) error {
+	for block := 0; block < blocks; block++ {
+		file, err := u.fileHandler.Open(fmt.Sprintf("%s/%d", dir, block))
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// verifyProof checks that proof proves the file of hash h at index of batch.
+func verifyProof(batch remoteBatch, index int, proof *merkletree.Proof, h []byte) error {
+	b, err := hex.DecodeString(batch.Root)
+	if err != nil {
+		return err
+	}
+	if proof.Index() != index || proof.Size() != batch.Total {
+		return fmt.Errorf("proof is for file %d of %d", proof.Index(), proof.Size())
+	}
+	leaf, err := batch.leaf(index, h)
+	if err != nil {
+		return err
+	}
+	return proof.Verify(leaf, b)
+}
+
+// remoteBatch is a stored batch being downloaded, infos holds the metadata
+// committed in the leaves of a Metadata batch.
+type remoteBatch struct {
+	server.Batch
+	infos []server.FileInfo
+}
+
+// remoteBatch fetches the batch of root, which must be one of the roots
+// uploaded by u.
+func (u Uploader) remoteBatch(root string) (remoteBatch, error) {
+	roots, err := u.getRoots()
+	if err != nil {
+		return remoteBatch{}, err
+	}
+	if !slices.Contains(roots, root) {
+		return remoteBatch{}, fmt.Errorf("unknown root hash")
+	}
+	batch, err := u.server.Batch(root)
+	if err != nil {
+		return remoteBatch{}, err
+	}
+	if !batch.Metadata {
+		return remoteBatch{Batch: batch}, nil
+	}
+	infos, err := u.server.Infos(root)
+	if err != nil {
+		return remoteBatch{}, err
+	}
+	if len(infos) != batch.Total {
+		return remoteBatch{}, fmt.Errorf("got metadata of %d files for a batch of %d", len(infos), batch.Total)
+	}
+	return remoteBatch{Batch: batch, infos: infos}, nil
+}
+
+// leaf returns the leaf hash of the file at index whose content has hash h.
+func (batch remoteBatch) leaf(index int, h []byte) ([]byte, error) {
+	if batch.Metadata {
+		if index < 0 || index >= len(batch.infos) {
+			return nil, fmt.Errorf("no metadata for file %d", index)
+		}
+		var err error
+		if h, err = batch.infos[index].Commit(batch.Batch, h); err != nil {
+			return nil, err
+		}
+	}
+	return merkletree.LeafHash(index, h, batch.Options()...), nil
+}
+
+// countingReader counts the bytes read from Reader.
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// VerifyFile checks offline that file is the one proven by proof under root,
+// blockSize must be the one of the batch when it was uploaded in blocks.
+func VerifyFile(root string, proof *merkletree.Proof, file io.Reader, blockSize int) error {
+	return verifyFile(root, proof, file, blockSize, nil)
+}
+
+// VerifyFileWithInfo checks offline that file and its metadata info, as
+// listed by the server, are the ones proven by proof under the root of a
+// Metadata batch.
+func VerifyFileWithInfo(root string, proof *merkletree.Proof, file io.Reader, blockSize int, info server.FileInfo) error {
+	return verifyFile(root, proof, file, blockSize, &info)
+}
+
+func verifyFile(root string, proof *merkletree.Proof, file io.Reader, blockSize int, info *server.FileInfo) error {
+	b, err := hex.DecodeString(root)
+	if err != nil {
+		return err
+	}
+	batch := server.Batch{BlockSize: blockSize, Hash: proof.Hash(), OddNode: proof.OddNode()}
+	hasher, sum := batch.FileHasher()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return err
+	}
+	h, err := sum()
+	if err != nil {
+		return err
+	}
+	if info != nil {
+		if h, err = info.Commit(batch, h); err != nil {
+			return err
+		}
+	}
+	options := append(batch.Options(), merkletree.WithMode(proof.Mode()))
+	return proof.Verify(merkletree.LeafHash(proof.Index(), h, options...), b)
+}
+
+// upload uploads path as the file at index i of batch. When stored is the
+// hash of a file the server stores only the hash is sent, unless the server
+// no longer stores it.
+func (u Uploader) upload(batch server.Batch, path string, i int, stored []byte) error {
+	var info server.FileInfo
+	var err error
+	switch {
+	case batch.Named:
+		info.Name, err = nameOf(path)
+	case batch.Metadata:
+		info, err = u.fileInfo(path)
+	}
+	if err != nil {
+		return err
+	}
+	if stored != nil {
+		err := u.server.UploadStored(batch, i, info, stored)
+		if !errors.Is(err, server.ErrUnknownBlob) {
+			return err
+		}
+	}
+
+	file, err := u.fileHandler.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	switch {
+	case batch.Named:
+		return u.server.UploadNamed(batch, i, info.Name, file)
+	case batch.Metadata:
+		return u.server.UploadWithInfo(batch, i, info, file)
+	default:
+		return u.server.Upload(batch, i, file)
+	}
+}
+
 func (u Uploader) delete(path string) error {
 	if err := u.fileHandler.Delete(path); err != nil {
 		return err
@@ -4,23 +4,38 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"mime"
 	"reflect"
-	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"testing/fstest"
 
 	"github.com/tclairet/merklestore/merkletree"
+	"github.com/tclairet/merklestore/server"
 )
 
 type fakeFileHandler struct {
 	saved map[string][]byte
+	// strict fails to open the files which were not saved, their path is
+	// read as their content otherwise
+	strict bool
+	// opened counts the opened files
+	opened atomic.Int32
 }
 
 func (f *fakeFileHandler) Open(name string) (io.ReadCloser, error) {
+	f.opened.Add(1)
 	if _, exist := f.saved[name]; exist {
 		return io.NopCloser(bytes.NewReader(f.saved[name])), nil
 	}
+	if f.strict {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
 	if rootFileName == name {
 		return io.NopCloser(bytes.NewReader([]byte("{}"))), nil
 	}
@@ -28,6 +43,11 @@ func (f *fakeFileHandler) Open(name string) (io.ReadCloser, error) {
 }
 
 func (f *fakeFileHandler) Delete(path string) error {
+	for name := range f.saved {
+		if name == path || strings.HasPrefix(name, path+"/") {
+			delete(f.saved, name)
+		}
+	}
 	return nil
 }
 
@@ -41,44 +61,229 @@ type fakeServer struct {
 	store   map[string][]byte
 	tree    map[string]*merkletree.MerkleTree
 	builder map[string]*merkletree.IndexedBuilder
+	batches map[string]server.Batch
+	names   map[string]map[string][]byte
+	sparse  map[string]*merkletree.SparseMerkleTree
+	infos   map[string][]server.FileInfo
+	failAt  map[int]error
+	// failBlock fails the requests of the blocks it holds
+	failBlock map[int]error
+	// stored counts the files uploaded from their hash, forget makes the
+	// server answer that it has files it no longer stores.
+	stored int
+	forget bool
+}
+
+func newFakeServer() *fakeServer {
+	return &fakeServer{
+		store:     make(map[string][]byte),
+		tree:      make(map[string]*merkletree.MerkleTree),
+		builder:   make(map[string]*merkletree.IndexedBuilder),
+		batches:   make(map[string]server.Batch),
+		names:     make(map[string]map[string][]byte),
+		sparse:    make(map[string]*merkletree.SparseMerkleTree),
+		infos:     make(map[string][]server.FileInfo),
+		failAt:    make(map[int]error),
+		failBlock: make(map[int]error),
+	}
 }
 
-func (f *fakeServer) Upload(root string, index int, total int, file io.Reader) error {
+func (f *fakeServer) Upload(batch server.Batch, index int, file io.Reader) error {
+	if err := f.failAt[index]; err != nil {
+		return err
+	}
+	root, total := batch.Root, batch.Total
+	f.batches[root] = batch
 	b, _ := io.ReadAll(file)
 	f.store[fmt.Sprintf("%s%d", root, index)] = b
 	if _, exist := f.builder[root]; !exist {
 		f.builder[root] = merkletree.NewIndexedBuilder(total)
+		for i := 0; batch.Parent != "" && i < f.batches[batch.Parent].Total; i++ {
+			stored := f.store[fmt.Sprintf("%s%d", batch.Parent, i)]
+			f.store[fmt.Sprintf("%s%d", root, i)] = stored
+			h := sha256.Sum256(stored)
+			_, _ = f.builder[root].AddHash(i, h[:])
+		}
 	}
-	hasher := sha256.New()
+	hasher, sum := batch.FileHasher()
 	hasher.Write(b)
-	done, err := f.builder[root].AddHash(index, hasher.Sum(nil))
+	h, _ := sum()
+	if batch.Metadata {
+		h, _ = f.infos[root][index].Commit(batch, h)
+	}
+	done, err := f.builder[root].AddHash(index, h)
 	if done {
 		f.tree[root], _ = f.builder[root].Build()
 	}
 	return err
 }
 
-func (f *fakeServer) Request(root string, index int) (io.Reader, *merkletree.Proof, error) {
-	stored := f.store[fmt.Sprintf("%s%d", root, index)]
-	hasher := sha256.New()
-	hasher.Write(stored)
-	h1 := hasher.Sum(nil)
-	hasher.Reset()
-	hasher.Write([]byte(strconv.Itoa(index)))
-	hasher.Write(h1)
-	proof, err := f.tree[root].ProofFor(hasher.Sum(nil))
+func (f *fakeServer) UploadNamed(batch server.Batch, index int, name string, file io.Reader) error {
+	root := batch.Root
+	f.batches[root] = batch
+	if f.names[root] == nil {
+		f.names[root] = make(map[string][]byte)
+	}
+	f.names[root][name], _ = io.ReadAll(file)
+	if len(f.names[root]) == batch.Total {
+		entries := make(map[string][]byte)
+		for name, b := range f.names[root] {
+			h := sha256.Sum256(b)
+			entries[name] = h[:]
+		}
+		f.sparse[root], _ = merkletree.NewSparse(entries)
+	}
+	return nil
+}
+
+func (f *fakeServer) UploadWithInfo(batch server.Batch, index int, info server.FileInfo, file io.Reader) error {
+	if f.infos[batch.Root] == nil {
+		f.infos[batch.Root] = make([]server.FileInfo, batch.Total)
+	}
+	f.infos[batch.Root][index] = info
+	batch.Metadata = true
+	return f.Upload(batch, index, file)
+}
+
+func (f *fakeServer) UploadStored(batch server.Batch, index int, info server.FileInfo, hash []byte) error {
+	b, exist := f.content(hash)
+	if !exist || f.forget {
+		return server.ErrUnknownBlob
+	}
+	f.stored++
+	switch {
+	case batch.Named:
+		return f.UploadNamed(batch, index, info.Name, bytes.NewReader(b))
+	case batch.Metadata:
+		return f.UploadWithInfo(batch, index, info, bytes.NewReader(b))
+	default:
+		return f.Upload(batch, index, bytes.NewReader(b))
+	}
+}
+
+func (f *fakeServer) Have(batch server.Batch, hashes [][]byte) ([]bool, error) {
+	have := make([]bool, len(hashes))
+	for i, h := range hashes {
+		_, have[i] = f.content(h)
+	}
+	return have, nil
+}
+
+// content returns the stored file whose sha256 is h.
+func (f *fakeServer) content(h []byte) ([]byte, bool) {
+	for _, b := range f.store {
+		if sum := sha256.Sum256(b); bytes.Equal(sum[:], h) {
+			return b, true
+		}
+	}
+	for _, names := range f.names {
+		for _, b := range names {
+			if sum := sha256.Sum256(b); bytes.Equal(sum[:], h) {
+				return b, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func (f *fakeServer) Infos(root string) ([]server.FileInfo, error) {
+	return f.infos[root], nil
+}
+
+func (f *fakeServer) Request(root string, index int) (io.ReadCloser, *merkletree.Proof, error) {
+	proof, err := f.tree[root].ProofForIndex(index)
 	if err != nil {
 		return nil, nil, err
 	}
-	return bytes.NewReader(f.store[fmt.Sprintf("%s%d", root, index)]), proof, nil
+	return io.NopCloser(bytes.NewReader(f.store[fmt.Sprintf("%s%d", root, index)])), proof, nil
 }
 
-func TestUploader(t *testing.T) {
-	server := &fakeServer{
-		store:   make(map[string][]byte),
-		tree:    make(map[string]*merkletree.MerkleTree),
-		builder: make(map[string]*merkletree.IndexedBuilder),
+func (f *fakeServer) RequestBlock(root string, index, block int) (io.ReadCloser, *server.BlockProof, error) {
+	if err := f.failBlock[block]; err != nil {
+		return nil, nil, err
+	}
+	batch := f.batches[root]
+	b := f.store[fmt.Sprintf("%s%d", root, index)]
+	blocks, err := merkletree.BlockTree(bytes.NewReader(b), batch.BlockSize, batch.Options()...)
+	if err != nil {
+		return nil, nil, err
+	}
+	blockProof, err := blocks.ProofForIndex(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	fileProof, err := f.tree[root].ProofForIndex(index)
+	if err != nil {
+		return nil, nil, err
+	}
+	content := b[block*batch.BlockSize : min((block+1)*batch.BlockSize, len(b))]
+	return io.NopCloser(bytes.NewReader(content)), &server.BlockProof{
+		Hash:     batch.Hash,
+		FileHash: blocks.Root(),
+		Block:    blockProof,
+		File:     fileProof,
+	}, nil
+}
+
+func (f *fakeServer) RequestName(root, name string) (io.ReadCloser, *merkletree.SparseProof, error) {
+	b, exist := f.names[root][name]
+	if !exist {
+		return nil, nil, server.ErrUnknownName
+	}
+	return io.NopCloser(bytes.NewReader(b)), f.sparse[root].ProofFor(name), nil
+}
+
+func (f *fakeServer) NameProof(root, name string) (*merkletree.SparseProof, []byte, error) {
+	hash, _ := f.sparse[root].Get(name)
+	return f.sparse[root].ProofFor(name), hash, nil
+}
+
+func (f *fakeServer) RequestMany(root string, indexes []int, save func(index int, file io.Reader) error) (*merkletree.MultiProof, error) {
+	proof, err := f.tree[root].MultiProofFor(indexes)
+	if err != nil {
+		return nil, err
+	}
+	for _, index := range proof.Indexes() {
+		if err := save(index, bytes.NewReader(f.store[fmt.Sprintf("%s%d", root, index)])); err != nil {
+			return nil, err
+		}
+	}
+	return proof, nil
+}
+
+func (f *fakeServer) Batch(root string) (server.Batch, error) {
+	return f.batches[root], nil
+}
+
+func (f *fakeServer) Status(root string) (server.Status, error) {
+	batch, exist := f.batches[root]
+	if !exist {
+		return server.Status{}, server.ErrUnknownRoot
 	}
+	status := server.Status{Batch: batch, Received: []int{}, Complete: f.tree[root] != nil}
+	for i := 0; i < batch.Total; i++ {
+		if _, exist := f.store[fmt.Sprintf("%s%d", root, i)]; exist {
+			status.Received = append(status.Received, i)
+		}
+	}
+	return status, nil
+}
+
+func (f *fakeServer) Hashes(root string) ([][]byte, error) {
+	var hashes [][]byte
+	for i := 0; i < f.batches[root].Total; i++ {
+		h := sha256.Sum256(f.store[fmt.Sprintf("%s%d", root, i)])
+		hashes = append(hashes, h[:])
+	}
+	return hashes, nil
+}
+
+func (f *fakeServer) Consistency(old, root string) (*merkletree.ConsistencyProof, error) {
+	return f.tree[root].ConsistencyProof(f.batches[old].Total)
+}
+
+func TestUploader(t *testing.T) {
+	server := newFakeServer()
 	uploader := Uploader{
 		server: server,
 		fileHandler: &fakeFileHandler{
@@ -121,4 +326,365 @@ func TestUploader(t *testing.T) {
 	if err := uploader.Download(root, 1); err != nil {
 		t.Error(err)
 	}
+	if err := uploader.Download(root, 1, 0); err != nil {
+		t.Error(err)
+	}
+
+	server.store[root+"1"] = []byte("c")
+	if err := uploader.Download(root, 0, 1); err == nil {
+		t.Error("download of a modified file should fail")
+	}
+}
+
+func TestUploaderResume(t *testing.T) {
+	server := newFakeServer()
+	uploader := Uploader{
+		server: server,
+		fileHandler: &fakeFileHandler{
+			saved: make(map[string][]byte),
+		},
+	}
+	paths := []string{"a", "b", "c"}
+	server.failAt[1] = fmt.Errorf("connection lost")
+	if _, err := uploader.Upload(paths); err == nil {
+		t.Fatal("upload should fail")
+	}
+	roots, err := uploader.getRoots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := roots[len(roots)-1]
+
+	status, err := server.Status(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := status.Received, []int{0}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	delete(server.failAt, 1)
+	server.failAt[0] = fmt.Errorf("index 0 must not be uploaded again")
+	if err := uploader.Resume(root, paths); err != nil {
+		t.Fatal(err)
+	}
+	for i := range paths {
+		if err := uploader.Download(root, i); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestUploaderBlocks(t *testing.T) {
+	server := newFakeServer()
+	handler := &fakeFileHandler{saved: make(map[string][]byte)}
+	uploader := NewUploader(handler, server, WithBlockSize(3))
+	root, err := uploader.Upload([]string{"abcdefghij", "k"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.strict = true
+
+	server.failBlock[2] = fmt.Errorf("connection lost")
+	if err := uploader.DownloadBlocks(root, 0); err == nil {
+		t.Fatal("download should fail")
+	}
+	if _, exist := handler.saved[root+"/0"]; exist {
+		t.Fatal("an incomplete file should not be saved")
+	}
+
+	delete(server.failBlock, 2)
+	server.failBlock[0] = fmt.Errorf("block 0 must not be downloaded again")
+	server.failBlock[1] = fmt.Errorf("block 1 must not be downloaded again")
+	if err := uploader.DownloadBlocks(root, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(handler.saved[root+"/0"]), "abcdefghij"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for path := range handler.saved {
+		if strings.HasPrefix(path, root+"/0.blocks") {
+			t.Errorf("%s should be deleted", path)
+		}
+	}
+
+	clear(server.failBlock)
+	server.store[root+"1"] = []byte("x")
+	if err := uploader.DownloadBlocks(root, 1); err == nil {
+		t.Fatal("a block the root does not prove should not be downloaded")
+	}
+	if _, exist := handler.saved[root+"/1.blocks/0"]; exist {
+		t.Error("a block the root does not prove should not be kept")
+	}
+}
+
+func TestUploaderStored(t *testing.T) {
+	for _, options := range [][]Option{nil, {WithNames()}, {WithMetadata()}} {
+		server := newFakeServer()
+		handler := &fakeFileHandler{saved: make(map[string][]byte)}
+		uploader := NewUploader(handler, server, options...)
+		if _, err := uploader.Upload([]string{"a", "b"}); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := server.stored, 0; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+
+		// only c is sent, a and b are already stored
+		handler.opened.Store(0)
+		root, err := uploader.Upload([]string{"b", "c", "a"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := server.stored, 2; got != want {
+			t.Errorf("got %v stored files, want %v", got, want)
+		}
+		// every file is hashed once and c sent, along with the file of roots
+		// read once, the metadata of the files is read apart
+		if got, want := handler.opened.Load(), int32(5); !uploader.metadata && got != want {
+			t.Errorf("got %v opened files, want %v", got, want)
+		}
+		if uploader.named {
+			err = uploader.DownloadNamed(root, "a", "b", "c")
+		} else {
+			err = uploader.Download(root, 0, 1, 2)
+		}
+		if err != nil {
+			t.Error(err)
+		}
+
+		// files the server no longer stores are sent again
+		server.forget = true
+		if _, err := uploader.Upload([]string{"c", "a"}); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := server.stored, 2; got != want {
+			t.Errorf("got %v stored files, want %v", got, want)
+		}
+	}
+}
+
+func TestVerifyFile(t *testing.T) {
+	server := newFakeServer()
+	uploader := Uploader{
+		server: server,
+		fileHandler: &fakeFileHandler{
+			saved: make(map[string][]byte),
+		},
+	}
+	root, err := uploader.Upload([]string{"a", "b", "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, proof, err := server.Request(root, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := proof.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded merkletree.Proof
+	if err := decoded.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := VerifyFile(root, &decoded, strings.NewReader("b"), 0); err != nil {
+		t.Error(err)
+	}
+	if err := VerifyFile(root, &decoded, strings.NewReader("a"), 0); err == nil {
+		t.Error("another file should not verify")
+	}
+	if err := VerifyFile(root, &decoded, strings.NewReader("b"), 1); err == nil {
+		t.Error("file should not verify with another block size")
+	}
+}
+
+func TestUploaderAppend(t *testing.T) {
+	server := newFakeServer()
+	uploader := Uploader{
+		server: server,
+		fileHandler: &fakeFileHandler{
+			saved: make(map[string][]byte),
+		},
+	}
+	root, err := uploader.Upload([]string{"a", "b", "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	extended, err := uploader.Append(root, []string{"d", "e"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, _, err := Uploader{server: newFakeServer(), fileHandler: &fakeFileHandler{saved: make(map[string][]byte)}}.root([]string{"a", "b", "c", "d", "e"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := extended, expected; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if err := uploader.VerifyConsistency(root, extended); err != nil {
+		t.Error(err)
+	}
+	if err := uploader.Download(extended, 1, 4); err != nil {
+		t.Error(err)
+	}
+
+	server.tree[extended], _ = merkletree.From([][]byte{[]byte("forged")})
+	if err := uploader.VerifyConsistency(root, extended); err == nil {
+		t.Error("consistency of another tree should fail")
+	}
+}
+
+func TestUploaderNames(t *testing.T) {
+	server := newFakeServer()
+	handler := &fakeFileHandler{saved: make(map[string][]byte)}
+	uploader := NewUploader(handler, server, WithNames())
+	paths := []string{"a.txt", "dir/b.txt"}
+	root, err := uploader.Upload(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !server.batches[root].Named {
+		t.Fatal("batch should be named")
+	}
+	entries := make(map[string][]byte)
+	for _, path := range paths {
+		h := sha256.Sum256([]byte(path))
+		entries[path] = h[:]
+	}
+	tree, err := merkletree.NewSparse(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := root, hex.EncodeToString(tree.Root()); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if err := uploader.DownloadNamed(root, "dir/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(handler.saved[root+"/dir/b.txt"]), "dir/b.txt"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if err := uploader.DownloadNamed(root, "missing.txt"); !errors.Is(err, ErrAbsent) {
+		t.Errorf("got %v, want %v", err, ErrAbsent)
+	}
+	if err := uploader.DownloadNamed(root, "../a.txt"); err == nil {
+		t.Error("name out of the download directory should fail")
+	}
+	if _, err := uploader.Append(root, []string{"c.txt"}); err == nil {
+		t.Error("append to a named batch should fail")
+	}
+
+	server.names[root]["a.txt"] = []byte("forged")
+	if err := uploader.DownloadNamed(root, "a.txt"); err == nil {
+		t.Error("download of a modified file should fail")
+	}
+	if _, err := uploader.Upload([]string{"/abs.txt"}); err == nil {
+		t.Error("upload of an absolute path should fail")
+	}
+}
+
+func TestUploaderDir(t *testing.T) {
+	server := newFakeServer()
+	handler := &fakeFileHandler{saved: make(map[string][]byte)}
+	uploader := NewUploader(handler, server)
+	fsys := fstest.MapFS{
+		"a.txt":       {Data: []byte("dir/a.txt"), Mode: 0o600},
+		"sub/b.txt":   {Data: []byte("dir/sub/b.txt"), Mode: 0o755},
+		"sub/c/d.txt": {Data: []byte("dir/sub/c/d.txt"), Mode: 0o644},
+		"link":        {Data: []byte("a.txt"), Mode: fs.ModeSymlink},
+	}
+	manifest, err := NewManifest(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, entry := range manifest.Files {
+		paths = append(paths, entry.Path)
+	}
+	if got, want := paths, []string{"a.txt", "sub/b.txt", "sub/c/d.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := manifest.Files[1], (ManifestEntry{Path: "sub/b.txt", Size: 13, Mode: 0o755}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	root, err := uploader.UploadDir("dir", manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !server.batches[root].Manifest {
+		t.Fatal("batch should have a manifest")
+	}
+	if err := uploader.DownloadDir(root); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		if got, want := string(handler.saved[root+"/"+path]), "dir/"+path; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+	if _, err := uploader.Append(root, []string{"e.txt"}); err == nil {
+		t.Error("append to a batch with a manifest should fail")
+	}
+
+	server.store[root+"1"] = []byte("dir/sub/x.txt")
+	if err := uploader.DownloadDir(root); err == nil {
+		t.Error("download of a modified file should fail")
+	}
+	server.store[root+"3"] = []byte(`{"files":[]}`)
+	if err := uploader.DownloadDir(root); err == nil {
+		t.Error("download with a modified manifest should fail")
+	}
+
+	for _, path := range []string{"../a", "/a", "a/../b", ""} {
+		invalid := Manifest{Files: []ManifestEntry{{Path: path}}}
+		if _, err := uploader.UploadDir("dir", invalid); err == nil {
+			t.Errorf("upload of %q should fail", path)
+		}
+	}
+}
+
+func TestUploaderMetadata(t *testing.T) {
+	fake := newFakeServer()
+	uploader := NewUploader(&fakeFileHandler{saved: make(map[string][]byte)}, fake, WithMetadata())
+	paths := []string{"dir/a.txt", "b.json"}
+	root, err := uploader.Upload(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []server.FileInfo{
+		{Name: "a.txt", Size: 9, ContentType: mime.TypeByExtension(".txt")},
+		{Name: "b.json", Size: 6, ContentType: mime.TypeByExtension(".json")},
+	}
+	if got, want := fake.infos[root], expected; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	plain, _, err := Uploader{server: newFakeServer(), fileHandler: &fakeFileHandler{saved: make(map[string][]byte)}}.root(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root == plain {
+		t.Error("metadata should change the root")
+	}
+
+	if err := uploader.Download(root, 0); err != nil {
+		t.Error(err)
+	}
+	if err := uploader.Download(root, 0, 1); err != nil {
+		t.Error(err)
+	}
+	fake.infos[root][1].ContentType = "text/html"
+	if err := uploader.Download(root, 1); err == nil {
+		t.Error("download with modified metadata should fail")
+	}
+	if err := uploader.Download(root, 0, 1); err == nil {
+		t.Error("download with modified metadata should fail")
+	}
+	if _, err := NewUploader(&fakeFileHandler{saved: make(map[string][]byte)}, fake, WithMetadata(), WithNames()).Upload(paths); err == nil {
+		t.Error("named batches should not keep metadata")
+	}
 }
@@ -0,0 +1,67 @@
+package client
+
+import (
+	"fmt"
+	"io/fs"
+)
+
+// Manifest describes the layout of a directory uploaded with UploadDir. It is
+// uploaded as the last file of the batch, so the root commits to it and a
+// download restores the directory from it.
+type Manifest struct {
+	Files []ManifestEntry `json:"files"`
+}
+
+// ManifestEntry describes the file at the same index of the batch. Path is
+// slash separated and relative to the directory, Leaf is the hex leaf hash
+// of the file in the tree of the batch.
+type ManifestEntry struct {
+	Path string      `json:"path"`
+	Size int64       `json:"size"`
+	Mode fs.FileMode `json:"mode"`
+	Leaf string      `json:"leaf,omitempty"`
+}
+
+// NewManifest lists the regular files of fsys in lexical order, other files
+// such as symbolic links are skipped. The leaf hashes are computed on upload.
+func NewManifest(fsys fs.FS) (Manifest, error) {
+	manifest := Manifest{Files: []ManifestEntry{}}
+	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, ManifestEntry{
+			Path: path,
+			Size: info.Size(),
+			Mode: info.Mode().Perm(),
+		})
+		return nil
+	})
+	if err != nil {
+		return Manifest{}, err
+	}
+	return manifest, nil
+}
+
+// validate checks that every path is a clean relative path given once, so a
+// download stays in its directory.
+func (manifest Manifest) validate() error {
+	seen := make(map[string]bool, len(manifest.Files))
+	for _, entry := range manifest.Files {
+		if !fs.ValidPath(entry.Path) || entry.Path == "." {
+			return fmt.Errorf("invalid path %q", entry.Path)
+		}
+		if seen[entry.Path] {
+			return fmt.Errorf("path %s listed twice", entry.Path)
+		}
+		if entry.Size < 0 || entry.Mode != entry.Mode.Perm() {
+			return fmt.Errorf("invalid size or mode of %s", entry.Path)
+		}
+		seen[entry.Path] = true
+	}
+	return nil
+}
@@ -1,10 +1,17 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"os"
 	"strconv"
 
 	"github.com/spf13/cobra"
+
+	"github.com/tclairet/merklestore/client"
+	"github.com/tclairet/merklestore/merkletree"
+	"github.com/tclairet/merklestore/server"
 )
 
 var rootCmd = &cobra.Command{
@@ -17,13 +24,87 @@ var rootCmd = &cobra.Command{
 
 var (
 	uploadCmd = &cobra.Command{
-		Use:   "upload [FILES]",
+		Use:   "upload [FILES | -r DIR]",
 		Short: "Upload set of files",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			client, err := MerkleStoreClient()
+			blockSize, err := cmd.Flags().GetInt("block-size")
+			if err != nil {
+				return err
+			}
+			resume, err := cmd.Flags().GetString("resume")
+			if err != nil {
+				return err
+			}
+			hash, err := cmd.Flags().GetString("hash")
+			if err != nil {
+				return err
+			}
+			oddNode, err := cmd.Flags().GetString("odd-node")
+			if err != nil {
+				return err
+			}
+			parent, err := cmd.Flags().GetString("append")
+			if err != nil {
+				return err
+			}
+			named, err := cmd.Flags().GetBool("names")
+			if err != nil {
+				return err
+			}
+			recursive, err := cmd.Flags().GetBool("recursive")
+			if err != nil {
+				return err
+			}
+			metadata, err := cmd.Flags().GetBool("metadata")
+			if err != nil {
+				return err
+			}
+			var manifest client.Manifest
+			if recursive {
+				if len(args) != 1 {
+					return fmt.Errorf("you must provide the directory to upload")
+				}
+				if manifest, err = client.NewManifest(os.DirFS(args[0])); err != nil {
+					return err
+				}
+			}
+			options := []client.Option{client.WithBlockSize(blockSize), client.WithHash(hash), client.WithOddNode(merkletree.OddNode(oddNode))}
+			if named {
+				options = append(options, client.WithNames())
+			}
+			if metadata {
+				options = append(options, client.WithMetadata())
+			}
+			client, err := MerkleStoreClient(options...)
 			if err != nil {
 				return err
 			}
+			if resume != "" {
+				if err := client.Resume(resume, args); err != nil {
+					return err
+				}
+				fmt.Println("Upload resumed with success")
+				fmt.Println("Merkle Root:", resume)
+				return nil
+			}
+			if recursive {
+				root, err := client.UploadDir(args[0], manifest)
+				if err != nil {
+					return err
+				}
+				fmt.Println("Directory Upload with success")
+				fmt.Println("Merkle Root:", root)
+				return nil
+			}
+			if parent != "" {
+				root, err := client.Append(parent, args)
+				if err != nil {
+					return err
+				}
+				fmt.Println("Files appended with success")
+				fmt.Println("Merkle Root:", root)
+				return nil
+			}
 			root, err := client.Upload(args)
 			if err != nil {
 				return err
@@ -36,16 +117,46 @@ var (
 	}
 
 	downloadCmd = &cobra.Command{
-		Use:   "download ROOT_HASH [FILES_INDEX]",
+		Use:   "download ROOT_HASH [FILES_INDEX | FILES_NAME]",
 		Short: "Download the i file",
+		Long:  "Download files by index, or by name from a batch uploaded with --names. A name the batch does not hold is reported once its absence is proven. Without files, the directory uploaded with upload -r is restored under ROOT_HASH",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			client, err := MerkleStoreClient()
 			if err != nil {
 				return err
 			}
-			if len(args) < 2 {
+			if len(args) == 0 {
 				return fmt.Errorf("you must provide the root hash and indexes of the files you want to download")
 			}
+			serverClient, err := MerkleStoreServer()
+			if err != nil {
+				return err
+			}
+			batch, err := serverClient.Batch(args[0])
+			if err != nil {
+				return err
+			}
+			if len(args) == 1 {
+				if !batch.Manifest {
+					return fmt.Errorf("you must provide the indexes of the files you want to download")
+				}
+				if err := client.DownloadDir(args[0]); err != nil {
+					return err
+				}
+				fmt.Println("Directory Download with success")
+				fmt.Printf("\t%s\n", args[0])
+				return nil
+			}
+			if batch.Named {
+				if err := client.DownloadNamed(args[0], args[1:]...); err != nil {
+					return err
+				}
+				fmt.Println("Files Download with success")
+				for _, name := range args[1:] {
+					fmt.Printf("\t%s/%s\n", args[0], name)
+				}
+				return nil
+			}
 			var indexes []int
 			for i := 1; i < len(args); i++ {
 				index, err := strconv.ParseInt(args[i], 10, 10)
@@ -54,7 +165,17 @@ var (
 				}
 				indexes = append(indexes, int(index))
 			}
-			if err := client.Download(args[0], indexes...); err != nil {
+			blocks, err := cmd.Flags().GetBool("blocks")
+			if err != nil {
+				return err
+			}
+			if blocks {
+				for _, index := range indexes {
+					if err := client.DownloadBlocks(args[0], index); err != nil {
+						return fmt.Errorf("index %d: %w", index, err)
+					}
+				}
+			} else if err := client.Download(args[0], indexes...); err != nil {
 				return err
 			}
 			fmt.Println("Files Download with success")
@@ -64,4 +185,126 @@ var (
 			return nil
 		},
 	}
+
+	listCmd = &cobra.Command{
+		Use:   "list ROOT_HASH",
+		Short: "List the files of a batch uploaded with --metadata or --names",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			serverClient, err := MerkleStoreServer()
+			if err != nil {
+				return err
+			}
+			infos, err := serverClient.Infos(args[0])
+			if err != nil {
+				return err
+			}
+			for i, info := range infos {
+				b, err := json.Marshal(info)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("%d\t%s\n", i, b)
+			}
+			return nil
+		},
+	}
+
+	deleteCmd = &cobra.Command{
+		Use:   "delete ROOT_HASH",
+		Short: "Delete a batch, the files it shares with other batches are kept",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			serverClient, err := MerkleStoreServer()
+			if err != nil {
+				return err
+			}
+			return serverClient.Delete(args[0])
+		},
+	}
+
+	statsCmd = &cobra.Command{
+		Use:   "stats",
+		Short: "Show how many files the server stores and how many blobs hold them",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			serverClient, err := MerkleStoreServer()
+			if err != nil {
+				return err
+			}
+			stats, err := serverClient.Stats()
+			if err != nil {
+				return err
+			}
+			fmt.Printf("files\t%d\t%d bytes\n", stats.Files, stats.FileBytes)
+			fmt.Printf("blobs\t%d\t%d bytes\n", stats.Blobs, stats.Bytes)
+			return nil
+		},
+	}
+
+	verifyConsistencyCmd = &cobra.Command{
+		Use:   "verify-consistency OLD_ROOT_HASH ROOT_HASH",
+		Short: "Verify that a batch only appended files to an older one",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := MerkleStoreClient()
+			if err != nil {
+				return err
+			}
+			if err := client.VerifyConsistency(args[0], args[1]); err != nil {
+				return err
+			}
+			fmt.Printf("%s extends %s\n", args[1], args[0])
+			return nil
+		},
+	}
+
+	verifyProofCmd = &cobra.Command{
+		Use:   "verify-proof ROOT_HASH PROOF FILE",
+		Short: "Verify offline that a file belongs to a root",
+		Long:  "Verify offline that FILE is proven under ROOT_HASH by PROOF, a proof in its JSON or binary encoding as served by /roots/ROOT/files/INDEX/proof",
+		Args:  cobra.ExactArgs(3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			blockSize, err := cmd.Flags().GetInt("block-size")
+			if err != nil {
+				return err
+			}
+			encodedInfo, err := cmd.Flags().GetString("info")
+			if err != nil {
+				return err
+			}
+			b, err := os.ReadFile(args[1])
+			if err != nil {
+				return err
+			}
+			var proof merkletree.Proof
+			if trimmed := bytes.TrimSpace(b); len(trimmed) > 0 && trimmed[0] == '{' {
+				err = json.Unmarshal(trimmed, &proof)
+			} else {
+				err = proof.UnmarshalBinary(b)
+			}
+			if err != nil {
+				return fmt.Errorf("invalid proof: %w", err)
+			}
+			file, err := os.Open(args[2])
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			if encodedInfo != "" {
+				var info server.FileInfo
+				if err := json.Unmarshal([]byte(encodedInfo), &info); err != nil {
+					return fmt.Errorf("invalid info: %w", err)
+				}
+				err = client.VerifyFileWithInfo(args[0], &proof, file, blockSize, info)
+			} else {
+				err = client.VerifyFile(args[0], &proof, file, blockSize)
+			}
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s is file %d of %d under %s\n", args[2], proof.Index(), proof.Size(), args[0])
+			return nil
+		},
+	}
 )
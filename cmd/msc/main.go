@@ -3,9 +3,11 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/tclairet/merklestore/client"
 	"github.com/tclairet/merklestore/files"
+	"github.com/tclairet/merklestore/merkletree"
 	"github.com/tclairet/merklestore/server"
 )
 
@@ -18,16 +20,48 @@ var (
 func init() {
 	rootCmd.PersistentFlags().StringVar(&merkleStoreServerEnvFlag, "server", envMerkleStoreServer, "MerkleStoreServer url")
 
-	rootCmd.AddCommand(uploadCmd, downloadCmd)
+	uploadCmd.Flags().Int("block-size", 0, "split files in blocks of this many bytes, 0 hashes whole files")
+	uploadCmd.Flags().String("hash", merkletree.SHA256, fmt.Sprintf("hash algorithm of the batch, one of %s", strings.Join(merkletree.HashNames(), ", ")))
+	uploadCmd.Flags().String("odd-node", string(merkletree.Promote), fmt.Sprintf("handling of the last node of odd tree levels, one of %s", oddNodes()))
+	uploadCmd.Flags().String("resume", "", "root of an interrupted upload, only the files the server is missing are sent, not with -r as the uploaded files are deleted and the manifest of the directory cannot be rebuilt")
+	uploadCmd.Flags().String("append", "", "root of a stored batch the files are appended to, its block size, hash and odd node handling are kept")
+	uploadCmd.Flags().Bool("names", false, "address the files by their path instead of their index, the paths must be relative")
+	uploadCmd.Flags().BoolP("recursive", "r", false, "upload the directory given as only argument with a manifest of its layout, restored by download ROOT_HASH")
+	uploadCmd.MarkFlagsMutuallyExclusive("resume", "append")
+	uploadCmd.MarkFlagsMutuallyExclusive("names", "append")
+	uploadCmd.Flags().Bool("metadata", false, "commit the name, size, content type and modification time of every file in its leaf")
+	uploadCmd.MarkFlagsMutuallyExclusive("recursive", "resume", "append", "names")
+	uploadCmd.MarkFlagsMutuallyExclusive("metadata", "names", "recursive")
+
+	downloadCmd.Flags().Bool("blocks", false, "download the files of a batch uploaded with --block-size one proven block at a time, resuming an interrupted download")
+
+	verifyProofCmd.Flags().Int("block-size", 0, "block size of the batch when its files were split in blocks")
+	verifyProofCmd.Flags().String("info", "", "JSON metadata of the file as listed by list ROOT_HASH, for batches uploaded with --metadata")
+
+	rootCmd.AddCommand(uploadCmd, downloadCmd, listCmd, deleteCmd, statsCmd, verifyProofCmd, verifyConsistencyCmd)
+}
+
+func oddNodes() string {
+	var names []string
+	for _, strategy := range merkletree.OddNodes() {
+		names = append(names, string(strategy))
+	}
+	return strings.Join(names, ", ")
+}
+
+func MerkleStoreClient(options ...client.Option) (*client.Uploader, error) {
+	serverClient, err := MerkleStoreServer()
+	if err != nil {
+		return nil, err
+	}
+	return client.NewUploader(files.OS{}, serverClient, options...), nil
 }
 
-func MerkleStoreClient() (*client.Uploader, error) {
-	fileHandler := files.OS{}
+func MerkleStoreServer() (server.Client, error) {
 	if merkleStoreServerEnvFlag == "" {
-		return nil, fmt.Errorf("--server not provided or MERKLE_STORE_SERVER env variable not set")
+		return server.Client{}, fmt.Errorf("--server not provided or MERKLE_STORE_SERVER env variable not set")
 	}
-	serverClient := server.NewClient(merkleStoreServerEnvFlag)
-	return client.NewUploader(fileHandler, serverClient), nil
+	return server.NewClient(merkleStoreServerEnvFlag), nil
 }
 
 func main() {
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -13,17 +15,44 @@ import (
 	"github.com/tclairet/merklestore/server"
 )
 
+var (
+	dbPath = flag.String("db", "merkle.db", "path of the metadata database")
+	expire = flag.Duration("expire", 24*time.Hour, "delete the partial batches receiving no file for this long, 0 keeps them")
+)
+
 func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-db PATH] [-expire DURATION] [migrate]\n\n", os.Args[0])
+		fmt.Fprintln(flag.CommandLine.Output(), "migrate imports backup.json into the metadata database and exits")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
 	fileHandler := files.OS{}
-	store, err := server.NewJsonStore(fileHandler)
+	store, err := server.NewBoltStore(*dbPath)
 	if err != nil {
 		panic(err)
 	}
-	s, err := server.New(fileHandler, store)
+	defer store.Close()
+
+	if flag.Arg(0) == "migrate" {
+		if err := migrate(fileHandler, store); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if _, err := os.Stat("backup.json"); err == nil {
+		log.Println("backup.json is no longer read, run 'server migrate' to import it")
+	}
+
+	s, err := server.New(context.Background(), fileHandler, store)
 	if err != nil {
 		panic(err)
 	}
 	api := server.NewAPI(s)
+	if *expire > 0 {
+		go expirePending(s, *expire)
+	}
 
 	server := &http.Server{Addr: "0.0.0.0:3333", Handler: api.Routes()}
 	serverCtx, serverStopCtx := context.WithCancel(context.Background())
@@ -35,7 +64,8 @@ func main() {
 		<-sig
 
 		// Shutdown signal with grace period of 30 seconds
-		shutdownCtx, _ := context.WithTimeout(serverCtx, 30*time.Second)
+		shutdownCtx, cancel := context.WithTimeout(serverCtx, 30*time.Second)
+		defer cancel()
 
 		go func() {
 			<-shutdownCtx.Done()
@@ -61,3 +91,31 @@ func main() {
 	// Wait for server context to be stopped
 	<-serverCtx.Done()
 }
+
+// expirePending deletes the partial batches idle for longer than idle, checked
+// every hour or every idle when shorter.
+func expirePending(s *server.Server, idle time.Duration) {
+	ticker := time.NewTicker(min(idle, time.Hour))
+	defer ticker.Stop()
+	for range ticker.C {
+		if _, err := s.Expire(context.Background(), idle); err != nil {
+			log.Println("cannot expire partial batches:", err)
+		}
+	}
+}
+
+func migrate(fileHandler files.Handler, store *server.BoltStore) error {
+	if _, err := os.Stat("backup.json"); err != nil {
+		return err
+	}
+	backup, err := server.NewJsonStore(fileHandler)
+	if err != nil {
+		return err
+	}
+	count, err := store.Import(context.Background(), backup)
+	if err != nil {
+		return err
+	}
+	log.Printf("imported %d roots from backup.json into %s", count, *dbPath)
+	return nil
+}
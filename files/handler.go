@@ -2,6 +2,7 @@ package files
 
 import (
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 )
@@ -12,6 +13,39 @@ type Handler interface {
 	Save(name string, content io.Reader) error
 }
 
+// Chmoder is implemented by the handlers able to change the mode of a saved
+// file.
+type Chmoder interface {
+	Chmod(path string, mode fs.FileMode) error
+}
+
+// Stater is implemented by the handlers able to describe a file.
+type Stater interface {
+	Stat(path string) (fs.FileInfo, error)
+}
+
+// Renamer is implemented by the handlers able to move a saved file.
+type Renamer interface {
+	Rename(from, to string) error
+}
+
+// Move moves the file at from to to, by copy when handler is not a Renamer.
+func Move(handler Handler, from, to string) error {
+	if renamer, ok := handler.(Renamer); ok {
+		return renamer.Rename(from, to)
+	}
+	file, err := handler.Open(from)
+	if err != nil {
+		return err
+	}
+	err = handler.Save(to, file)
+	file.Close()
+	if err != nil {
+		return err
+	}
+	return handler.Delete(from)
+}
+
 type OS struct{}
 
 func (OS) Open(path string) (io.ReadCloser, error) {
@@ -36,3 +70,18 @@ func (OS) Save(name string, content io.Reader) error {
 	}
 	return nil
 }
+
+func (OS) Chmod(path string, mode fs.FileMode) error {
+	return os.Chmod(path, mode)
+}
+
+func (OS) Rename(from, to string) error {
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+	return os.Rename(from, to)
+}
+
+func (OS) Stat(path string) (fs.FileInfo, error) {
+	return os.Stat(path)
+}
@@ -6,9 +6,14 @@ require (
 	github.com/go-chi/chi/v5 v5.0.11
 	github.com/go-chi/httplog/v2 v2.0.9
 	github.com/spf13/cobra v1.8.0
+	go.etcd.io/bbolt v1.3.10
+	golang.org/x/crypto v0.21.0
+	lukechampine.com/blake3 v1.2.1
 )
 
 require (
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
+	github.com/klauspost/cpuid/v2 v2.0.9 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
+	golang.org/x/sys v0.18.0 // indirect
 )
@@ -0,0 +1,115 @@
+package merkletree
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+const benchLeaves = 1 << 20
+
+func benchHashes(n int) [][]byte {
+	hashes := make([][]byte, n)
+	for i := range hashes {
+		h := sha256.Sum256(binary.AppendUvarint(nil, uint64(i)))
+		hashes[i] = h[:]
+	}
+	return hashes
+}
+
+func benchWorkers(b *testing.B, bench func(b *testing.B, workers int)) {
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			bench(b, workers)
+		})
+	}
+}
+
+func BenchmarkFromHashes(b *testing.B) {
+	hashes := benchHashes(benchLeaves)
+	benchWorkers(b, func(b *testing.B, workers int) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := FromHashes(hashes, WithWorkers(workers)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkFrom(b *testing.B) {
+	inputs := benchHashes(benchLeaves)
+	benchWorkers(b, func(b *testing.B, workers int) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := From(inputs, WithWorkers(workers)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkIndexedBuilder(b *testing.B) {
+	content := make([]byte, 4096)
+	benchWorkers(b, func(b *testing.B, workers int) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			builder := NewIndexedBuilder(benchLeaves/16, WithWorkers(workers))
+			err := builder.AddAll(func(int) ([]byte, error) {
+				h := sha256.Sum256(content)
+				return h[:], nil
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := builder.Build(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkAppend(b *testing.B) {
+	hashes := benchHashes(benchLeaves + b.N)
+	tree, err := FromHashes(hashes[:benchLeaves])
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := tree.Append(hashes[benchLeaves+i]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkProofForIndex(b *testing.B) {
+	tree, err := FromHashes(benchHashes(benchLeaves))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := tree.ProofForIndex(i % benchLeaves); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkProofFor(b *testing.B) {
+	hashes := benchHashes(benchLeaves)
+	tree, err := FromHashes(hashes)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := tree.ProofFor(hashes[i%len(hashes)]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
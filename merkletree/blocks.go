@@ -0,0 +1,100 @@
+package merkletree
+
+import (
+	"fmt"
+	"hash"
+	"io"
+)
+
+// BlockHasher is an io.Writer splitting everything written to it in blocks of
+// blockSize bytes. Every block becomes an indexed leaf of a tree whose root is
+// used as the file hash in chunked batches.
+type BlockHasher struct {
+	blockSize int
+	opts      options
+
+	current hash.Hash
+	written int
+	hashes  [][]byte
+}
+
+func NewBlockHasher(blockSize int, opts ...Option) *BlockHasher {
+	return &BlockHasher{
+		blockSize: blockSize,
+		opts:      newOptions(opts),
+	}
+}
+
+func (b *BlockHasher) Write(p []byte) (int, error) {
+	if b.blockSize <= 0 {
+		return 0, fmt.Errorf("invalid block size %d", b.blockSize)
+	}
+	n := len(p)
+	for len(p) > 0 {
+		if b.current == nil {
+			b.current = b.opts.newHash()
+			b.written = 0
+		}
+		chunk := p
+		if remaining := b.blockSize - b.written; len(chunk) > remaining {
+			chunk = chunk[:remaining]
+		}
+		b.current.Write(chunk)
+		b.written += len(chunk)
+		p = p[len(chunk):]
+		if b.written == b.blockSize {
+			b.flush()
+		}
+	}
+	return n, nil
+}
+
+func (b *BlockHasher) flush() {
+	b.hashes = append(b.hashes, b.current.Sum(nil))
+	b.current = nil
+}
+
+// Tree builds the tree of every block written so far. An empty input is made
+// of a single empty block.
+func (b *BlockHasher) Tree() (*MerkleTree, error) {
+	if b.current != nil || len(b.hashes) == 0 {
+		if b.current == nil {
+			b.current = b.opts.newHash()
+		}
+		b.flush()
+	}
+	return BlockTreeOf(b.hashes, b.opts.option())
+}
+
+// Hashes returns the hash of every block of the tree built by Tree.
+func (b *BlockHasher) Hashes() [][]byte {
+	return b.hashes
+}
+
+// BlockTreeOf returns the block tree of a file whose blocks hash to hashes.
+func BlockTreeOf(hashes [][]byte, opts ...Option) (*MerkleTree, error) {
+	builder := NewIndexedBuilder(len(hashes), opts...)
+	for i, h := range hashes {
+		if _, err := builder.AddHash(i, h); err != nil {
+			return nil, err
+		}
+	}
+	return builder.Build()
+}
+
+// BlockTree reads input until EOF and returns its block tree.
+func BlockTree(input io.Reader, blockSize int, opts ...Option) (*MerkleTree, error) {
+	hasher := NewBlockHasher(blockSize, opts...)
+	if _, err := io.Copy(hasher, input); err != nil {
+		return nil, err
+	}
+	return hasher.Tree()
+}
+
+// BlockCount returns the number of blocks of a file of the given size.
+func BlockCount(size int64, blockSize int) int {
+	if size == 0 {
+		return 1
+	}
+	return int((size + int64(blockSize) - 1) / int64(blockSize))
+}
@@ -0,0 +1,100 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestBlockHasher(t *testing.T) {
+	t.Run("blocks", func(t *testing.T) {
+		cases := []struct {
+			input     string
+			blockSize int
+			expected  []string
+		}{
+			{input: "", blockSize: 2, expected: []string{""}},
+			{input: "a", blockSize: 2, expected: []string{"a"}},
+			{input: "ab", blockSize: 2, expected: []string{"ab"}},
+			{input: "abc", blockSize: 2, expected: []string{"ab", "c"}},
+			{input: "abcdefg", blockSize: 3, expected: []string{"abc", "def", "g"}},
+		}
+
+		for _, c := range cases {
+			t.Run(fmt.Sprintf("%s by %d", c.input, c.blockSize), func(t *testing.T) {
+				tree, err := BlockTree(strings.NewReader(c.input), c.blockSize)
+				if err != nil {
+					t.Fatal(err)
+				}
+				builder := NewIndexedBuilder(len(c.expected))
+				for i, block := range c.expected {
+					if _, err := builder.Add(i, strings.NewReader(block)); err != nil {
+						t.Fatal(err)
+					}
+				}
+				expected, err := builder.Build()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, want := tree.Root(), expected.Root(); !bytes.Equal(got, want) {
+					t.Fatalf("got %x, want %x", got, want)
+				}
+				if got, want := BlockCount(int64(len(c.input)), c.blockSize), len(c.expected); got != want {
+					t.Fatalf("got %v, want %v", got, want)
+				}
+			})
+		}
+	})
+
+	t.Run("independent of writes", func(t *testing.T) {
+		input := bytes.Repeat([]byte("0123456789"), 100)
+		expected, err := BlockTree(bytes.NewReader(input), 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, writeSize := range []int{1, 7, 64, 65, 1000} {
+			hasher := NewBlockHasher(64)
+			if _, err := io.CopyBuffer(hasher, onlyReader{bytes.NewReader(input)}, make([]byte, writeSize)); err != nil {
+				t.Fatal(err)
+			}
+			tree, err := hasher.Tree()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := tree.Root(), expected.Root(); !bytes.Equal(got, want) {
+				t.Fatalf("write size %d: got %x, want %x", writeSize, got, want)
+			}
+		}
+	})
+
+	t.Run("block proof", func(t *testing.T) {
+		tree, err := BlockTree(strings.NewReader("abcdefg"), 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := sha256.Sum256([]byte("def"))
+		leaf := LeafHash(1, h[:])
+		proof, err := tree.ProofForIndex(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := proof.Verify(leaf, tree.Root()); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("invalid block size", func(t *testing.T) {
+		if _, err := BlockTree(strings.NewReader("a"), 0); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
+// onlyReader hides the WriterTo implementation of the wrapped reader so that
+// io.CopyBuffer uses the provided buffer size.
+type onlyReader struct {
+	io.Reader
+}
@@ -1,25 +1,28 @@
 package merkletree
 
 import (
-	"crypto/sha256"
+	"errors"
 	"fmt"
-	"hash"
 	"io"
 	"strconv"
+	"sync"
+	"sync/atomic"
 )
 
 type Builder struct {
 	data [][]byte
+	opts options
 }
 
-func NewBuilder() *Builder {
+func NewBuilder(opts ...Option) *Builder {
 	return &Builder{
 		data: [][]byte{},
+		opts: newOptions(opts),
 	}
 }
 
 func (builder *Builder) Add(input io.Reader) error {
-	h := sha256.New()
+	h := builder.opts.leafHasher()
 	if _, err := io.Copy(h, input); err != nil {
 		return err
 	}
@@ -28,47 +31,96 @@ func (builder *Builder) Add(input io.Reader) error {
 }
 
 func (builder *Builder) Build() (*MerkleTree, error) {
-	return FromHashes(builder.data, sha256.New)
+	return FromHashes(builder.data, builder.opts.option())
 }
 
 type IndexedBuilder struct {
-	data    [][]byte
-	count   int
-	newHash func() hash.Hash
+	data  [][]byte
+	count int
+	opts  options
 }
 
-func NewIndexedBuilder(size int) *IndexedBuilder {
+func NewIndexedBuilder(size int, opts ...Option) *IndexedBuilder {
 	return &IndexedBuilder{
-		data:    make([][]byte, size),
-		newHash: sha256.New,
+		data: make([][]byte, size),
+		opts: newOptions(opts),
 	}
 }
 
 func (builder *IndexedBuilder) Add(index int, input io.Reader) (bool, error) {
-	hasher := builder.newHash()
+	hasher := builder.opts.newHash()
 	if _, err := io.Copy(hasher, input); err != nil {
 		return false, err
 	}
-	if len(builder.data[index]) != 0 {
-		return false, fmt.Errorf("already got hash for this index")
-	}
 	return builder.AddHash(index, hasher.Sum(nil))
 }
 
 func (builder *IndexedBuilder) AddHash(index int, h []byte) (bool, error) {
+	if index < 0 || index >= len(builder.data) {
+		return false, fmt.Errorf("index %d out of range for %d hashes", index, len(builder.data))
+	}
 	if len(builder.data[index]) != 0 {
 		return false, fmt.Errorf("already got hash for this index")
 	}
-	hasher := builder.newHash()
-	hasher.Write([]byte(strconv.Itoa(index)))
-	hasher.Write(h)
-
-	builder.data[index] = hasher.Sum(nil)
+	builder.data[index] = builder.opts.leaf([]byte(strconv.Itoa(index)), h)
 	builder.count++
 
 	return builder.count == len(builder.data), nil
 }
 
+// AddAll adds the hash returned by hash for every index not added yet,
+// calling it from up to as many goroutines as the workers of the tree. It
+// stops at the first error, the hashes computed until then are kept.
+func (builder *IndexedBuilder) AddAll(hash func(index int) ([]byte, error)) error {
+	workers := max(1, min(builder.opts.workers, len(builder.data)))
+	var next, added atomic.Int64
+	errs := make([]error, workers)
+	var failed atomic.Bool
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			hasher := builder.opts.newHash()
+			for !failed.Load() {
+				index := int(next.Add(1) - 1)
+				if index >= len(builder.data) {
+					return
+				}
+				if len(builder.data[index]) != 0 {
+					continue
+				}
+				h, err := hash(index)
+				if err != nil {
+					errs[w] = fmt.Errorf("index %d: %w", index, err)
+					failed.Store(true)
+					return
+				}
+				builder.data[index] = builder.opts.leafWith(hasher, nil, []byte(strconv.Itoa(index)), h)
+				added.Add(1)
+			}
+		}(w)
+	}
+	wg.Wait()
+	builder.count += int(added.Load())
+	return errors.Join(errs...)
+}
+
+// Has reports whether the hash at index was already added.
+func (builder *IndexedBuilder) Has(index int) bool {
+	return index >= 0 && index < len(builder.data) && len(builder.data[index]) != 0
+}
+
+// Count returns the number of hashes added so far.
+func (builder *IndexedBuilder) Count() int {
+	return builder.count
+}
+
 func (builder *IndexedBuilder) Build() (*MerkleTree, error) {
-	return FromHashes(builder.data, builder.newHash)
+	return FromHashes(builder.data, builder.opts.option())
+}
+
+// LeafHash returns the leaf stored in an indexed tree for the hash h at index.
+func LeafHash(index int, h []byte, opts ...Option) []byte {
+	return newOptions(opts).leaf([]byte(strconv.Itoa(index)), h)
 }
@@ -0,0 +1,167 @@
+package merkletree
+
+import (
+	"bytes"
+	"fmt"
+	"math/bits"
+)
+
+// Append adds already hashed leaves at the end of the tree. Trees only grow
+// on their right side so every previous version stays provable with
+// ConsistencyProof.
+func (tree *MerkleTree) Append(hashes ...[]byte) error {
+	if len(tree.levels) == 0 {
+		return fmt.Errorf("merkle tree not initialized")
+	}
+	from := tree.size
+	for _, h := range hashes {
+		tree.levels[0].append(h)
+	}
+	tree.grow(from)
+	return nil
+}
+
+// ConsistencyProof proves that the tree of oldSize leaves is a prefix of the
+// tree of size leaves, as in RFC 6962.
+type ConsistencyProof struct {
+	opts    options
+	oldSize int
+	size    int
+	hashes  [][]byte
+}
+
+// NewConsistencyProof returns the proof that the first oldSize leaves of a
+// tree of size leaves made a tree of their own, opts must match the ones of
+// the tree it comes from.
+func NewConsistencyProof(oldSize, size int, hashes [][]byte, opts ...Option) *ConsistencyProof {
+	return &ConsistencyProof{
+		oldSize: oldSize,
+		size:    size,
+		hashes:  hashes,
+		opts:    newOptions(opts),
+	}
+}
+
+// ConsistencyProof returns the proof that the tree made of the first oldSize
+// leaves is a previous version of tree.
+func (tree *MerkleTree) ConsistencyProof(oldSize int) (*ConsistencyProof, error) {
+	if oldSize <= 0 || oldSize > tree.size {
+		return nil, fmt.Errorf("old size %d out of range for %d leaves", oldSize, tree.size)
+	}
+	if tree.opts.oddNode != Promote {
+		return nil, fmt.Errorf("no consistency proof for trees with the %s odd node strategy", tree.opts.oddNode)
+	}
+	var hashes [][]byte
+	if oldSize < tree.size {
+		hashes = tree.subproof(oldSize, 0, tree.size, true)
+	}
+	return NewConsistencyProof(oldSize, tree.size, hashes, tree.opts.option()), nil
+}
+
+// subproof is SUBPROOF of RFC 6962 for the leaves between start and end.
+func (tree *MerkleTree) subproof(m, start, end int, complete bool) [][]byte {
+	n := end - start
+	if m == n {
+		if complete {
+			return nil
+		}
+		return [][]byte{tree.subtreeHash(start, end)}
+	}
+	k := splitPoint(n)
+	if m <= k {
+		return append(tree.subproof(m, start, start+k, complete), tree.subtreeHash(start+k, end))
+	}
+	return append(tree.subproof(m-k, start+k, end, false), tree.subtreeHash(start, start+k))
+}
+
+// subtreeHash returns the root of the tree made of the leaves between start
+// and end.
+func (tree *MerkleTree) subtreeHash(start, end int) []byte {
+	n := end - start
+	if n&(n-1) == 0 && start%n == 0 {
+		// an aligned subtree of a power of two leaves is a node of the tree
+		return bytes.Clone(tree.levels[bits.TrailingZeros(uint(n))].at(start / n))
+	}
+	k := splitPoint(n)
+	return tree.opts.node(tree.subtreeHash(start, start+k), tree.subtreeHash(start+k, end))
+}
+
+// splitPoint returns the largest power of two smaller than n.
+func splitPoint(n int) int {
+	return 1 << (bits.Len(uint(n-1)) - 1)
+}
+
+// Verify checks that oldRoot is the root of the first OldSize leaves of the
+// tree of newRoot, following RFC 9162.
+func (proof ConsistencyProof) Verify(oldRoot, newRoot []byte) error {
+	if err := proof.opts.validate(); err != nil {
+		return err
+	}
+	if proof.opts.oddNode != Promote {
+		return fmt.Errorf("no consistency proof for trees with the %s odd node strategy", proof.opts.oddNode)
+	}
+	m, n := proof.oldSize, proof.size
+	if m <= 0 || m > n {
+		return fmt.Errorf("old size %d out of range for %d leaves", m, n)
+	}
+	if m == n {
+		if len(proof.hashes) != 0 || !bytes.Equal(oldRoot, newRoot) {
+			return fmt.Errorf("trees of the same size must have the same root")
+		}
+		return nil
+	}
+
+	path := proof.hashes
+	if m&(m-1) == 0 {
+		// the old tree is a node of the new one
+		path = append([][]byte{oldRoot}, path...)
+	}
+	if len(path) == 0 {
+		return fmt.Errorf("empty consistency proof")
+	}
+	fn, sn := m-1, n-1
+	for fn&1 == 1 {
+		fn, sn = fn>>1, sn>>1
+	}
+	fr, sr := path[0], path[0]
+	for _, c := range path[1:] {
+		if sn == 0 {
+			return fmt.Errorf("%d unused hashes", len(path))
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = proof.opts.node(c, fr)
+			sr = proof.opts.node(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn, sn = fn>>1, sn>>1
+			}
+		} else {
+			sr = proof.opts.node(sr, c)
+		}
+		fn, sn = fn>>1, sn>>1
+	}
+	if sn != 0 {
+		return fmt.Errorf("missing hashes for %d leaves of %d", m, n)
+	}
+	if !bytes.Equal(fr, oldRoot) {
+		return fmt.Errorf("old root mismatch, got %x want %x", fr, oldRoot)
+	}
+	if !bytes.Equal(sr, newRoot) {
+		return fmt.Errorf("root mismatch, got %x want %x", sr, newRoot)
+	}
+	return nil
+}
+
+// OldSize returns the number of leaves of the old tree.
+func (proof ConsistencyProof) OldSize() int {
+	return proof.oldSize
+}
+
+// Size returns the number of leaves of the new tree.
+func (proof ConsistencyProof) Size() int {
+	return proof.size
+}
+
+// Hashes returns the hashes of the proof in the order of RFC 6962.
+func (proof ConsistencyProof) Hashes() [][]byte {
+	return proof.hashes
+}
@@ -0,0 +1,135 @@
+package merkletree
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+// rfc6962Inputs are the leaves of the reference tree of RFC 6962
+// implementations.
+var rfc6962Inputs = []string{"", "00", "10", "2021", "3031", "40414243", "5051525354555657", "606162636465666768696a6b6c6d6e6f"}
+
+func TestConsistencyProof(t *testing.T) {
+	var inputs [][]byte
+	for _, input := range rfc6962Inputs {
+		b, _ := hex.DecodeString(input)
+		inputs = append(inputs, b)
+	}
+
+	t.Run("rfc6962", func(t *testing.T) {
+		tree, err := From(inputs, WithMode(DomainSeparated))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cases := []struct {
+			oldSize int
+			hashes  []string
+		}{
+			{1, []string{
+				"96a296d224f285c67bee93c30f8a309157f0daa35dc5b87e410b78630a09cfc7",
+				"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+				"6b47aaf29ee3c2af9af889bc1fb9254dabd31177f16232dd6aab035ca39bf6e4",
+			}},
+			{6, []string{
+				"0ebc5d3437fbe2db158b9f126a1d118e308181031d0a949f8dededebc558ef6a",
+				"ca854ea128ed050b41b35ffc1b87b8eb2bde461e9e3b5596ece6b9d5975a0ae0",
+				"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+			}},
+		}
+		for _, c := range cases {
+			proof, err := tree.ConsistencyProof(c.oldSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, h := range proof.Hashes() {
+				got = append(got, hex.EncodeToString(h))
+			}
+			if want := c.hashes; fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("%d: got %v, want %v", c.oldSize, got, want)
+			}
+		}
+	})
+
+	t.Run("every version", func(t *testing.T) {
+		var all [][]byte
+		for i := 0; i < 17; i++ {
+			all = append(all, []byte(strconv.Itoa(i)))
+		}
+		for _, mode := range []Mode{Plain, DomainSeparated} {
+			var roots [][]byte
+			tree, _ := From(all[:1], WithMode(mode))
+			roots = append(roots, tree.Root())
+			for n := 2; n <= len(all); n++ {
+				if err := tree.Append(tree.opts.leaf(all[n-1])); err != nil {
+					t.Fatal(err)
+				}
+				fresh, _ := From(all[:n], WithMode(mode))
+				if got, want := hex.EncodeToString(tree.Root()), hex.EncodeToString(fresh.Root()); got != want {
+					t.Fatalf("%d leaves: got %v, want %v", n, got, want)
+				}
+				roots = append(roots, tree.Root())
+
+				for m := 1; m <= n; m++ {
+					proof, err := tree.ConsistencyProof(m)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if err := proof.Verify(roots[m-1], tree.Root()); err != nil {
+						t.Errorf("%s %d to %d: %v", mode, m, n, err)
+					}
+					if m > 1 {
+						if err := proof.Verify(roots[m-2], tree.Root()); err == nil {
+							t.Errorf("%s %d to %d: proof should not verify another old root", mode, m, n)
+						}
+					}
+				}
+			}
+		}
+	})
+
+	t.Run("tampered", func(t *testing.T) {
+		tree, _ := From(inputs)
+		old, _ := From(inputs[:3])
+		proof, _ := tree.ConsistencyProof(3)
+		hashes := proof.Hashes()
+		cases := []struct {
+			name  string
+			proof *ConsistencyProof
+		}{
+			{"missing hash", NewConsistencyProof(3, 8, hashes[1:])},
+			{"extra hash", NewConsistencyProof(3, 8, append(hashes, hashes[0]))},
+			{"swapped hashes", NewConsistencyProof(3, 8, [][]byte{hashes[1], hashes[0], hashes[2], hashes[3]})},
+			{"other old size", NewConsistencyProof(4, 8, hashes)},
+			{"old size out of range", NewConsistencyProof(9, 8, hashes)},
+		}
+		for _, c := range cases {
+			if err := c.proof.Verify(old.Root(), tree.Root()); err == nil {
+				t.Errorf("%s: proof should not verify", c.name)
+			}
+		}
+		if _, err := tree.ConsistencyProof(0); err == nil {
+			t.Error("empty old tree should fail")
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		tree, _ := From(inputs, WithHashName(BLAKE2b256))
+		old, _ := From(inputs[:5], WithHashName(BLAKE2b256))
+		proof, _ := tree.ConsistencyProof(5)
+		b, err := json.Marshal(proof)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded ConsistencyProof
+		if err := json.Unmarshal(b, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if err := decoded.Verify(old.Root(), tree.Root()); err != nil {
+			t.Error(err)
+		}
+	})
+}
@@ -0,0 +1,483 @@
+package merkletree
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// proofVersion is the version of the encoded proof format. The binary
+// encoding of a proof is made of
+//
+//	version (1 byte) | hash id (1 byte) | odd node id (4 bits) | mode id (4 bits) |
+//	uvarint index | uvarint size | uvarint sibling count | siblings
+//
+// where every sibling takes the size of the hash. The JSON encoding holds the
+// same fields with the hash, mode and odd node strategy by name and hex
+// encoded siblings, it is shared by multiproofs which hold a list of indexes
+// instead of one and by consistency proofs which hold the sizes of both trees.
+//
+// Version 1 proofs predate odd node strategies: the third byte only holds the
+// mode id and the JSON encoding has no odd node strategy. They are still
+// decoded, as proofs of trees promoting odd nodes.
+//
+// Sparse proofs replace the index, size and count by a bitmap with a bit per
+// level of the tree, from the bottom, set when its sibling is not empty. Only
+// those siblings follow.
+const proofVersion = 2
+
+// checkVersion returns an error for the proof versions which cannot be
+// decoded, and for version 1 proofs of trees not promoting odd nodes.
+func checkVersion(version int, oddNode OddNode) error {
+	if version < 1 || version > proofVersion {
+		return fmt.Errorf("unsupported proof version %d", version)
+	}
+	if version == 1 && oddNode != "" && oddNode != Promote {
+		return fmt.Errorf("version 1 proof with odd node strategy %q", oddNode)
+	}
+	return nil
+}
+
+var modeIDs = map[Mode]byte{
+	Plain:           0,
+	DomainSeparated: 1,
+}
+
+var oddNodeIDs = map[OddNode]byte{
+	Promote:   0,
+	Duplicate: 1,
+}
+
+var (
+	_ encoding.BinaryMarshaler   = Proof{}
+	_ encoding.BinaryUnmarshaler = &Proof{}
+	_ json.Marshaler             = Proof{}
+	_ json.Unmarshaler           = &Proof{}
+	_ json.Marshaler             = MultiProof{}
+	_ json.Unmarshaler           = &MultiProof{}
+	_ json.Marshaler             = ConsistencyProof{}
+	_ json.Unmarshaler           = &ConsistencyProof{}
+	_ encoding.BinaryMarshaler   = SparseProof{}
+	_ encoding.BinaryUnmarshaler = &SparseProof{}
+	_ json.Marshaler             = SparseProof{}
+	_ json.Unmarshaler           = &SparseProof{}
+)
+
+func (proof Proof) MarshalBinary() ([]byte, error) {
+	if err := proof.opts.validate(); err != nil {
+		return nil, err
+	}
+	if proof.opts.hashName == "" {
+		return nil, fmt.Errorf("cannot encode proof of an unnamed hash algorithm")
+	}
+	size := proof.opts.newHash().Size()
+	b := []byte{proofVersion, hashes[proof.opts.hashName].id, oddNodeIDs[proof.opts.oddNode]<<4 | modeIDs[proof.opts.mode]}
+	b = binary.AppendUvarint(b, uint64(proof.index))
+	b = binary.AppendUvarint(b, uint64(proof.size))
+	b = binary.AppendUvarint(b, uint64(len(proof.siblings)))
+	for _, sibling := range proof.siblings {
+		if len(sibling) != size {
+			return nil, fmt.Errorf("sibling of %d bytes for a hash of %d bytes", len(sibling), size)
+		}
+		b = append(b, sibling...)
+	}
+	return b, nil
+}
+
+func (proof *Proof) UnmarshalBinary(data []byte) error {
+	if len(data) < 3 {
+		return fmt.Errorf("proof too short")
+	}
+	if err := checkVersion(int(data[0]), ""); err != nil {
+		return err
+	}
+	name, err := hashName(data[1])
+	if err != nil {
+		return err
+	}
+	var mode Mode
+	for m, id := range modeIDs {
+		if id == data[2]&0x0f {
+			mode = m
+		}
+	}
+	if mode == "" {
+		return fmt.Errorf("unknown tree mode id %d", data[2]&0x0f)
+	}
+	var oddNode OddNode
+	for s, id := range oddNodeIDs {
+		if id == data[2]>>4 {
+			oddNode = s
+		}
+	}
+	if oddNode == "" {
+		return fmt.Errorf("unknown odd node strategy id %d", data[2]>>4)
+	}
+	if err := checkVersion(int(data[0]), oddNode); err != nil {
+		return err
+	}
+	data = data[3:]
+
+	var values [3]uint64
+	for i := range values {
+		value, n := binary.Uvarint(data)
+		if n <= 0 {
+			return fmt.Errorf("invalid proof header")
+		}
+		values[i] = value
+		data = data[n:]
+	}
+	index, size, count := values[0], values[1], values[2]
+
+	opts := newOptions([]Option{WithHashName(name), WithMode(mode), WithOddNode(oddNode)})
+	hashSize := opts.newHash().Size()
+	if count > uint64(len(data)/hashSize) || len(data) != int(count)*hashSize {
+		return fmt.Errorf("%d bytes left for %d siblings of %d bytes", len(data), count, hashSize)
+	}
+	siblings := make([][]byte, count)
+	for i := range siblings {
+		siblings[i] = append([]byte(nil), data[i*hashSize:(i+1)*hashSize]...)
+	}
+	*proof = Proof{
+		opts:     opts,
+		index:    int(index),
+		size:     int(size),
+		siblings: siblings,
+	}
+	return nil
+}
+
+type jsonProof struct {
+	Version  int      `json:"version"`
+	Hash     string   `json:"hash"`
+	Mode     Mode     `json:"mode"`
+	OddNode  OddNode  `json:"odd_node,omitempty"`
+	Index    int      `json:"index"`
+	Size     int      `json:"size"`
+	Siblings []string `json:"siblings"`
+}
+
+func (proof Proof) MarshalJSON() ([]byte, error) {
+	siblings, err := encodeSiblings(proof.opts, proof.siblings)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonProof{
+		Version:  proofVersion,
+		Hash:     proof.opts.hashName,
+		Mode:     proof.opts.mode,
+		OddNode:  proof.opts.oddNode,
+		Index:    proof.index,
+		Size:     proof.size,
+		Siblings: siblings,
+	})
+}
+
+func (proof *Proof) UnmarshalJSON(data []byte) error {
+	var decoded jsonProof
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	opts, siblings, err := decodeSiblings(decoded.Version, decoded.Hash, decoded.Mode, decoded.OddNode, decoded.Siblings)
+	if err != nil {
+		return err
+	}
+	*proof = Proof{
+		opts:     opts,
+		index:    decoded.Index,
+		size:     decoded.Size,
+		siblings: siblings,
+	}
+	return nil
+}
+
+type jsonMultiProof struct {
+	Version  int      `json:"version"`
+	Hash     string   `json:"hash"`
+	Mode     Mode     `json:"mode"`
+	OddNode  OddNode  `json:"odd_node,omitempty"`
+	Indexes  []int    `json:"indexes"`
+	Size     int      `json:"size"`
+	Siblings []string `json:"siblings"`
+}
+
+func (proof MultiProof) MarshalJSON() ([]byte, error) {
+	siblings, err := encodeSiblings(proof.opts, proof.siblings)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonMultiProof{
+		Version:  proofVersion,
+		Hash:     proof.opts.hashName,
+		Mode:     proof.opts.mode,
+		OddNode:  proof.opts.oddNode,
+		Indexes:  proof.indexes,
+		Size:     proof.size,
+		Siblings: siblings,
+	})
+}
+
+func (proof *MultiProof) UnmarshalJSON(data []byte) error {
+	var decoded jsonMultiProof
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	opts, siblings, err := decodeSiblings(decoded.Version, decoded.Hash, decoded.Mode, decoded.OddNode, decoded.Siblings)
+	if err != nil {
+		return err
+	}
+	*proof = MultiProof{
+		opts:     opts,
+		indexes:  decoded.Indexes,
+		size:     decoded.Size,
+		siblings: siblings,
+	}
+	return nil
+}
+
+type jsonConsistencyProof struct {
+	Version int      `json:"version"`
+	Hash    string   `json:"hash"`
+	Mode    Mode     `json:"mode"`
+	OddNode OddNode  `json:"odd_node,omitempty"`
+	OldSize int      `json:"old_size"`
+	Size    int      `json:"size"`
+	Hashes  []string `json:"hashes"`
+}
+
+func (proof ConsistencyProof) MarshalJSON() ([]byte, error) {
+	hashes, err := encodeSiblings(proof.opts, proof.hashes)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonConsistencyProof{
+		Version: proofVersion,
+		Hash:    proof.opts.hashName,
+		Mode:    proof.opts.mode,
+		OddNode: proof.opts.oddNode,
+		OldSize: proof.oldSize,
+		Size:    proof.size,
+		Hashes:  hashes,
+	})
+}
+
+func (proof *ConsistencyProof) UnmarshalJSON(data []byte) error {
+	var decoded jsonConsistencyProof
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	opts, hashes, err := decodeSiblings(decoded.Version, decoded.Hash, decoded.Mode, decoded.OddNode, decoded.Hashes)
+	if err != nil {
+		return err
+	}
+	*proof = ConsistencyProof{
+		opts:    opts,
+		oldSize: decoded.OldSize,
+		size:    decoded.Size,
+		hashes:  hashes,
+	}
+	return nil
+}
+
+func (proof SparseProof) MarshalBinary() ([]byte, error) {
+	bitmap, siblings, err := proof.compact()
+	if err != nil {
+		return nil, err
+	}
+	b := []byte{proofVersion, hashes[proof.opts.hashName].id, modeIDs[proof.opts.mode]}
+	b = append(b, bitmap...)
+	for _, sibling := range siblings {
+		b = append(b, sibling...)
+	}
+	return b, nil
+}
+
+func (proof *SparseProof) UnmarshalBinary(data []byte) error {
+	if len(data) < 3 {
+		return fmt.Errorf("proof too short")
+	}
+	if err := checkVersion(int(data[0]), ""); err != nil {
+		return err
+	}
+	name, err := hashName(data[1])
+	if err != nil {
+		return err
+	}
+	var mode Mode
+	for m, id := range modeIDs {
+		if id == data[2] {
+			mode = m
+		}
+	}
+	if mode == "" {
+		return fmt.Errorf("unknown tree mode id %d", data[2])
+	}
+	opts := newOptions([]Option{WithHashName(name), WithMode(mode)})
+	hashSize := opts.newHash().Size()
+	data = data[3:]
+	if len(data) < hashSize {
+		return fmt.Errorf("proof too short")
+	}
+	bitmap, data := data[:hashSize], data[hashSize:]
+	var siblings [][]byte
+	for i := 0; i < len(data)/hashSize; i++ {
+		siblings = append(siblings, bytes.Clone(data[i*hashSize:(i+1)*hashSize]))
+	}
+	if len(data)%hashSize != 0 {
+		return fmt.Errorf("%d bytes left for siblings of %d bytes", len(data), hashSize)
+	}
+	expanded, err := expandSiblings(bitmap, siblings, 8*hashSize)
+	if err != nil {
+		return err
+	}
+	*proof = SparseProof{
+		opts:     opts,
+		siblings: expanded,
+	}
+	return nil
+}
+
+type jsonSparseProof struct {
+	Version  int      `json:"version"`
+	Hash     string   `json:"hash"`
+	Mode     Mode     `json:"mode"`
+	Bitmap   string   `json:"bitmap"`
+	Siblings []string `json:"siblings"`
+}
+
+func (proof SparseProof) MarshalJSON() ([]byte, error) {
+	bitmap, siblings, err := proof.compact()
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := encodeSiblings(proof.opts, siblings)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonSparseProof{
+		Version:  proofVersion,
+		Hash:     proof.opts.hashName,
+		Mode:     proof.opts.mode,
+		Bitmap:   hex.EncodeToString(bitmap),
+		Siblings: encoded,
+	})
+}
+
+func (proof *SparseProof) UnmarshalJSON(data []byte) error {
+	var decoded jsonSparseProof
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	opts, siblings, err := decodeSiblings(decoded.Version, decoded.Hash, decoded.Mode, Promote, decoded.Siblings)
+	if err != nil {
+		return err
+	}
+	bitmap, err := hex.DecodeString(decoded.Bitmap)
+	if err != nil {
+		return fmt.Errorf("invalid bitmap: %w", err)
+	}
+	expanded, err := expandSiblings(bitmap, siblings, 8*opts.newHash().Size())
+	if err != nil {
+		return err
+	}
+	*proof = SparseProof{
+		opts:     opts,
+		siblings: expanded,
+	}
+	return nil
+}
+
+// compact returns the bitmap of the siblings of a sparse proof which are not
+// empty, followed by those siblings.
+func (proof SparseProof) compact() ([]byte, [][]byte, error) {
+	if err := proof.opts.validate(); err != nil {
+		return nil, nil, err
+	}
+	if proof.opts.hashName == "" {
+		return nil, nil, fmt.Errorf("cannot encode proof of an unnamed hash algorithm")
+	}
+	size := proof.opts.newHash().Size()
+	if len(proof.siblings) != 8*size {
+		return nil, nil, fmt.Errorf("got %d siblings for a tree of height %d", len(proof.siblings), 8*size)
+	}
+	bitmap := make([]byte, size)
+	var siblings [][]byte
+	for i, sibling := range proof.siblings {
+		if sibling == nil {
+			continue
+		}
+		if len(sibling) != size {
+			return nil, nil, fmt.Errorf("sibling of %d bytes for a hash of %d bytes", len(sibling), size)
+		}
+		bitmap[i/8] |= 1 << (7 - i%8)
+		siblings = append(siblings, sibling)
+	}
+	return bitmap, siblings, nil
+}
+
+// expandSiblings places the siblings of a sparse proof at the levels set in
+// bitmap, the others are left empty.
+func expandSiblings(bitmap []byte, siblings [][]byte, height int) ([][]byte, error) {
+	if len(bitmap)*8 != height {
+		return nil, fmt.Errorf("bitmap of %d bytes for a tree of height %d", len(bitmap), height)
+	}
+	expanded := make([][]byte, height)
+	for i := range expanded {
+		if bit(bitmap, i) == 0 {
+			continue
+		}
+		if len(siblings) == 0 {
+			return nil, fmt.Errorf("missing siblings for bitmap %x", bitmap)
+		}
+		expanded[i], siblings = siblings[0], siblings[1:]
+	}
+	if len(siblings) != 0 {
+		return nil, fmt.Errorf("%d unused siblings for bitmap %x", len(siblings), bitmap)
+	}
+	return expanded, nil
+}
+
+func encodeSiblings(opts options, siblings [][]byte) ([]string, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	if opts.hashName == "" {
+		return nil, fmt.Errorf("cannot encode proof of an unnamed hash algorithm")
+	}
+	encoded := make([]string, len(siblings))
+	for i, sibling := range siblings {
+		encoded[i] = hex.EncodeToString(sibling)
+	}
+	return encoded, nil
+}
+
+// decodeSiblings returns the options and siblings of a JSON proof, proofs
+// without odd node strategy are from trees promoting odd nodes.
+func decodeSiblings(version int, hash string, mode Mode, oddNode OddNode, encoded []string) (options, [][]byte, error) {
+	if err := checkVersion(version, oddNode); err != nil {
+		return options{}, nil, err
+	}
+	if oddNode == "" {
+		oddNode = Promote
+	}
+	opts := newOptions([]Option{WithHashName(hash), WithMode(mode), WithOddNode(oddNode)})
+	if err := opts.validate(); err != nil {
+		return options{}, nil, err
+	}
+	hashSize := opts.newHash().Size()
+	siblings := make([][]byte, len(encoded))
+	for i := range encoded {
+		sibling, err := hex.DecodeString(encoded[i])
+		if err != nil {
+			return options{}, nil, fmt.Errorf("invalid sibling %d: %w", i, err)
+		}
+		if len(sibling) != hashSize {
+			return options{}, nil, fmt.Errorf("sibling %d of %d bytes for a hash of %d bytes", i, len(sibling), hashSize)
+		}
+		siblings[i] = sibling
+	}
+	return opts, siblings, nil
+}
@@ -0,0 +1,152 @@
+package merkletree
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestProofEncoding(t *testing.T) {
+	inputs := stringsToBytes([]string{"a", "b", "c", "d", "e"})
+	for _, name := range HashNames() {
+		for _, mode := range []Mode{Plain, DomainSeparated} {
+			for _, oddNode := range OddNodes() {
+				t.Run(name+" "+string(mode)+" "+string(oddNode), func(t *testing.T) {
+					tree, err := From(inputs, WithHashName(name), WithMode(mode), WithOddNode(oddNode))
+					if err != nil {
+						t.Fatal(err)
+					}
+					leaves := leavesOf(tree)
+					proof, err := tree.ProofForIndex(2)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					b, err := proof.MarshalBinary()
+					if err != nil {
+						t.Fatal(err)
+					}
+					var fromBinary Proof
+					if err := fromBinary.UnmarshalBinary(b); err != nil {
+						t.Fatal(err)
+					}
+					j, err := json.Marshal(proof)
+					if err != nil {
+						t.Fatal(err)
+					}
+					var fromJSON Proof
+					if err := json.Unmarshal(j, &fromJSON); err != nil {
+						t.Fatal(err)
+					}
+
+					for _, decoded := range []Proof{fromBinary, fromJSON} {
+						if got, want := decoded.Hash(), name; got != want {
+							t.Errorf("got %v, want %v", got, want)
+						}
+						if got, want := decoded.Mode(), mode; got != want {
+							t.Errorf("got %v, want %v", got, want)
+						}
+						if got, want := decoded.OddNode(), oddNode; got != want {
+							t.Errorf("got %v, want %v", got, want)
+						}
+						if got, want := decoded.Siblings(), proof.Siblings(); !reflect.DeepEqual(got, want) {
+							t.Errorf("got %x, want %x", got, want)
+						}
+						if err := decoded.Verify(leaves[2], tree.Root()); err != nil {
+							t.Error(err)
+						}
+					}
+					again, _ := fromBinary.MarshalBinary()
+					if got, want := string(again), string(b); got != want {
+						t.Errorf("binary encoding is not canonical")
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestProofEncodingWithoutOddNode(t *testing.T) {
+	tree, _ := From(stringsToBytes([]string{"a", "b", "c"}))
+	leaves := leavesOf(tree)
+	proof, _ := tree.ProofForIndex(2)
+	b, _ := proof.MarshalBinary()
+	if got, want := b[2], byte(0); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	data := fmt.Sprintf(`{"version":1,"hash":"sha256","mode":"plain","index":2,"size":3,"siblings":["%x"]}`, proof.Siblings()[0])
+	var decoded Proof
+	if err := json.Unmarshal([]byte(data), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := decoded.OddNode(), Promote; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if err := decoded.Verify(leaves[2], tree.Root()); err != nil {
+		t.Error(err)
+	}
+
+	var fromV1 Proof
+	if err := fromV1.UnmarshalBinary(append([]byte{1}, b[1:]...)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fromV1.OddNode(), Promote; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if err := fromV1.Verify(leaves[2], tree.Root()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestProofEncodingErrors(t *testing.T) {
+	tree, _ := From(stringsToBytes([]string{"a", "b", "c"}))
+	leaves := leavesOf(tree)
+	proof, _ := tree.ProofForIndex(0)
+	b, _ := proof.MarshalBinary()
+
+	binaryCases := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"version", append([]byte{3}, b[1:]...)},
+		{"version 1 odd node", append([]byte{1, b[1], 0x10}, b[3:]...)},
+		{"hash", append([]byte{b[0], 0}, b[2:]...)},
+		{"mode", append([]byte{b[0], b[1], 9}, b[3:]...)},
+		{"odd node", append([]byte{b[0], b[1], 0x90}, b[3:]...)},
+		{"truncated", b[:len(b)-1]},
+		{"trailing", append(append([]byte{}, b...), 0)},
+		{"header", b[:4]},
+	}
+	for _, c := range binaryCases {
+		var decoded Proof
+		if err := decoded.UnmarshalBinary(c.data); err == nil {
+			t.Errorf("%s: decoding should fail", c.name)
+		}
+	}
+
+	jsonCases := []string{
+		`{"version":3,"hash":"sha256","mode":"plain","index":0,"size":3,"siblings":[]}`,
+		`{"version":1,"hash":"sha256","mode":"plain","odd_node":"duplicate","index":0,"size":3,"siblings":[]}`,
+		`{"version":1,"hash":"md5","mode":"plain","index":0,"size":3,"siblings":[]}`,
+		`{"version":1,"hash":"sha256","mode":"other","index":0,"size":3,"siblings":[]}`,
+		`{"version":1,"hash":"sha256","mode":"plain","odd_node":"other","index":0,"size":3,"siblings":[]}`,
+		`{"version":1,"hash":"sha256","mode":"plain","index":0,"size":3,"siblings":["zz"]}`,
+		`{"version":1,"hash":"sha256","mode":"plain","index":0,"size":3,"siblings":["00"]}`,
+	}
+	for _, c := range jsonCases {
+		var decoded Proof
+		if err := json.Unmarshal([]byte(c), &decoded); err == nil {
+			t.Errorf("%s: decoding should fail", c)
+		}
+	}
+
+	custom, _ := FromHashes(leaves, WithHash(newFakeHash))
+	customProof, _ := custom.ProofForIndex(0)
+	if _, err := customProof.MarshalBinary(); err == nil || !strings.Contains(err.Error(), "unnamed") {
+		t.Errorf("got %v, want unnamed hash error", err)
+	}
+}
@@ -0,0 +1,85 @@
+package merkletree
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"sort"
+
+	"golang.org/x/crypto/blake2b"
+	"lukechampine.com/blake3"
+)
+
+// Names of the supported hash algorithms.
+const (
+	SHA256     = "sha256"
+	SHA512     = "sha512"
+	SHA512_256 = "sha512/256"
+	BLAKE2b256 = "blake2b-256"
+	BLAKE3     = "blake3"
+	// SHA256d is sha256 applied twice as in Bitcoin.
+	SHA256d = "sha256d"
+)
+
+// algorithm is a supported hash, id identifies it in encoded proofs and must
+// never change.
+type algorithm struct {
+	id      byte
+	newHash func() hash.Hash
+}
+
+var hashes = map[string]algorithm{
+	SHA256:     {1, sha256.New},
+	SHA512:     {2, sha512.New},
+	SHA512_256: {3, sha512.New512_256},
+	BLAKE2b256: {4, func() hash.Hash {
+		h, _ := blake2b.New256(nil) // only fails with a key longer than 64 bytes
+		return h
+	}},
+	BLAKE3: {5, func() hash.Hash {
+		return blake3.New(32, nil)
+	}},
+	SHA256d: {6, func() hash.Hash {
+		return doubleSHA256{sha256.New()}
+	}},
+}
+
+// doubleSHA256 hashes the sha256 digest of its input again with sha256.
+type doubleSHA256 struct {
+	hash.Hash
+}
+
+func (h doubleSHA256) Sum(b []byte) []byte {
+	first := h.Hash.Sum(nil)
+	second := sha256.Sum256(first)
+	return append(b, second[:]...)
+}
+
+// HashFunc returns the constructor of the hash algorithm called name.
+func HashFunc(name string) (func() hash.Hash, error) {
+	algorithm, exist := hashes[name]
+	if !exist {
+		return nil, fmt.Errorf("unknown hash algorithm %q", name)
+	}
+	return algorithm.newHash, nil
+}
+
+// HashNames returns the names of every supported hash algorithm.
+func HashNames() []string {
+	names := make([]string, 0, len(hashes))
+	for name := range hashes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func hashName(id byte) (string, error) {
+	for name, algorithm := range hashes {
+		if algorithm.id == id {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("unknown hash algorithm id %d", id)
+}
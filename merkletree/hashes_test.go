@@ -0,0 +1,67 @@
+package merkletree
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestHashFunc(t *testing.T) {
+	cases := []struct {
+		name     string
+		expected string
+	}{
+		{SHA256, "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{SHA512, "ddaf35a193617abacc417349ae20413112e6fa4e89a97ea20a9eeee64b55d39a2192992a274fc1a836ba3c23a3feebbd454d4423643ce80e2a9ac94fa54ca49f"},
+		{SHA512_256, "53048e2681941ef99b2e29b76b4c7dabe4c2d0c634fc6d46e0e2f13107e7af23"},
+		{BLAKE2b256, "bddd813c634239723171ef3fee98579b94964e3bb1cb3e427262c8c068d52319"},
+		{BLAKE3, "6437b3ac38465133ffb63b75273a8db548c558465d79db03fd359c6cd5bd9d85"},
+		{SHA256d, "4f8b42c22dd3729b519ba6f68d2da7cc5b2d606d05daed5ad5128cc03e6c6358"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			newHash, err := HashFunc(c.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			h := newHash()
+			h.Write([]byte("abc"))
+			if got, want := hex.EncodeToString(h.Sum(nil)), c.expected; got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+	if got, want := len(HashNames()), len(cases); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := HashFunc("md5"); err == nil {
+		t.Error("unknown hash should fail")
+	}
+}
+
+func TestWithHash(t *testing.T) {
+	inputs := [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+	roots := make(map[string]string)
+	for _, name := range HashNames() {
+		newHash, _ := HashFunc(name)
+		tree, err := From(inputs, WithHash(newHash))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := len(tree.Root()), newHash().Size(); got != want {
+			t.Errorf("%s: got %v, want %v", name, got, want)
+		}
+		root := hex.EncodeToString(tree.Root())
+		if other, exist := roots[root]; exist {
+			t.Errorf("%s and %s give the same root", name, other)
+		}
+		roots[root] = name
+		leaves := leavesOf(tree)
+		proof, err := tree.ProofForIndex(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := proof.Verify(leaves[0], tree.Root()); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
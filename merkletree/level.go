@@ -0,0 +1,95 @@
+package merkletree
+
+import (
+	"bytes"
+	"slices"
+)
+
+// level holds the hashes of one level of a tree back to back. Hashes of a
+// level share the same width, ends is only filled when they do not, as with
+// hash functions of variable output.
+type level struct {
+	hashes []byte
+	width  int
+	ends   []int
+	count  int
+}
+
+func newLevel(count, width int) level {
+	return level{hashes: make([]byte, 0, count*width)}
+}
+
+func (l *level) append(h []byte) {
+	switch {
+	case l.count == 0 && l.ends == nil:
+		l.width = len(h)
+	case l.ends == nil && len(h) != l.width:
+		l.ends = make([]int, l.count)
+		for i := range l.ends {
+			l.ends[i] = (i + 1) * l.width
+		}
+	}
+	l.hashes = append(l.hashes, h...)
+	if l.ends != nil {
+		l.ends = append(l.ends, len(l.hashes))
+	}
+	l.count++
+}
+
+// extend makes room for n hashes of width bytes to be written in place and
+// returns the index of the first one.
+func (l *level) extend(n, width int) int {
+	first := l.count
+	if l.count == 0 {
+		l.width = width
+	}
+	l.hashes = slices.Grow(l.hashes, n*width)[:len(l.hashes)+n*width]
+	l.count += n
+	return first
+}
+
+// truncate keeps the first n hashes, the next appends overwrite the others
+// in place.
+func (l *level) truncate(n int) {
+	if n >= l.count {
+		return
+	}
+	end := n * l.width
+	if l.ends != nil {
+		end = 0
+		if n > 0 {
+			end = l.ends[n-1]
+		}
+		l.ends = l.ends[:n]
+	}
+	l.hashes = l.hashes[:end]
+	l.count = n
+}
+
+func (l level) len() int {
+	return l.count
+}
+
+// at returns the hash at index i as stored in the level, the tree only hands
+// out copies since levels are rewritten by appends.
+func (l level) at(i int) []byte {
+	if l.ends == nil {
+		return l.hashes[i*l.width : (i+1)*l.width : (i+1)*l.width]
+	}
+	start := 0
+	if i > 0 {
+		start = l.ends[i-1]
+	}
+	return l.hashes[start:l.ends[i]:l.ends[i]]
+}
+
+// all returns a copy of the hashes of the level, backed by a single array.
+func (l level) all() [][]byte {
+	copied := l
+	copied.hashes = bytes.Clone(l.hashes)
+	hashes := make([][]byte, l.count)
+	for i := range hashes {
+		hashes[i] = copied.at(i)
+	}
+	return hashes
+}
@@ -2,23 +2,26 @@ package merkletree
 
 import (
 	"bytes"
-	"crypto/sha256"
-	"encoding/hex"
 	"fmt"
 	"hash"
 )
 
+// MerkleTree stores the hashes of every level in a flat slice, a node is
+// found from its position: the parent of the node at i is at i/2 on the level
+// above and the last node of an odd level is handled according to the
+// OddNode strategy of the tree.
 type MerkleTree struct {
-	nodes  map[string]*Node
-	root   []byte
-	height int
+	// levels holds every level from the leaves to the root
+	levels []level
+	size   int
 
-	newHash func() hash.Hash
+	opts options
 }
 
-func From(inputs [][]byte) (*MerkleTree, error) {
+// From builds the tree whose leaves are the hashes of inputs.
+func From(inputs [][]byte, opts ...Option) (*MerkleTree, error) {
 	tree := &MerkleTree{
-		newHash: sha256.New,
+		opts: newOptions(opts),
 	}
 	if err := tree.from(inputs); err != nil {
 		return nil, err
@@ -26,9 +29,11 @@ func From(inputs [][]byte) (*MerkleTree, error) {
 	return tree, nil
 }
 
-func FromHashes(hashes [][]byte, newHash func() hash.Hash) (*MerkleTree, error) {
+// FromHashes builds the tree of already hashed leaves, only interior nodes
+// are hashed according to the mode of the tree.
+func FromHashes(hashes [][]byte, opts ...Option) (*MerkleTree, error) {
 	tree := &MerkleTree{
-		newHash: newHash,
+		opts: newOptions(opts),
 	}
 	if err := tree.build(hashes); err != nil {
 		return nil, err
@@ -37,133 +42,156 @@ func FromHashes(hashes [][]byte, newHash func() hash.Hash) (*MerkleTree, error)
 }
 
 func (tree *MerkleTree) Level(index int) ([][]byte, error) {
-	if tree.height == 0 {
+	if len(tree.levels) == 0 {
 		return nil, fmt.Errorf("merkle tree not initialized")
 	}
 
-	if index >= tree.height {
-		return nil, fmt.Errorf("cannot retrieve level '%d' height is '%d'", index, tree.height)
+	if index >= len(tree.levels) {
+		return nil, fmt.Errorf("cannot retrieve level '%d' height is '%d'", index, len(tree.levels))
 	}
 
-	parents := [][]byte{tree.root}
-	if index == 0 {
-		return parents, nil
-	}
-
-	var nodes [][]byte
-	for i := 0; i < index; i++ {
-		nodes = [][]byte{}
-		for _, child := range parents {
-			nodes = append(nodes, tree.childOf(child)...)
-		}
-		parents = nodes
-	}
-
-	return nodes, nil
+	return tree.levels[len(tree.levels)-1-index].all(), nil
 }
 
 func (tree *MerkleTree) Height() int {
-	return tree.height
+	return len(tree.levels)
 }
 
 func (tree *MerkleTree) Root() []byte {
-	return tree.root
+	if len(tree.levels) == 0 {
+		return nil
+	}
+	return bytes.Clone(tree.levels[len(tree.levels)-1].at(0))
 }
 
-func (tree *MerkleTree) ProofFor(hash []byte) (*Proof, error) {
-	node, exist := tree.nodes[hex.EncodeToString(hash)]
-	if !exist {
-		return nil, fmt.Errorf("%x not found in merkle tree", hash)
-	}
-	var hashes = [][]byte{node.hash}
-	for !bytes.Equal(node.hash, tree.root) {
-		node = tree.nodes[hex.EncodeToString(node.parent)]
-		otherLeaf := node.rightChild
-		if bytes.Equal(hashes[len(hashes)-1], otherLeaf) {
-			otherLeaf = node.leftChild
-		}
-		hashes = append(hashes, otherLeaf, node.hash)
-	}
-	return NewProof(tree.newHash, hashes), nil
+// LeafCount returns the number of leaves of the tree.
+func (tree *MerkleTree) LeafCount() int {
+	return tree.size
 }
 
-func (tree *MerkleTree) build(hashes [][]byte) error {
-	if len(hashes) == 0 {
-		return fmt.Errorf("invalid inputs")
+// Leaf returns the leaf at index.
+func (tree *MerkleTree) Leaf(index int) ([]byte, error) {
+	if err := tree.checkIndex(index); err != nil {
+		return nil, err
 	}
+	return bytes.Clone(tree.levels[0].at(index)), nil
+}
 
-	tree.height = 1
-	tree.nodes = make(map[string]*Node)
-
-	for _, h := range hashes {
-		tree.nodes[hex.EncodeToString(h)] = &Node{
-			hash: h,
+// Leaves calls yield for every leaf in index order until it returns false.
+// leaf is only valid during the call.
+func (tree *MerkleTree) Leaves(yield func(index int, leaf []byte) bool) {
+	if len(tree.levels) == 0 {
+		return
+	}
+	leaves := tree.levels[0]
+	for i := 0; i < leaves.len(); i++ {
+		if !yield(i, leaves.at(i)) {
+			return
 		}
 	}
+}
 
-	for len(hashes) != 1 {
-		hashes = tree.buildBranch(hashes)
+// ProofForIndex returns the proof of the leaf at index.
+func (tree *MerkleTree) ProofForIndex(index int) (*Proof, error) {
+	if err := tree.checkIndex(index); err != nil {
+		return nil, err
 	}
-	tree.root = hashes[0]
-
-	return nil
+	return tree.proof(index), nil
 }
 
-func (tree *MerkleTree) from(inputs [][]byte) error {
-	var hashes [][]byte
-	for _, data := range inputs {
-		h := tree.newHash()
-		h.Write(data)
-		hashes = append(hashes, h.Sum(nil))
+// ProofFor returns the proof of the leaf hash, the first one when several
+// leaves have that hash. ProofForIndex avoids searching the leaves.
+func (tree *MerkleTree) ProofFor(hash []byte) (*Proof, error) {
+	if len(tree.levels) != 0 {
+		leaves := tree.levels[0]
+		for i := 0; i < leaves.len(); i++ {
+			if bytes.Equal(leaves.at(i), hash) {
+				return tree.proof(i), nil
+			}
+		}
 	}
-	return tree.build(hashes)
+	return nil, fmt.Errorf("%x not found in merkle tree", hash)
 }
 
-func (tree *MerkleTree) buildBranch(hashes [][]byte) [][]byte {
-	var newNodes [][]byte
+func (tree *MerkleTree) checkIndex(index int) error {
+	if len(tree.levels) == 0 {
+		return fmt.Errorf("merkle tree not initialized")
+	}
+	if index < 0 || index >= tree.size {
+		return fmt.Errorf("index %d out of range for %d leaves", index, tree.size)
+	}
+	return nil
+}
 
-	for i := 0; i < (len(hashes) / 2); i++ {
-		h := sum(tree.newHash, hashes[i*2], hashes[i*2+1])
-		tree.nodes[hex.EncodeToString(h)] = &Node{
-			hash:       h,
-			leftChild:  hashes[i*2],
-			rightChild: hashes[i*2+1],
+func (tree *MerkleTree) proof(index int) *Proof {
+	var siblings [][]byte
+	for depth, p := 0, index; depth < len(tree.levels)-1; depth, p = depth+1, p/2 {
+		level := tree.levels[depth]
+		switch {
+		case p%2 == 1:
+			siblings = append(siblings, bytes.Clone(level.at(p-1)))
+		case p+1 < level.len():
+			siblings = append(siblings, bytes.Clone(level.at(p+1)))
 		}
-		tree.nodes[hex.EncodeToString(hashes[i*2])].parent = h
-		tree.nodes[hex.EncodeToString(hashes[i*2+1])].parent = h
-		newNodes = append(newNodes, h)
 	}
+	return NewProof(index, tree.size, siblings, tree.opts.option())
+}
 
-	if isOdd(len(hashes)) {
-		newNodes = append(newNodes, hashes[len(hashes)-1])
+func (tree *MerkleTree) build(hashes [][]byte) error {
+	if len(hashes) == 0 {
+		return fmt.Errorf("invalid inputs")
+	}
+	if err := tree.opts.validate(); err != nil {
+		return err
 	}
 
-	tree.height++
-	return newNodes
+	leaves := newLevel(len(hashes), len(hashes[0]))
+	for _, h := range hashes {
+		leaves.append(h)
+	}
+	tree.levels = []level{leaves}
+	tree.grow(0)
+	return nil
 }
 
-func (tree *MerkleTree) childOf(hash []byte) [][]byte {
-	node := tree.nodes[hex.EncodeToString(hash)]
-	if len(node.leftChild) == 0 && len(node.rightChild) == 0 {
-		return [][]byte{hash}
+func (tree *MerkleTree) from(inputs [][]byte) error {
+	if len(inputs) == 0 {
+		return fmt.Errorf("invalid inputs")
 	}
-	return [][]byte{node.leftChild, node.rightChild}
+	if err := tree.opts.validate(); err != nil {
+		return err
+	}
+
+	var leaves level
+	tree.appendHashes(&leaves, len(inputs), func(h hash.Hash, dst []byte, i int) []byte {
+		return tree.opts.leafWith(h, dst, inputs[i])
+	})
+	tree.levels = []level{leaves}
+	tree.grow(0)
+	return nil
 }
 
-type Node struct {
-	parent     []byte
-	hash       []byte
-	leftChild  []byte
-	rightChild []byte
+// grow hashes the levels above the leaves again from the leaf at index from,
+// the nodes on the left of its path are kept.
+func (tree *MerkleTree) grow(from int) {
+	tree.size = tree.levels[0].len()
+	for depth := 0; tree.levels[depth].len() > 1; depth++ {
+		below := tree.levels[depth]
+		if depth+1 == len(tree.levels) {
+			tree.levels = append(tree.levels, newLevel((below.len()+1)/2, tree.opts.newHash().Size()))
+		}
+		from /= 2
+		next := &tree.levels[depth+1]
+		next.truncate(from)
+		tree.appendHashes(next, below.len()/2-from, func(h hash.Hash, dst []byte, i int) []byte {
+			return tree.opts.nodeWith(h, dst, below.at(2*(from+i)), below.at(2*(from+i)+1))
+		})
+		if isOdd(below.len()) {
+			next.append(tree.opts.unpaired(below.at(below.len() - 1)))
+		}
+	}
 }
 
 func isOdd(number int) bool {
 	return number%2 == 1
 }
-
-func sum(newHash func() hash.Hash, left, right []byte) []byte {
-	h := newHash()
-	h.Write(left)
-	h.Write(right)
-	return h.Sum(nil)
-}
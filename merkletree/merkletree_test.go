@@ -1,10 +1,14 @@
 package merkletree
 
 import (
+	"bytes"
 	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"hash"
+	"os"
 	"reflect"
+	"slices"
 	"strconv"
 	"strings"
 	"testing"
@@ -43,7 +47,7 @@ func newFakeHash() hash.Hash {
 
 func TestMerkleTree(t *testing.T) {
 	t.Run("from", func(t *testing.T) {
-		tree := MerkleTree{newHash: newFakeHash}
+		tree := MerkleTree{opts: newOptions([]Option{WithHash(newFakeHash)})}
 
 		cases := []struct {
 			inputs         []string
@@ -64,7 +68,7 @@ func TestMerkleTree(t *testing.T) {
 			t.Run(strings.Join(c.inputs, ""), func(t *testing.T) {
 				tree.from(stringsToBytes(c.inputs))
 
-				if got, want := string(tree.root), c.expectedRoot; got != want {
+				if got, want := string(tree.Root()), c.expectedRoot; got != want {
 					t.Fatalf("got %v, want %v", got, want)
 				}
 				if got, want := tree.Height(), c.expectedHeight; got != want {
@@ -75,7 +79,7 @@ func TestMerkleTree(t *testing.T) {
 	})
 
 	t.Run("level", func(t *testing.T) {
-		tree := MerkleTree{newHash: newFakeHash}
+		tree := MerkleTree{opts: newOptions([]Option{WithHash(newFakeHash)})}
 
 		cases := []struct {
 			inputs   []string
@@ -87,6 +91,8 @@ func TestMerkleTree(t *testing.T) {
 			{[]string{"a", "b", "c", "d", "e", "f", "g", "h", "i"}, 2, []string{"abcd", "efgh", "i"}},
 			{[]string{"a", "b", "c", "d", "e", "f", "g", "h", "i"}, 3, []string{"ab", "cd", "ef", "gh", "i"}},
 			{[]string{"a", "b", "c", "d", "e", "f", "g", "h", "i"}, 4, []string{"a", "b", "c", "d", "e", "f", "g", "h", "i"}},
+			{[]string{"a", "b", "c", "d", "e", "f"}, 1, []string{"abcd", "ef"}},
+			{[]string{"a", "b", "c", "d", "e", "f"}, 2, []string{"ab", "cd", "ef"}},
 		}
 
 		for _, c := range cases {
@@ -127,31 +133,31 @@ func TestMerkleTree(t *testing.T) {
 	t.Run("Proof", func(t *testing.T) {
 
 		cases := []struct {
-			inputs        []string
-			index         int
-			expectedProof []string
+			inputs           []string
+			index            int
+			expectedSiblings []string
 		}{
-			{inputs: []string{"a"}, index: 0, expectedProof: []string{"0a"}},
-			{inputs: []string{"a", "b"}, index: 0, expectedProof: []string{"0a", "1b", "0a1b"}},
-			{inputs: []string{"a", "b", "c"}, index: 0, expectedProof: []string{"0a", "1b", "0a1b", "2c", "0a1b2c"}},
-			{inputs: []string{"a", "b", "c"}, index: 2, expectedProof: []string{"2c", "0a1b", "0a1b2c"}},
-			{inputs: []string{"a", "b", "c", "d"}, index: 0, expectedProof: []string{"0a", "1b", "0a1b", "2c3d", "0a1b2c3d"}},
-			{inputs: []string{"a", "b", "c", "d"}, index: 1, expectedProof: []string{"1b", "0a", "0a1b", "2c3d", "0a1b2c3d"}},
-			{inputs: []string{"a", "b", "c", "d"}, index: 2, expectedProof: []string{"2c", "3d", "2c3d", "0a1b", "0a1b2c3d"}},
-			{inputs: []string{"a", "b", "c", "d"}, index: 3, expectedProof: []string{"3d", "2c", "2c3d", "0a1b", "0a1b2c3d"}},
-			{inputs: []string{"a", "b", "c", "d", "e"}, index: 0, expectedProof: []string{"0a", "1b", "0a1b", "2c3d", "0a1b2c3d", "4e", "0a1b2c3d4e"}},
-			{inputs: []string{"a", "b", "c", "d", "e"}, index: 4, expectedProof: []string{"4e", "0a1b2c3d", "0a1b2c3d4e"}},
-			{inputs: []string{"a", "b", "c", "d", "e", "f"}, index: 0, expectedProof: []string{"0a", "1b", "0a1b", "2c3d", "0a1b2c3d", "4e5f", "0a1b2c3d4e5f"}},
-			{inputs: []string{"a", "b", "c", "d", "e", "f", "g"}, index: 0, expectedProof: []string{"0a", "1b", "0a1b", "2c3d", "0a1b2c3d", "4e5f6g", "0a1b2c3d4e5f6g"}},
-			{inputs: []string{"a", "b", "c", "d", "e", "f", "g", "h"}, index: 0, expectedProof: []string{"0a", "1b", "0a1b", "2c3d", "0a1b2c3d", "4e5f6g7h", "0a1b2c3d4e5f6g7h"}},
-			{inputs: []string{"a", "b", "c", "d", "e", "f", "g", "h"}, index: 3, expectedProof: []string{"3d", "2c", "2c3d", "0a1b", "0a1b2c3d", "4e5f6g7h", "0a1b2c3d4e5f6g7h"}},
-			{inputs: []string{"a", "b", "c", "d", "e", "f", "g", "h"}, index: 7, expectedProof: []string{"7h", "6g", "6g7h", "4e5f", "4e5f6g7h", "0a1b2c3d", "0a1b2c3d4e5f6g7h"}},
+			{inputs: []string{"a"}, index: 0, expectedSiblings: []string{}},
+			{inputs: []string{"a", "b"}, index: 0, expectedSiblings: []string{"1b"}},
+			{inputs: []string{"a", "b", "c"}, index: 0, expectedSiblings: []string{"1b", "2c"}},
+			{inputs: []string{"a", "b", "c"}, index: 2, expectedSiblings: []string{"0a1b"}},
+			{inputs: []string{"a", "b", "c", "d"}, index: 0, expectedSiblings: []string{"1b", "2c3d"}},
+			{inputs: []string{"a", "b", "c", "d"}, index: 1, expectedSiblings: []string{"0a", "2c3d"}},
+			{inputs: []string{"a", "b", "c", "d"}, index: 2, expectedSiblings: []string{"3d", "0a1b"}},
+			{inputs: []string{"a", "b", "c", "d"}, index: 3, expectedSiblings: []string{"2c", "0a1b"}},
+			{inputs: []string{"a", "b", "c", "d", "e"}, index: 0, expectedSiblings: []string{"1b", "2c3d", "4e"}},
+			{inputs: []string{"a", "b", "c", "d", "e"}, index: 4, expectedSiblings: []string{"0a1b2c3d"}},
+			{inputs: []string{"a", "b", "c", "d", "e", "f"}, index: 0, expectedSiblings: []string{"1b", "2c3d", "4e5f"}},
+			{inputs: []string{"a", "b", "c", "d", "e", "f", "g"}, index: 0, expectedSiblings: []string{"1b", "2c3d", "4e5f6g"}},
+			{inputs: []string{"a", "b", "c", "d", "e", "f", "g", "h"}, index: 0, expectedSiblings: []string{"1b", "2c3d", "4e5f6g7h"}},
+			{inputs: []string{"a", "b", "c", "d", "e", "f", "g", "h"}, index: 3, expectedSiblings: []string{"2c", "0a1b", "4e5f6g7h"}},
+			{inputs: []string{"a", "b", "c", "d", "e", "f", "g", "h"}, index: 7, expectedSiblings: []string{"6g", "4e5f", "0a1b2c3d"}},
 		}
 
 		for _, c := range cases {
 			t.Run(fmt.Sprintf("%s for %s", strings.Join(c.inputs, ""), c.inputs[c.index]), func(t *testing.T) {
 				builder := NewIndexedBuilder(len(c.inputs))
-				builder.newHash = newFakeHash
+				builder.opts.newHash = newFakeHash
 				for i, input := range c.inputs {
 					builder.AddHash(i, []byte(input))
 				}
@@ -161,36 +167,73 @@ func TestMerkleTree(t *testing.T) {
 				}
 
 				indexedHash := []byte(strconv.Itoa(c.index) + c.inputs[c.index])
-				proof, err := tree.ProofFor(indexedHash)
+				proof, err := tree.ProofForIndex(c.index)
 				if err != nil {
 					t.Fatal(err)
 				}
 
-				for i := 0; i < len(c.expectedProof); i++ {
-					if got, want := string(proof.hashes[i]), c.expectedProof[i]; got != want {
-						t.Errorf("got %v, want %v", got, want)
-					}
+				if got, want := proof.Siblings(), stringsToBytes(c.expectedSiblings); !reflect.DeepEqual(got, want) {
+					t.Errorf("got %s, want %s", got, want)
+				}
+				if got, want := proof.Index(), c.index; got != want {
+					t.Errorf("got %v, want %v", got, want)
 				}
 
-				if err := proof.Verify(indexedHash, tree.root); err != nil {
+				if err := proof.Verify(indexedHash, tree.Root()); err != nil {
 					t.Errorf(err.Error())
 				}
 			})
 		}
 	})
 
+	t.Run("proof position", func(t *testing.T) {
+		tree, err := From(stringsToBytes([]string{"a", "b", "c", "d", "e"}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaves := leavesOf(tree)
+		proof, err := tree.ProofForIndex(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		siblings := proof.Siblings()
+		swapped := [][]byte{siblings[0], siblings[2], siblings[1]}
+
+		cases := []struct {
+			name  string
+			proof *Proof
+			leaf  []byte
+		}{
+			{"other index", NewProof(0, 5, siblings), leaves[1]},
+			{"other size", NewProof(1, 4, siblings), leaves[1]},
+			{"swapped siblings", NewProof(1, 5, swapped), leaves[1]},
+			{"missing sibling", NewProof(1, 5, siblings[:2]), leaves[1]},
+			{"extra sibling", NewProof(1, 5, append(siblings, leaves[0])), leaves[1]},
+			{"out of range", NewProof(5, 5, siblings), leaves[1]},
+			{"sibling as leaf", NewProof(1, 5, siblings), leaves[0]},
+		}
+		for _, c := range cases {
+			if err := c.proof.Verify(c.leaf, tree.Root()); err == nil {
+				t.Errorf("%s: proof should not verify", c.name)
+			}
+		}
+		if err := proof.Verify(leaves[1], tree.Root()); err != nil {
+			t.Error(err)
+		}
+	})
+
 	t.Run("From", func(t *testing.T) {
 		tree, err := From(stringsToBytes([]string{"a", "b"}))
 		if err != nil {
 			t.Fatal(err)
 		}
-		left := tree.newHash().Sum([]byte("a"))
-		right := tree.newHash().Sum([]byte("b"))
+		left := tree.opts.newHash().Sum([]byte("a"))
+		right := tree.opts.newHash().Sum([]byte("b"))
 
-		if got, want := tree.root, tree.newHash().Sum(append(left, right...)); reflect.DeepEqual(got, want) {
+		if got, want := tree.Root(), tree.opts.newHash().Sum(append(left, right...)); reflect.DeepEqual(got, want) {
 			t.Fatalf("got %v, want %v", got, want)
 		}
-		if got, want := tree.height, 2; got != want {
+		if got, want := tree.Height(), 2; got != want {
 			t.Fatalf("got %v, want %v", got, want)
 		}
 	})
@@ -199,19 +242,103 @@ func TestMerkleTree(t *testing.T) {
 		a := sha256.New().Sum([]byte("a"))
 		b := sha256.New().Sum([]byte("b"))
 
-		tree, err := FromHashes([][]byte{a, b}, sha256.New)
+		tree, err := FromHashes([][]byte{a, b})
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		if got, want := tree.root, tree.newHash().Sum(append(a, b...)); reflect.DeepEqual(got, want) {
+		if got, want := tree.Root(), tree.opts.newHash().Sum(append(a, b...)); reflect.DeepEqual(got, want) {
 			t.Fatalf("got %v, want %v", got, want)
 		}
-		if got, want := tree.height, 2; got != want {
+		if got, want := tree.Height(), 2; got != want {
 			t.Fatalf("got %v, want %v", got, want)
 		}
 	})
 
+	t.Run("duplicate leaves", func(t *testing.T) {
+		tree, err := From(stringsToBytes([]string{"a", "b", "a", "a", "c"}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaves := leavesOf(tree)
+		for _, leaf := range leaves {
+			proof, err := tree.ProofFor(leaf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := proof.Verify(leaf, tree.Root()); err != nil {
+				t.Error(err)
+			}
+		}
+		for _, index := range []int{0, 2, 3} {
+			proof, err := tree.ProofForIndex(index)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := proof.Index(), index; got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+			if err := proof.Verify(leaves[0], tree.Root()); err != nil {
+				t.Errorf("%d: %v", index, err)
+			}
+		}
+	})
+
+	t.Run("append keeps previous hashes", func(t *testing.T) {
+		inputs := stringsToBytes([]string{"a", "b", "c", "d", "e"})
+		tree, _ := From(inputs[:3])
+		root := tree.Root()
+		leaves := leavesOf(tree)
+		proof, _ := tree.ProofForIndex(2)
+
+		for _, input := range inputs[3:] {
+			if err := tree.Append(tree.opts.leaf(input)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := proof.Verify(leaves[2], root); err != nil {
+			t.Error(err)
+		}
+		expected, _ := From(inputs)
+		if got, want := tree.Root(), expected.Root(); !bytes.Equal(got, want) {
+			t.Errorf("got %x, want %x", got, want)
+		}
+	})
+
+	t.Run("leaves", func(t *testing.T) {
+		inputs := stringsToBytes([]string{"a", "b", "c"})
+		tree, _ := From(inputs)
+		if got, want := tree.LeafCount(), len(inputs); got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		for i, input := range inputs {
+			leaf, err := tree.Leaf(i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := leaf, tree.opts.leaf(input); !bytes.Equal(got, want) {
+				t.Errorf("got %x, want %x", got, want)
+			}
+		}
+		for _, index := range []int{-1, len(inputs)} {
+			if _, err := tree.Leaf(index); err == nil {
+				t.Errorf("leaf %d should fail", index)
+			}
+			if _, err := tree.ProofForIndex(index); err == nil {
+				t.Errorf("proof %d should fail", index)
+			}
+		}
+
+		var visited []int
+		tree.Leaves(func(index int, leaf []byte) bool {
+			visited = append(visited, index)
+			return index < 1
+		})
+		if got, want := visited, []int{0, 1}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
 	t.Run("new invalid inputs", func(t *testing.T) {
 		_, err := From(nil)
 		if got, want := err.Error(), "invalid inputs"; !strings.Contains(got, want) {
@@ -220,6 +347,15 @@ func TestMerkleTree(t *testing.T) {
 	})
 }
 
+func leavesOf(tree *MerkleTree) [][]byte {
+	var leaves [][]byte
+	tree.Leaves(func(_ int, leaf []byte) bool {
+		leaves = append(leaves, bytes.Clone(leaf))
+		return true
+	})
+	return leaves
+}
+
 func stringsToBytes(in []string) [][]byte {
 	var out [][]byte
 	for _, i := range in {
@@ -227,3 +363,218 @@ func stringsToBytes(in []string) [][]byte {
 	}
 	return out
 }
+
+func TestDomainSeparated(t *testing.T) {
+	t.Run("rfc6962 roots", func(t *testing.T) {
+		leaves := []string{"", "00", "10", "2021", "3031", "40414243", "5051525354555657", "606162636465666768696a6b6c6d6e6f"}
+		roots := []string{
+			"6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+			"fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125",
+			"aeb6bcfe274b70a14fb067a5e5578264db0fa9b51af5e0ba159158f329e06e77",
+			"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+			"4e3bbb1f7b478dcfe71fb631631519a3bca12c9aefca1612bfce4c13a86264d4",
+			"76e67dadbcdf1e10e1b74ddc608abd2f98dfb16fbce75277b5232a127f2087ef",
+			"ddb89be403809e325750d3d263cd78929c2942b7942a34b77e122c9594a74c8c",
+			"5dc9da79a70659a9ad559cb701ded9a2ab9d823aad2f4960cfe370eff4604328",
+		}
+		var inputs [][]byte
+		for i, leaf := range leaves {
+			input, _ := hex.DecodeString(leaf)
+			inputs = append(inputs, input)
+			tree, err := From(inputs, WithMode(DomainSeparated))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := hex.EncodeToString(tree.Root()), roots[i]; got != want {
+				t.Errorf("%d leaves: got %v, want %v", i+1, got, want)
+			}
+		}
+	})
+
+	t.Run("interior node as leaf", func(t *testing.T) {
+		inputs := [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("d")}
+		for _, c := range []struct {
+			mode    Mode
+			forgery bool
+		}{
+			{Plain, true},
+			{DomainSeparated, false},
+		} {
+			t.Run(string(c.mode), func(t *testing.T) {
+				opts := newOptions([]Option{WithMode(c.mode)})
+				tree, err := From(inputs, WithMode(c.mode))
+				if err != nil {
+					t.Fatal(err)
+				}
+				nodes, err := tree.Level(1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// claim the concatenation of the first two leaves is a leaf
+				leaves, _ := tree.Level(2)
+				forged := NewProof(0, 2, [][]byte{nodes[1]}, WithMode(c.mode))
+				err = forged.Verify(opts.leaf(leaves[0], leaves[1]), tree.Root())
+				if got, want := err == nil, c.forgery; got != want {
+					t.Errorf("got %v, want %v", got, want)
+				}
+			})
+		}
+	})
+
+	t.Run("proofs", func(t *testing.T) {
+		builder := NewIndexedBuilder(5, WithMode(DomainSeparated))
+		for i := 0; i < 5; i++ {
+			if _, err := builder.Add(i, strings.NewReader(strconv.Itoa(i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		tree, err := builder.Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		plain, err := FromHashes(builder.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(plain.Root(), tree.Root()) {
+			t.Fatal("modes should give different roots")
+		}
+		for i := 0; i < 5; i++ {
+			h := sha256.Sum256([]byte(strconv.Itoa(i)))
+			leaf := LeafHash(i, h[:], WithMode(DomainSeparated))
+			proof, err := tree.ProofForIndex(i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := proof.Verify(leaf, tree.Root()); err != nil {
+				t.Error(err)
+			}
+			if err := NewProof(proof.Index(), proof.Size(), proof.Siblings()).Verify(leaf, tree.Root()); err == nil {
+				t.Errorf("%d: proof should not verify in plain mode", i)
+			}
+		}
+	})
+
+	if _, err := From([][]byte{[]byte("a")}, WithMode("unknown")); err == nil {
+		t.Error("unknown mode should fail")
+	}
+}
+
+func TestOddNode(t *testing.T) {
+	block277647, err := os.ReadFile("testdata/block277647.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := []struct {
+		name  string
+		txids []string
+		root  string
+	}{
+		{
+			name: "bitcoin block 100000",
+			txids: []string{
+				"8c14f0db3df150123e6f3dbbf30f8b955a8249b62ac1d1ff16284aefa3d06d87",
+				"fff2525b8931402dd09222c50775608f75787bd2b87e56995a7bdd30f79702c4",
+				"6359f0868171b1d194cbee1af2f16ea598ae8fad666d9b012c8ed2b79a236ec4",
+				"e9a66845e05d5abc0ad04ec80f774a7e585c6e8db975962d069a522137b80c1d",
+			},
+			root: "f3e94742aca4b5ef85488dc37c06c3282295ffec960994b2c0d5ac2a25a95766",
+		},
+		{
+			// 213 transactions, the levels of 213, 107, 27 and 7 nodes are odd
+			name:  "bitcoin block 277647",
+			txids: strings.Fields(string(block277647)),
+			root:  "36ac31298eb05c23be1f775d635104705e4560c6532b95c158023c6dc9af06c3",
+		},
+	}
+	for _, block := range blocks {
+		t.Run(block.name, func(t *testing.T) {
+			// bitcoin displays hashes byte reversed
+			var leaves [][]byte
+			for _, txid := range block.txids {
+				leaf, _ := hex.DecodeString(txid)
+				slices.Reverse(leaf)
+				leaves = append(leaves, leaf)
+			}
+			tree, err := FromHashes(leaves, WithHashName(SHA256d), WithOddNode(Duplicate))
+			if err != nil {
+				t.Fatal(err)
+			}
+			root := tree.Root()
+			slices.Reverse(root)
+			if got, want := hex.EncodeToString(root), block.root; got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+
+	t.Run("duplicate last", func(t *testing.T) {
+		leaves := stringsToBytes([]string{"a", "b", "c"})
+		tree, err := FromHashes(leaves, WithOddNode(Duplicate))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the odd leaf c is paired with itself
+		ab, cc := sha256.Sum256([]byte("ab")), sha256.Sum256([]byte("cc"))
+		want := sha256.Sum256(append(ab[:], cc[:]...))
+		if got := tree.Root(); !bytes.Equal(got, want[:]) {
+			t.Errorf("got %x, want %x", got, want)
+		}
+		padded, _ := FromHashes(stringsToBytes([]string{"a", "b", "c", "c"}))
+		if got, want := tree.Root(), padded.Root(); !bytes.Equal(got, want) {
+			t.Errorf("got %x, want %x", got, want)
+		}
+		promoted, _ := FromHashes(leaves)
+		if bytes.Equal(promoted.Root(), tree.Root()) {
+			t.Error("strategies should give different roots")
+		}
+	})
+
+	t.Run("proofs", func(t *testing.T) {
+		for _, oddNode := range OddNodes() {
+			var inputs []string
+			for size := 1; size <= 17; size++ {
+				inputs = append(inputs, strconv.Itoa(size))
+				tree, err := From(stringsToBytes(inputs), WithMode(DomainSeparated), WithOddNode(oddNode))
+				if err != nil {
+					t.Fatal(err)
+				}
+				leaves := leavesOf(tree)
+				for i := range leaves {
+					proof, err := tree.ProofForIndex(i)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if err := proof.Verify(leaves[i], tree.Root()); err != nil {
+						t.Errorf("%s %d leaves, index %d: %v", oddNode, size, i, err)
+					}
+				}
+			}
+		}
+	})
+
+	t.Run("append", func(t *testing.T) {
+		inputs := stringsToBytes([]string{"a", "b", "c", "d", "e", "f", "g"})
+		tree, err := From(inputs[:3], WithOddNode(Duplicate))
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts := newOptions([]Option{WithOddNode(Duplicate)})
+		for i, input := range inputs[3:] {
+			if err := tree.Append(opts.leaf(input)); err != nil {
+				t.Fatal(err)
+			}
+			want, _ := From(inputs[:4+i], WithOddNode(Duplicate))
+			if got, want := tree.Root(), want.Root(); !bytes.Equal(got, want) {
+				t.Errorf("%d leaves: got %x, want %x", 4+i, got, want)
+			}
+		}
+		if _, err := tree.ConsistencyProof(3); err == nil {
+			t.Error("duplicate trees should have no consistency proof")
+		}
+	})
+
+	if _, err := From([][]byte{[]byte("a")}, WithOddNode("unknown")); err == nil {
+		t.Error("unknown odd node strategy should fail")
+	}
+}
@@ -0,0 +1,171 @@
+package merkletree
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// MultiProof links several leaves of a tree of size leaves to its root at
+// once. A sibling is only kept when it cannot be computed from the proven
+// leaves, so nodes shared by their paths are sent a single time.
+type MultiProof struct {
+	opts     options
+	indexes  []int
+	size     int
+	siblings [][]byte
+}
+
+// NewMultiProof returns the proof of the leaves at indexes, sorted in
+// increasing order, of a tree of size leaves. opts must match the ones of the
+// tree it comes from.
+func NewMultiProof(indexes []int, size int, siblings [][]byte, opts ...Option) *MultiProof {
+	return &MultiProof{
+		indexes:  indexes,
+		size:     size,
+		siblings: siblings,
+		opts:     newOptions(opts),
+	}
+}
+
+// MultiProofFor returns the proof of the leaves at indexes, duplicated
+// indexes are proven once.
+func (tree *MerkleTree) MultiProofFor(indexes []int) (*MultiProof, error) {
+	known, err := sortIndexes(indexes, tree.size)
+	if err != nil {
+		return nil, err
+	}
+	proven := known
+
+	var siblings [][]byte
+	for _, level := range tree.levels[:len(tree.levels)-1] {
+		var parents []int
+		for i := 0; i < len(known); i++ {
+			p := known[i]
+			switch {
+			case p%2 == 0 && p == level.len()-1:
+				// the last node of an odd level has no sibling
+			case p%2 == 0 && i+1 < len(known) && known[i+1] == p+1:
+				i++
+			case p%2 == 0:
+				siblings = append(siblings, bytes.Clone(level.at(p+1)))
+			default:
+				siblings = append(siblings, bytes.Clone(level.at(p-1)))
+			}
+			parents = append(parents, p/2)
+		}
+		known = parents
+	}
+	return NewMultiProof(proven, tree.size, siblings, tree.opts.option()), nil
+}
+
+// Verify checks that leaves, given in the order of Indexes, are part of the
+// tree of root.
+func (proof MultiProof) Verify(leaves [][]byte, root []byte) error {
+	if err := proof.opts.validate(); err != nil {
+		return err
+	}
+	if len(leaves) != len(proof.indexes) {
+		return fmt.Errorf("got %d leaves for %d indexes", len(leaves), len(proof.indexes))
+	}
+	positions, err := sortIndexes(proof.indexes, proof.size)
+	if err != nil {
+		return err
+	}
+	if len(positions) != len(proof.indexes) {
+		return fmt.Errorf("duplicated indexes")
+	}
+	for i := range positions {
+		if positions[i] != proof.indexes[i] {
+			return fmt.Errorf("indexes are not sorted")
+		}
+	}
+
+	nodes := leaves
+	siblings := proof.siblings
+	next := func() ([]byte, error) {
+		if len(siblings) == 0 {
+			return nil, fmt.Errorf("missing siblings for %d leaves of %d", len(proof.indexes), proof.size)
+		}
+		sibling := siblings[0]
+		siblings = siblings[1:]
+		return sibling, nil
+	}
+	for size := proof.size; size > 1; size = (size + 1) / 2 {
+		var parents []int
+		var hashes [][]byte
+		for i := 0; i < len(positions); i++ {
+			p, h := positions[i], nodes[i]
+			switch {
+			case p%2 == 0 && p == size-1:
+				h = proof.opts.unpaired(h)
+			case p%2 == 0 && i+1 < len(positions) && positions[i+1] == p+1:
+				h = proof.opts.node(h, nodes[i+1])
+				i++
+			case p%2 == 0:
+				sibling, err := next()
+				if err != nil {
+					return err
+				}
+				h = proof.opts.node(h, sibling)
+			default:
+				sibling, err := next()
+				if err != nil {
+					return err
+				}
+				h = proof.opts.node(sibling, h)
+			}
+			parents = append(parents, p/2)
+			hashes = append(hashes, h)
+		}
+		positions, nodes = parents, hashes
+	}
+	if len(siblings) != 0 {
+		return fmt.Errorf("%d unused siblings", len(siblings))
+	}
+	if !bytes.Equal(nodes[0], root) {
+		return fmt.Errorf("root mismatch, got %x want %x", nodes[0], root)
+	}
+	return nil
+}
+
+// Hash returns the name of the hash algorithm of the tree.
+func (proof MultiProof) Hash() string {
+	return proof.opts.hashName
+}
+
+// Indexes returns the positions of the proven leaves in increasing order.
+func (proof MultiProof) Indexes() []int {
+	return proof.indexes
+}
+
+// Size returns the number of leaves of the tree.
+func (proof MultiProof) Size() int {
+	return proof.size
+}
+
+// Siblings returns the hashes needed to recompute the root from the leaves,
+// level by level from the bottom of the tree and from left to right.
+func (proof MultiProof) Siblings() [][]byte {
+	return proof.siblings
+}
+
+// sortIndexes returns the sorted and deduplicated indexes after checking they
+// are leaves of a tree of size leaves.
+func sortIndexes(indexes []int, size int) ([]int, error) {
+	if len(indexes) == 0 {
+		return nil, fmt.Errorf("no index to prove")
+	}
+	sorted := append([]int(nil), indexes...)
+	sort.Ints(sorted)
+	unique := sorted[:0]
+	for i, index := range sorted {
+		if index < 0 || index >= size {
+			return nil, fmt.Errorf("index %d out of range for %d leaves", index, size)
+		}
+		if i == 0 || index != sorted[i-1] {
+			unique = append(unique, index)
+		}
+	}
+	return unique, nil
+}
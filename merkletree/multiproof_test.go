@@ -0,0 +1,128 @@
+package merkletree
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestMultiProof(t *testing.T) {
+	t.Run("every subset", func(t *testing.T) {
+		for size := 1; size <= 9; size++ {
+			for _, oddNode := range OddNodes() {
+				var inputs []string
+				for i := 0; i < size; i++ {
+					inputs = append(inputs, strconv.Itoa(i))
+				}
+				tree, err := From(stringsToBytes(inputs), WithMode(DomainSeparated), WithOddNode(oddNode))
+				if err != nil {
+					t.Fatal(err)
+				}
+				leaves := leavesOf(tree)
+				for subset := 1; subset < 1<<size; subset++ {
+					var indexes [][]byte
+					var positions []int
+					single := 0
+					for i := 0; i < size; i++ {
+						if subset&(1<<i) != 0 {
+							positions = append(positions, i)
+							indexes = append(indexes, leaves[i])
+							proof, _ := tree.ProofForIndex(i)
+							single += len(proof.Siblings())
+						}
+					}
+					proof, err := tree.MultiProofFor(positions)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if err := proof.Verify(indexes, tree.Root()); err != nil {
+						t.Errorf("%s %d leaves %v: %v", oddNode, size, positions, err)
+					}
+					if len(proof.Siblings()) > single {
+						t.Errorf("%d leaves %v: %d siblings, more than %d for single proofs", size, positions, len(proof.Siblings()), single)
+					}
+				}
+			}
+		}
+	})
+
+	t.Run("shared nodes", func(t *testing.T) {
+		tree, _ := From(stringsToBytes([]string{"a", "b", "c", "d", "e", "f", "g", "h"}))
+		cases := []struct {
+			indexes  []int
+			siblings int
+		}{
+			{[]int{0}, 3},
+			{[]int{0, 1}, 2},
+			{[]int{0, 2}, 3},
+			{[]int{0, 1, 2, 3}, 1},
+			{[]int{3, 0, 3}, 3},
+			{[]int{0, 1, 2, 3, 4, 5, 6, 7}, 0},
+		}
+		for _, c := range cases {
+			t.Run(fmt.Sprint(c.indexes), func(t *testing.T) {
+				proof, err := tree.MultiProofFor(c.indexes)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, want := len(proof.Siblings()), c.siblings; got != want {
+					t.Errorf("got %v, want %v", got, want)
+				}
+			})
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		tree, _ := From(stringsToBytes([]string{"a", "b", "c", "d", "e"}))
+		leaves := leavesOf(tree)
+		for _, indexes := range [][]int{nil, {5}, {-1}} {
+			if _, err := tree.MultiProofFor(indexes); err == nil {
+				t.Errorf("%v: proof should fail", indexes)
+			}
+		}
+
+		proof, _ := tree.MultiProofFor([]int{1, 3})
+		siblings := proof.Siblings()
+		cases := []struct {
+			name   string
+			proof  *MultiProof
+			leaves [][]byte
+		}{
+			{"swapped leaves", proof, [][]byte{leaves[3], leaves[1]}},
+			{"missing leaf", proof, [][]byte{leaves[1]}},
+			{"other indexes", NewMultiProof([]int{0, 2}, 5, siblings), [][]byte{leaves[1], leaves[3]}},
+			{"unsorted indexes", NewMultiProof([]int{3, 1}, 5, siblings), [][]byte{leaves[3], leaves[1]}},
+			{"duplicated indexes", NewMultiProof([]int{1, 1}, 5, siblings), [][]byte{leaves[1], leaves[1]}},
+			{"other size", NewMultiProof([]int{1, 3}, 4, siblings), [][]byte{leaves[1], leaves[3]}},
+			{"missing sibling", NewMultiProof([]int{1, 3}, 5, siblings[1:]), [][]byte{leaves[1], leaves[3]}},
+			{"extra sibling", NewMultiProof([]int{1, 3}, 5, append(siblings, leaves[0])), [][]byte{leaves[1], leaves[3]}},
+		}
+		for _, c := range cases {
+			if err := c.proof.Verify(c.leaves, tree.Root()); err == nil {
+				t.Errorf("%s: proof should not verify", c.name)
+			}
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		tree, _ := From(stringsToBytes([]string{"a", "b", "c", "d", "e"}), WithHashName(BLAKE3))
+		leaves := leavesOf(tree)
+		proof, _ := tree.MultiProofFor([]int{0, 4})
+		b, err := json.Marshal(proof)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded MultiProof
+		if err := json.Unmarshal(b, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := decoded.Indexes(), []int{0, 4}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if err := decoded.Verify([][]byte{leaves[0], leaves[4]}, tree.Root()); err != nil {
+			t.Error(err)
+		}
+	})
+}
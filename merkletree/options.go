@@ -0,0 +1,187 @@
+package merkletree
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"runtime"
+)
+
+// Mode selects how leaves and interior nodes are hashed.
+type Mode string
+
+const (
+	// Plain hashes leaves and nodes the same way, an interior node can then
+	// be passed off as a leaf.
+	Plain Mode = "plain"
+	// DomainSeparated prefixes leaves with 0x00 and interior nodes with 0x01
+	// as in RFC 6962.
+	DomainSeparated Mode = "rfc6962"
+)
+
+// OddNode selects what becomes of the last node of a level with an odd
+// number of nodes.
+type OddNode string
+
+const (
+	// Promote moves the last node up as is. Trees then have the shape of RFC
+	// 6962 trees, whose leaves are split at the largest power of two.
+	Promote OddNode = "promote"
+	// Duplicate pairs the last node with itself as in Bitcoin. Such trees
+	// have no consistency proofs.
+	Duplicate OddNode = "duplicate"
+)
+
+// OddNodes returns every supported odd node strategy.
+func OddNodes() []OddNode {
+	return []OddNode{Promote, Duplicate}
+}
+
+const (
+	leafPrefix = 0x00
+	nodePrefix = 0x01
+)
+
+type options struct {
+	newHash  func() hash.Hash
+	hashName string // empty for hashes given by WithHash
+	mode     Mode
+	oddNode  OddNode
+	workers  int
+	err      error
+}
+
+// Option configures how trees are built, the default is sha256 in Plain mode.
+type Option func(*options)
+
+// WithHash builds trees with the given hash algorithm. Proofs of such trees
+// cannot be encoded as the algorithm has no name, see WithHashName.
+func WithHash(newHash func() hash.Hash) Option {
+	return func(o *options) {
+		o.newHash, o.hashName, o.err = newHash, "", nil
+	}
+}
+
+// WithHashName builds trees with the supported hash algorithm called name,
+// building with an unknown name fails.
+func WithHashName(name string) Option {
+	return func(o *options) {
+		newHash, err := HashFunc(name)
+		if err != nil {
+			o.err = err
+			return
+		}
+		o.newHash, o.hashName, o.err = newHash, name, nil
+	}
+}
+
+// WithMode builds trees hashing leaves and nodes according to mode.
+func WithMode(mode Mode) Option {
+	return func(o *options) {
+		o.mode = mode
+	}
+}
+
+// WithOddNode builds trees handling the last node of odd levels according to
+// strategy.
+func WithOddNode(strategy OddNode) Option {
+	return func(o *options) {
+		o.oddNode = strategy
+	}
+}
+
+// WithWorkers hashes large levels of the tree with up to n goroutines, 1
+// hashes sequentially. The default is runtime.GOMAXPROCS, the tree does not
+// depend on it.
+func WithWorkers(n int) Option {
+	return func(o *options) {
+		o.workers = n
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		newHash:  sha256.New,
+		hashName: SHA256,
+		mode:     Plain,
+		oddNode:  Promote,
+		workers:  runtime.GOMAXPROCS(0),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// option returns an Option restoring o, used to hand the configuration of a
+// tree over to its builders and proofs.
+func (o options) option() Option {
+	return func(target *options) {
+		*target = o
+	}
+}
+
+func (o options) validate() error {
+	if o.err != nil {
+		return o.err
+	}
+	switch o.mode {
+	case Plain, DomainSeparated:
+	default:
+		return fmt.Errorf("unknown tree mode %q", o.mode)
+	}
+	switch o.oddNode {
+	case Promote, Duplicate:
+		return nil
+	default:
+		return fmt.Errorf("unknown odd node strategy %q", o.oddNode)
+	}
+}
+
+// unpaired returns the parent of the last node h of an odd level.
+func (o options) unpaired(h []byte) []byte {
+	if o.oddNode == Duplicate {
+		return o.node(h, h)
+	}
+	return h
+}
+
+// leafHasher returns a hash ready to receive the content of a leaf.
+func (o options) leafHasher() hash.Hash {
+	h := o.newHash()
+	if o.mode == DomainSeparated {
+		h.Write([]byte{leafPrefix})
+	}
+	return h
+}
+
+func (o options) leaf(parts ...[]byte) []byte {
+	return o.leafWith(o.newHash(), nil, parts...)
+}
+
+func (o options) node(left, right []byte) []byte {
+	return o.nodeWith(o.newHash(), nil, left, right)
+}
+
+// leafWith hashes a leaf with h, appending the result to dst, so building a
+// level reuses a single hash and buffer.
+func (o options) leafWith(h hash.Hash, dst []byte, parts ...[]byte) []byte {
+	h.Reset()
+	if o.mode == DomainSeparated {
+		h.Write([]byte{leafPrefix})
+	}
+	for _, part := range parts {
+		h.Write(part)
+	}
+	return h.Sum(dst)
+}
+
+func (o options) nodeWith(h hash.Hash, dst, left, right []byte) []byte {
+	h.Reset()
+	if o.mode == DomainSeparated {
+		h.Write([]byte{nodePrefix})
+	}
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(dst)
+}
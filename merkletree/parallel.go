@@ -0,0 +1,54 @@
+package merkletree
+
+import (
+	"hash"
+	"sync"
+)
+
+// minParallelHashes is the least number of hashes given to a worker, below it
+// spreading a level over goroutines costs more than it saves.
+const minParallelHashes = 1 << 12
+
+// parallel calls work on contiguous chunks of [0, n) from up to workers
+// goroutines.
+func parallel(n, workers int, work func(start, end int)) {
+	workers = min(workers, n/minParallelHashes)
+	if workers <= 1 {
+		work(0, n)
+		return
+	}
+	var wg sync.WaitGroup
+	chunk := (n + workers - 1) / workers
+	for start := 0; start < n; start += chunk {
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			work(start, end)
+		}(start, min(start+chunk, n))
+	}
+	wg.Wait()
+}
+
+// appendHashes appends n hashes to l, hashFunc appends the i-th one to dst
+// using h. When the width of the hashes is known they are written in place by
+// the workers of the tree, each with its own hash.
+func (tree *MerkleTree) appendHashes(l *level, n int, hashFunc func(h hash.Hash, dst []byte, i int) []byte) {
+	width := tree.opts.newHash().Size()
+	if width == 0 || l.ends != nil || (l.count > 0 && l.width != width) {
+		h := tree.opts.newHash()
+		var sum []byte
+		for i := 0; i < n; i++ {
+			sum = hashFunc(h, sum[:0], i)
+			l.append(sum)
+		}
+		return
+	}
+	first := l.extend(n, width)
+	parallel(n, tree.opts.workers, func(start, end int) {
+		h := tree.opts.newHash()
+		for i := start; i < end; i++ {
+			at := (first + i) * width
+			hashFunc(h, l.hashes[at:at:at+width], i)
+		}
+	})
+}
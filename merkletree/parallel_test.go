@@ -0,0 +1,103 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestParallel(t *testing.T) {
+	for _, size := range []int{1, 2, 3, 2*minParallelHashes + 1, 5*minParallelHashes + 3} {
+		var inputs [][]byte
+		for i := 0; i < size; i++ {
+			inputs = append(inputs, []byte(strconv.Itoa(i)))
+		}
+		for _, mode := range []Mode{Plain, DomainSeparated} {
+			t.Run(fmt.Sprintf("%d %s", size, mode), func(t *testing.T) {
+				sequential, err := From(inputs, WithMode(mode), WithWorkers(1))
+				if err != nil {
+					t.Fatal(err)
+				}
+				parallel, err := From(inputs, WithMode(mode), WithWorkers(8))
+				if err != nil {
+					t.Fatal(err)
+				}
+				for i := 0; i < sequential.Height(); i++ {
+					want, _ := sequential.Level(i)
+					got, _ := parallel.Level(i)
+					if !reflect.DeepEqual(got, want) {
+						t.Fatalf("level %d differs", i)
+					}
+				}
+
+				appended, err := From(inputs[:size/2+1], WithMode(mode), WithWorkers(8))
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, input := range inputs[size/2+1:] {
+					if err := appended.Append(appended.opts.leaf(input)); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if got, want := appended.Root(), sequential.Root(); !bytes.Equal(got, want) {
+					t.Errorf("got %x, want %x", got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestIndexedBuilderAddAll(t *testing.T) {
+	const size = 1000
+	hashOf := func(index int) ([]byte, error) {
+		h := sha256.Sum256([]byte(strconv.Itoa(index)))
+		return h[:], nil
+	}
+
+	sequential := NewIndexedBuilder(size)
+	for i := 0; i < size; i++ {
+		h, _ := hashOf(i)
+		if _, err := sequential.AddHash(i, h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected, _ := sequential.Build()
+
+	builder := NewIndexedBuilder(size, WithWorkers(8))
+	h, _ := hashOf(3)
+	if _, err := builder.AddHash(3, h); err != nil {
+		t.Fatal(err)
+	}
+	if err := builder.AddAll(hashOf); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := builder.Count(), size; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	tree, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tree.Root(), expected.Root(); !bytes.Equal(got, want) {
+		t.Errorf("got %x, want %x", got, want)
+	}
+
+	errFailed := errors.New("failed")
+	failing := NewIndexedBuilder(size, WithWorkers(8))
+	err = failing.AddAll(func(index int) ([]byte, error) {
+		if index == 500 {
+			return nil, errFailed
+		}
+		return hashOf(index)
+	})
+	if !errors.Is(err, errFailed) {
+		t.Errorf("got %v, want %v", err, errFailed)
+	}
+	if failing.Has(500) || failing.Count() == size {
+		t.Error("failed index should not be added")
+	}
+}
@@ -3,35 +3,57 @@ package merkletree
 import (
 	"bytes"
 	"fmt"
-	"hash"
 )
 
+// Proof links the leaf at index of a tree of size leaves to its root. Only
+// the siblings met on the way up are kept, the position of the leaf tells on
+// which side each of them goes.
 type Proof struct {
-	newHash func() hash.Hash
-	hashes  [][]byte
+	opts     options
+	index    int
+	size     int
+	siblings [][]byte
 }
 
-func NewProof(newHash func() hash.Hash, hashes [][]byte) *Proof {
+// NewProof returns the proof of the leaf at index in a tree of size leaves,
+// opts must match the ones of the tree it comes from.
+func NewProof(index, size int, siblings [][]byte, opts ...Option) *Proof {
 	return &Proof{
-		hashes:  hashes,
-		newHash: newHash,
+		index:    index,
+		size:     size,
+		siblings: siblings,
+		opts:     newOptions(opts),
 	}
 }
 
 func (proof Proof) Verify(leaf []byte, root []byte) error {
-	if !bytes.Equal(leaf, proof.hashes[0]) {
-		return fmt.Errorf("invalid start leaf")
+	if err := proof.opts.validate(); err != nil {
+		return err
 	}
-	if len(proof.hashes) == 1 {
-		return nil
+	if proof.index < 0 || proof.index >= proof.size {
+		return fmt.Errorf("index %d out of range for %d leaves", proof.index, proof.size)
 	}
 
 	h := leaf
-	for i := 1; i < len(proof.hashes); i = i + 2 {
-		if err := proof.validate(h, proof.hashes[i], proof.hashes[i+1]); err != nil {
-			return err
+	siblings := proof.siblings
+	for index, size := proof.index, proof.size; size > 1; index, size = index/2, (size+1)/2 {
+		if index%2 == 0 && index == size-1 {
+			// the last node of an odd level has no sibling to send
+			h = proof.opts.unpaired(h)
+			continue
 		}
-		h = proof.hashes[i+1]
+		if len(siblings) == 0 {
+			return fmt.Errorf("missing siblings for leaf %d of %d", proof.index, proof.size)
+		}
+		if index%2 == 1 {
+			h = proof.opts.node(siblings[0], h)
+		} else {
+			h = proof.opts.node(h, siblings[0])
+		}
+		siblings = siblings[1:]
+	}
+	if len(siblings) != 0 {
+		return fmt.Errorf("%d unused siblings for leaf %d of %d", len(siblings), proof.index, proof.size)
 	}
 	if !bytes.Equal(h, root) {
 		return fmt.Errorf("root mismatch, got %x want %x", h, root)
@@ -39,15 +61,34 @@ func (proof Proof) Verify(leaf []byte, root []byte) error {
 	return nil
 }
 
-func (proof Proof) validate(left, right, expected []byte) error {
-	hash1 := sum(proof.newHash, left, right)
-	hash2 := sum(proof.newHash, right, left)
-	if !bytes.Equal(hash1, expected) && !bytes.Equal(hash2, expected) {
-		return fmt.Errorf("cannot verify, calculated %x and %x but expected %x", hash1, hash2, expected)
-	}
-	return nil
+// Hash returns the name of the hash algorithm of the tree, empty when it was
+// built WithHash.
+func (proof Proof) Hash() string {
+	return proof.opts.hashName
+}
+
+// Mode returns the mode of the tree.
+func (proof Proof) Mode() Mode {
+	return proof.opts.mode
+}
+
+// OddNode returns the odd node strategy of the tree.
+func (proof Proof) OddNode() OddNode {
+	return proof.opts.oddNode
+}
+
+// Index returns the position of the proven leaf.
+func (proof Proof) Index() int {
+	return proof.index
+}
+
+// Size returns the number of leaves of the tree.
+func (proof Proof) Size() int {
+	return proof.size
 }
 
-func (proof Proof) Hashes() [][]byte {
-	return proof.hashes
+// Siblings returns the hashes needed to recompute the root from the leaf,
+// from the bottom of the tree to its top.
+func (proof Proof) Siblings() [][]byte {
+	return proof.siblings
 }
@@ -0,0 +1,281 @@
+package merkletree
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// SparseMerkleTree commits to a set of key value pairs. It has a leaf for
+// every possible hash of a key, the entry of a key sits at the leaf whose
+// position is the hash of the key and every other leaf is empty, so the
+// proof of an empty leaf proves a key is not in the tree.
+//
+// The leaf of an entry is the leaf hash of the key hash and the value, an
+// empty leaf is made of zeros. Only the subtrees holding several entries are
+// kept, the others are hashed when needed.
+type SparseMerkleTree struct {
+	opts    options
+	entries []sparseEntry // sorted by path
+	// empty holds the root of an empty subtree of every height
+	empty [][]byte
+	nodes map[sparseNode][]byte
+	root  []byte
+}
+
+type sparseEntry struct {
+	key   string
+	path  []byte
+	value []byte
+	leaf  []byte
+}
+
+// sparseNode is a subtree holding several entries, identified by its depth
+// and its first entry.
+type sparseNode struct {
+	depth, first int
+}
+
+// NewSparse builds the sparse tree of entries, mapping keys to values which
+// are usually hashes. The hash must have a fixed size.
+func NewSparse(entries map[string][]byte, opts ...Option) (*SparseMerkleTree, error) {
+	tree := &SparseMerkleTree{
+		opts:  newOptions(opts),
+		nodes: make(map[sparseNode][]byte),
+	}
+	if err := tree.opts.validate(); err != nil {
+		return nil, err
+	}
+	empty, err := emptyHashes(tree.opts)
+	if err != nil {
+		return nil, err
+	}
+	tree.empty = empty
+
+	for key, value := range entries {
+		path := tree.opts.path(key)
+		tree.entries = append(tree.entries, sparseEntry{
+			key:   key,
+			path:  path,
+			value: bytes.Clone(value),
+			leaf:  tree.opts.leaf(path, value),
+		})
+	}
+	sort.Slice(tree.entries, func(i, j int) bool {
+		return bytes.Compare(tree.entries[i].path, tree.entries[j].path) < 0
+	})
+	for i := 1; i < len(tree.entries); i++ {
+		if bytes.Equal(tree.entries[i-1].path, tree.entries[i].path) {
+			return nil, fmt.Errorf("keys %q and %q have the same hash", tree.entries[i-1].key, tree.entries[i].key)
+		}
+	}
+	tree.root = tree.subtree(0, 0, len(tree.entries))
+	return tree, nil
+}
+
+func (tree *SparseMerkleTree) Root() []byte {
+	return bytes.Clone(tree.root)
+}
+
+// Len returns the number of entries of the tree.
+func (tree *SparseMerkleTree) Len() int {
+	return len(tree.entries)
+}
+
+// Get returns the value of key.
+func (tree *SparseMerkleTree) Get(key string) ([]byte, bool) {
+	i, found := tree.search(tree.opts.path(key))
+	if !found {
+		return nil, false
+	}
+	return bytes.Clone(tree.entries[i].value), true
+}
+
+// ProofFor returns the proof of the leaf of key, it proves the value of key
+// when the tree holds it and its absence otherwise.
+func (tree *SparseMerkleTree) ProofFor(key string) *SparseProof {
+	path := tree.opts.path(key)
+	height := tree.height()
+	siblings := make([][]byte, height)
+	lo, hi := 0, len(tree.entries)
+	depth := 0
+	for ; depth < height && hi-lo > 1; depth++ {
+		mid := tree.split(depth, lo, hi)
+		if bit(path, depth) == 0 {
+			siblings[height-depth-1] = tree.subtree(depth+1, mid, hi)
+			hi = mid
+		} else {
+			siblings[height-depth-1] = tree.subtree(depth+1, lo, mid)
+			lo = mid
+		}
+	}
+	if hi-lo == 1 && !bytes.Equal(tree.entries[lo].path, path) {
+		// the subtree of the path holds another entry only, its siblings are
+		// empty up to the depth where both paths part
+		for bit(tree.entries[lo].path, depth) == bit(path, depth) {
+			depth++
+		}
+		siblings[height-depth-1] = tree.chain(depth+1, lo)
+	}
+	for h, sibling := range siblings {
+		if sibling != nil && bytes.Equal(sibling, tree.empty[h]) {
+			siblings[h] = nil
+		}
+	}
+	return NewSparseProof(siblings, tree.opts.option())
+}
+
+func (tree *SparseMerkleTree) height() int {
+	return len(tree.empty) - 1
+}
+
+func (tree *SparseMerkleTree) search(path []byte) (int, bool) {
+	i := sort.Search(len(tree.entries), func(i int) bool {
+		return bytes.Compare(tree.entries[i].path, path) >= 0
+	})
+	return i, i < len(tree.entries) && bytes.Equal(tree.entries[i].path, path)
+}
+
+// split returns the first entry of [lo, hi) going right at depth.
+func (tree *SparseMerkleTree) split(depth, lo, hi int) int {
+	return lo + sort.Search(hi-lo, func(i int) bool {
+		return bit(tree.entries[lo+i].path, depth) == 1
+	})
+}
+
+// subtree returns the root of the subtree at depth holding the entries
+// [lo, hi).
+func (tree *SparseMerkleTree) subtree(depth, lo, hi int) []byte {
+	switch hi - lo {
+	case 0:
+		return tree.empty[tree.height()-depth]
+	case 1:
+		return tree.chain(depth, lo)
+	}
+	if h, exist := tree.nodes[sparseNode{depth, lo}]; exist {
+		return h
+	}
+	mid := tree.split(depth, lo, hi)
+	h := tree.opts.node(tree.subtree(depth+1, lo, mid), tree.subtree(depth+1, mid, hi))
+	tree.nodes[sparseNode{depth, lo}] = h
+	return h
+}
+
+// chain returns the root of the subtree at depth holding the entry i only.
+func (tree *SparseMerkleTree) chain(depth, i int) []byte {
+	entry := tree.entries[i]
+	h := entry.leaf
+	for d := tree.height() - 1; d >= depth; d-- {
+		h = tree.opts.parent(h, tree.empty[tree.height()-d-1], bit(entry.path, d))
+	}
+	return h
+}
+
+// SparseProof links the leaf of a key to the root of a sparse tree, empty
+// siblings are nil.
+type SparseProof struct {
+	opts     options
+	siblings [][]byte
+}
+
+// NewSparseProof returns the proof made of siblings from the bottom of the
+// tree to its top, opts must match the ones of the tree it comes from.
+func NewSparseProof(siblings [][]byte, opts ...Option) *SparseProof {
+	return &SparseProof{
+		siblings: siblings,
+		opts:     newOptions(opts),
+	}
+}
+
+// Verify checks that the tree of root maps key to value.
+func (proof SparseProof) Verify(key string, value, root []byte) error {
+	return proof.verify(key, value, root)
+}
+
+// VerifyAbsence checks that key is not in the tree of root.
+func (proof SparseProof) VerifyAbsence(key string, root []byte) error {
+	return proof.verify(key, nil, root)
+}
+
+// verify checks the leaf of key holds value, or is empty for a nil value.
+func (proof SparseProof) verify(key string, value, root []byte) error {
+	if err := proof.opts.validate(); err != nil {
+		return err
+	}
+	empty, err := emptyHashes(proof.opts)
+	if err != nil {
+		return err
+	}
+	height := len(empty) - 1
+	if len(proof.siblings) != height {
+		return fmt.Errorf("got %d siblings for a tree of height %d", len(proof.siblings), height)
+	}
+	path := proof.opts.path(key)
+	h := empty[0]
+	if value != nil {
+		h = proof.opts.leaf(path, value)
+	}
+	for i, sibling := range proof.siblings {
+		if sibling == nil {
+			sibling = empty[i]
+		}
+		h = proof.opts.parent(h, sibling, bit(path, height-i-1))
+	}
+	if !bytes.Equal(h, root) {
+		return fmt.Errorf("root mismatch, got %x want %x", h, root)
+	}
+	return nil
+}
+
+// Hash returns the name of the hash algorithm of the tree, empty when it was
+// built WithHash.
+func (proof SparseProof) Hash() string {
+	return proof.opts.hashName
+}
+
+// Mode returns the mode of the tree.
+func (proof SparseProof) Mode() Mode {
+	return proof.opts.mode
+}
+
+// Siblings returns the hashes needed to recompute the root from the leaf,
+// from the bottom of the tree to its top, empty ones are nil.
+func (proof SparseProof) Siblings() [][]byte {
+	return proof.siblings
+}
+
+// emptyHashes returns the root of an empty subtree of every height of the
+// sparse trees built with o.
+func emptyHashes(o options) ([][]byte, error) {
+	size := o.newHash().Size()
+	if size == 0 {
+		return nil, fmt.Errorf("sparse trees need a hash of fixed size")
+	}
+	empty := make([][]byte, 8*size+1)
+	empty[0] = make([]byte, size)
+	for h := 1; h < len(empty); h++ {
+		empty[h] = o.node(empty[h-1], empty[h-1])
+	}
+	return empty, nil
+}
+
+// path returns the position of the leaf of key in a sparse tree.
+func (o options) path(key string) []byte {
+	h := o.newHash()
+	h.Write([]byte(key))
+	return h.Sum(nil)
+}
+
+// parent returns the parent of h and its sibling, side tells whether h
+// is the left (0) or the right (1) child.
+func (o options) parent(h, sibling []byte, side byte) []byte {
+	if side == 0 {
+		return o.node(h, sibling)
+	}
+	return o.node(sibling, h)
+}
+
+// bit returns the bit of path at depth, from the most significant one.
+func bit(path []byte, depth int) byte {
+	return path[depth/8] >> (7 - depth%8) & 1
+}
@@ -0,0 +1,181 @@
+package merkletree
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"testing"
+)
+
+func TestSparseMerkleTree(t *testing.T) {
+	t.Run("root", func(t *testing.T) {
+		opts := newOptions(nil)
+		empty, _ := emptyHashes(opts)
+		entries := make(map[string][]byte)
+		for size := 0; size <= 20; size++ {
+			tree, err := NewSparse(entries)
+			if err != nil {
+				t.Fatal(err)
+			}
+			leaves := make(map[string][]byte)
+			for key, value := range entries {
+				path := opts.path(key)
+				leaves[string(path)] = opts.leaf(path, value)
+			}
+			if got, want := tree.Root(), referenceSparseRoot(opts, empty, leaves, 0, nil); !bytes.Equal(got, want) {
+				t.Errorf("%d entries: got %x, want %x", size, got, want)
+			}
+			if got, want := tree.Len(), size; got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+			entries["key "+strconv.Itoa(size)] = []byte(strconv.Itoa(size))
+		}
+	})
+
+	t.Run("proofs", func(t *testing.T) {
+		for _, mode := range []Mode{Plain, DomainSeparated} {
+			entries := make(map[string][]byte)
+			for size := 0; size <= 20; size++ {
+				tree, err := NewSparse(entries, WithMode(mode))
+				if err != nil {
+					t.Fatal(err)
+				}
+				root := tree.Root()
+				for key, value := range entries {
+					got, found := tree.Get(key)
+					if !found || !bytes.Equal(got, value) {
+						t.Errorf("got %s %v, want %s", got, found, value)
+					}
+					proof := tree.ProofFor(key)
+					if err := proof.Verify(key, value, root); err != nil {
+						t.Errorf("%s %d entries, %s: %v", mode, size, key, err)
+					}
+					if err := proof.Verify(key, []byte("other"), root); err == nil {
+						t.Errorf("%s %d entries, %s: other value should not verify", mode, size, key)
+					}
+					if err := proof.VerifyAbsence(key, root); err == nil {
+						t.Errorf("%s %d entries, %s: absence should not verify", mode, size, key)
+					}
+				}
+				for _, key := range []string{"missing", "key " + strconv.Itoa(size)} {
+					if _, found := tree.Get(key); found {
+						t.Errorf("%s should not be found", key)
+					}
+					proof := tree.ProofFor(key)
+					if err := proof.VerifyAbsence(key, root); err != nil {
+						t.Errorf("%s %d entries, %s: %v", mode, size, key, err)
+					}
+					if err := proof.Verify(key, []byte("value"), root); err == nil {
+						t.Errorf("%s %d entries, %s: inclusion should not verify", mode, size, key)
+					}
+				}
+				entries["key "+strconv.Itoa(size)] = []byte(strconv.Itoa(size))
+			}
+		}
+	})
+
+	t.Run("modes", func(t *testing.T) {
+		entries := map[string][]byte{"a": []byte("1"), "b": []byte("2")}
+		plain, _ := NewSparse(entries)
+		separated, _ := NewSparse(entries, WithMode(DomainSeparated))
+		if bytes.Equal(plain.Root(), separated.Root()) {
+			t.Error("modes should give different roots")
+		}
+		if err := plain.ProofFor("a").Verify("a", []byte("1"), separated.Root()); err == nil {
+			t.Error("proof should not verify in another mode")
+		}
+	})
+
+	t.Run("encoding", func(t *testing.T) {
+		for _, name := range HashNames() {
+			entries := map[string][]byte{"a": []byte("1"), "b": []byte("2"), "c": []byte("3")}
+			tree, err := NewSparse(entries, WithHashName(name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, key := range []string{"a", "d"} {
+				proof := tree.ProofFor(key)
+				b, err := proof.MarshalBinary()
+				if err != nil {
+					t.Fatal(err)
+				}
+				var fromBinary SparseProof
+				if err := fromBinary.UnmarshalBinary(b); err != nil {
+					t.Fatal(err)
+				}
+				j, err := json.Marshal(proof)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var fromJSON SparseProof
+				if err := json.Unmarshal(j, &fromJSON); err != nil {
+					t.Fatal(err)
+				}
+				for _, decoded := range []SparseProof{fromBinary, fromJSON} {
+					if got, want := decoded.Hash(), name; got != want {
+						t.Errorf("got %v, want %v", got, want)
+					}
+					value, found := tree.Get(key)
+					if found {
+						err = decoded.Verify(key, value, tree.Root())
+					} else {
+						err = decoded.VerifyAbsence(key, tree.Root())
+					}
+					if err != nil {
+						t.Errorf("%s %s: %v", name, key, err)
+					}
+				}
+			}
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		if _, err := NewSparse(nil, WithHash(newFakeHash)); err == nil {
+			t.Error("hash without fixed size should fail")
+		}
+		if _, err := NewSparse(nil, WithMode("unknown")); err == nil {
+			t.Error("unknown mode should fail")
+		}
+		tree, _ := NewSparse(map[string][]byte{"a": []byte("1")})
+		proof := tree.ProofFor("a")
+		b, _ := proof.MarshalBinary()
+		for _, data := range [][]byte{b[:10], append(append([]byte{}, b...), 0), append([]byte{3}, b[1:]...)} {
+			var decoded SparseProof
+			if err := decoded.UnmarshalBinary(data); err == nil {
+				t.Errorf("decoding %x should fail", data)
+			}
+		}
+		short := NewSparseProof(proof.Siblings()[1:])
+		if err := short.Verify("a", []byte("1"), tree.Root()); err == nil {
+			t.Error("proof missing a level should not verify")
+		}
+	})
+}
+
+// referenceSparseRoot hashes the subtree at depth under prefix by filtering
+// the leaves, keyed by path, on every level.
+func referenceSparseRoot(o options, empty [][]byte, leaves map[string][]byte, depth int, prefix []byte) []byte {
+	height := len(empty) - 1
+	var under []string
+	for path := range leaves {
+		match := true
+		for d := 0; d < depth; d++ {
+			if bit([]byte(path), d) != prefix[d] {
+				match = false
+				break
+			}
+		}
+		if match {
+			under = append(under, path)
+		}
+	}
+	switch {
+	case len(under) == 0:
+		return empty[height-depth]
+	case depth == height:
+		return leaves[under[0]]
+	}
+	left := referenceSparseRoot(o, empty, leaves, depth+1, append(prefix[:depth:depth], 0))
+	right := referenceSparseRoot(o, empty, leaves, depth+1, append(prefix[:depth:depth], 1))
+	return o.node(left, right)
+}
@@ -2,16 +2,67 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/textproto"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+
+	"github.com/tclairet/merklestore/merkletree"
 )
 
 const (
 	uploadRoute  = "/upload"
 	requestRoute = "/request"
+	rootRoute    = "/roots/{root}"
+	statusRoute  = "/roots/{root}/status"
+	batchRoute   = "/roots/{root}/files"
+	filesRoute   = "/roots/{root}/files/{index}"
+	proofRoute   = "/roots/{root}/files/{index}/proof"
+	blockRoute   = "/roots/{root}/files/{index}/blocks/{block}"
+	hashesRoute  = "/roots/{root}/hashes"
+	// consistencyRoute proves that the batch of old is a previous version of
+	// the batch of root
+	consistencyRoute = "/roots/{root}/consistency/{old}"
+	// nameRoute and nameProofRoute serve the files of named batches, the name
+	// is given in the name query parameter
+	nameRoute      = "/roots/{root}/name"
+	nameProofRoute = "/roots/{root}/name/proof"
+	// listRoute lists the metadata of the files of named and Metadata batches
+	listRoute = "/roots/{root}/list"
+	// statsRoute reports the deduplication of the stored files
+	statsRoute = "/stats"
+	// haveRoute tells which files the server already stores
+	haveRoute = "/have"
+
+	totalHeader      = "X-Merkle-Total"
+	blockSizeHeader  = "X-Merkle-Block-Size"
+	proofHeader      = "X-Merkle-Proof"
+	blockProofHeader = "X-Merkle-Block-Proof"
+	fileHashHeader   = "X-Merkle-File-Hash"
+	hashHeader       = "X-Merkle-Hash"
+	blockCountHeader = "X-Merkle-Block-Count"
+	indexHeader      = "X-Merkle-Index"
+	parentHeader     = "X-Merkle-Parent"
+	oddNodeHeader    = "X-Merkle-Odd-Node"
+	nameHeader       = "X-Merkle-Name"
+	nameProofHeader  = "X-Merkle-Name-Proof"
+	manifestHeader   = "X-Merkle-Manifest"
+	fileInfoHeader   = "X-Merkle-File-Info"
+	storedHeader     = "X-Merkle-Stored"
 )
 
 type API struct {
@@ -29,14 +80,31 @@ func (api API) Routes() http.Handler {
 	// r.Use(httplog.RequestLogger(httplog.NewLogger("merkleStoreServer", httplog.Options{JSON: true})))
 	r.Post(uploadRoute, api.upload)
 	r.Post(requestRoute, api.request)
+	r.Post(haveRoute, api.have)
+	r.Put(filesRoute, api.uploadStream)
+	r.Get(batchRoute, api.downloadMany)
+	r.Get(filesRoute, api.download)
+	r.Get(proofRoute, api.proof)
+	r.Get(rootRoute, api.batch)
+	r.Delete(rootRoute, api.delete)
+	r.Get(statusRoute, api.status)
+	r.Get(blockRoute, api.block)
+	r.Get(hashesRoute, api.hashes)
+	r.Get(consistencyRoute, api.consistency)
+	r.Get(nameRoute, api.downloadName)
+	r.Get(nameProofRoute, api.nameProof)
+	r.Get(listRoute, api.list)
+	r.Get(statsRoute, api.stats)
 	return r
 }
 
 type UploadRequest struct {
-	Root    string `json:"root"`
-	Index   int    `json:"index"`
-	Total   int    `json:"total"`
-	Content []byte `json:"content"`
+	Root    string    `json:"root"`
+	Index   int       `json:"index"`
+	Total   int       `json:"total"`
+	Hash    string    `json:"hash,omitempty"`
+	Info    *FileInfo `json:"info,omitempty"`
+	Content []byte    `json:"content"`
 }
 
 func (api API) upload(w http.ResponseWriter, r *http.Request) {
@@ -46,21 +114,174 @@ func (api API) upload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := api.server.Upload(upload.Root, upload.Index, upload.Total, bytes.NewReader(upload.Content)); err != nil {
-		RespondWithError(w, http.StatusInternalServerError, err)
+	batch := Batch{Root: upload.Root, Total: upload.Total, Hash: upload.Hash}
+	if err := batch.validateRoot(batch.Root); err != nil {
+		RespondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	var err error
+	if upload.Info != nil {
+		err = api.server.UploadWithInfo(r.Context(), batch, upload.Index, *upload.Info, bytes.NewReader(upload.Content))
+	} else {
+		err = api.server.Upload(r.Context(), batch, upload.Index, bytes.NewReader(upload.Content))
+	}
+	if err != nil {
+		RespondWithError(w, uploadErrorCode(err), err)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
 }
 
+// uploadStream pipes the raw request body into the server, root and index
+// come from the path, the batch size from the X-Merkle-Total header, the
+// optional block size of chunked batches from X-Merkle-Block-Size, the
+// optional hash algorithm from X-Merkle-Hash, the root of the batch it
+// appends files to from X-Merkle-Parent, the path escaped name of the
+// files of named batches from X-Merkle-Name, whether the last file is a
+// manifest from X-Merkle-Manifest and the base64 JSON FileInfo of the files
+// of Metadata batches from X-Merkle-File-Info. With the hex hash of a file
+// the server stores in X-Merkle-Stored the body is ignored and the stored
+// file is used.
+func (api API) uploadStream(w http.ResponseWriter, r *http.Request) {
+	index, err := strconv.Atoi(chi.URLParam(r, "index"))
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, fmt.Errorf("invalid index: %w", err))
+		return
+	}
+	total, err := strconv.Atoi(r.Header.Get(totalHeader))
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, fmt.Errorf("invalid %s header: %w", totalHeader, err))
+		return
+	}
+	var blockSize int
+	if header := r.Header.Get(blockSizeHeader); header != "" {
+		if blockSize, err = strconv.Atoi(header); err != nil {
+			RespondWithError(w, http.StatusBadRequest, fmt.Errorf("invalid %s header: %w", blockSizeHeader, err))
+			return
+		}
+	}
+	var manifest bool
+	if header := r.Header.Get(manifestHeader); header != "" {
+		if manifest, err = strconv.ParseBool(header); err != nil {
+			RespondWithError(w, http.StatusBadRequest, fmt.Errorf("invalid %s header: %w", manifestHeader, err))
+			return
+		}
+	}
+
+	batch := Batch{
+		Root:      chi.URLParam(r, "root"),
+		Total:     total,
+		BlockSize: blockSize,
+		Hash:      r.Header.Get(hashHeader),
+		OddNode:   merkletree.OddNode(r.Header.Get(oddNodeHeader)),
+		Parent:    r.Header.Get(parentHeader),
+		Manifest:  manifest,
+	}
+	if err := batch.validateRoot(batch.Root); err != nil {
+		RespondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	name, err := url.PathUnescape(r.Header.Get(nameHeader))
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, fmt.Errorf("invalid %s header: %w", nameHeader, err))
+		return
+	}
+	info, err := decodeFileInfoHeader(r.Header)
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	if name != "" && info != nil {
+		RespondWithError(w, http.StatusBadRequest, fmt.Errorf("%w: files of named batches only have a name, got a %s header", ErrInvalidBatch, fileInfoHeader))
+		return
+	}
+	var stored []byte
+	if header := r.Header.Get(storedHeader); header != "" {
+		if stored, err = hex.DecodeString(header); err != nil {
+			RespondWithError(w, http.StatusBadRequest, fmt.Errorf("invalid %s header: %w", storedHeader, err))
+			return
+		}
+	}
+	switch {
+	case stored != nil && name != "":
+		batch.Named = true
+		err = api.server.UploadStored(r.Context(), batch, index, FileInfo{Name: name}, stored)
+	case stored != nil && info != nil:
+		batch.Metadata = true
+		err = api.server.UploadStored(r.Context(), batch, index, *info, stored)
+	case stored != nil:
+		err = api.server.UploadStored(r.Context(), batch, index, FileInfo{}, stored)
+	case name != "":
+		err = api.server.UploadNamed(r.Context(), batch, index, name, r.Body)
+	case info != nil:
+		err = api.server.UploadWithInfo(r.Context(), batch, index, *info, r.Body)
+	default:
+		err = api.server.Upload(r.Context(), batch, index, r.Body)
+	}
+	if err != nil {
+		RespondWithError(w, uploadErrorCode(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func uploadErrorCode(err error) int {
+	switch {
+	case errors.Is(err, ErrRootMismatch):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, ErrRootExists), errors.Is(err, ErrIndexReceived):
+		return http.StatusConflict
+	case errors.Is(err, ErrUnknownBlob):
+		return http.StatusNotFound
+	case errors.Is(err, ErrInvalidBatch):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// HaveRequest lists the hex hashes of files of a batch hashed with Hash,
+// BlockSize and OddNode.
+type HaveRequest struct {
+	BlockSize int                `json:"block_size,omitempty"`
+	Hash      string             `json:"hash,omitempty"`
+	OddNode   merkletree.OddNode `json:"odd_node,omitempty"`
+	Hashes    []string           `json:"hashes"`
+}
+
+// have answers whether the server stores each file of a HaveRequest, in the
+// order of its hashes.
+func (api API) have(w http.ResponseWriter, r *http.Request) {
+	var request HaveRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		RespondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	hashes := make([][]byte, len(request.Hashes))
+	for i, encoded := range request.Hashes {
+		var err error
+		if hashes[i], err = hex.DecodeString(encoded); err != nil {
+			RespondWithError(w, http.StatusBadRequest, fmt.Errorf("invalid hash %d: %w", i, err))
+			return
+		}
+	}
+	batch := Batch{BlockSize: request.BlockSize, Hash: request.Hash, OddNode: request.OddNode}
+	have, err := api.server.Have(r.Context(), batch, hashes)
+	if err != nil {
+		RespondWithError(w, requestErrorCode(err), err)
+		return
+	}
+	RespondWithJSON(w, http.StatusOK, have)
+}
+
 type RequestRequest struct {
 	Root  string `json:"root"`
 	Index int    `json:"index"`
 }
 
 type RequestResponse struct {
-	Content []byte   `json:"content"`
-	Proof   [][]byte `json:"proof"`
+	Content []byte            `json:"content"`
+	Proof   *merkletree.Proof `json:"proof"`
 }
 
 func (api API) request(w http.ResponseWriter, r *http.Request) {
@@ -69,11 +290,12 @@ func (api API) request(w http.ResponseWriter, r *http.Request) {
 		RespondWithError(w, http.StatusBadRequest, err)
 		return
 	}
-	reader, proof, err := api.server.Request(request.Root, request.Index)
+	reader, proof, err := api.server.Request(r.Context(), request.Root, request.Index)
 	if err != nil {
 		RespondWithError(w, http.StatusInternalServerError, err)
 		return
 	}
+	defer reader.Close()
 	content, err := io.ReadAll(reader)
 	if err != nil {
 		RespondWithError(w, http.StatusInternalServerError, err)
@@ -81,24 +303,405 @@ func (api API) request(w http.ResponseWriter, r *http.Request) {
 	}
 	response := RequestResponse{
 		Content: content,
-		Proof:   proof.Hashes(),
+		Proof:   proof,
 	}
 	RespondWithJSON(w, http.StatusOK, response)
 }
 
+// download streams a stored file, the siblings of its proof are sent in the
+// X-Merkle-Proof header, the number of files in X-Merkle-Total, the hash
+// algorithm in X-Merkle-Hash and the leaf hash is used as ETag. Range and conditional requests
+// are supported when the underlying file is seekable.
+func (api API) download(w http.ResponseWriter, r *http.Request) {
+	index, err := strconv.Atoi(chi.URLParam(r, "index"))
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, fmt.Errorf("invalid index: %w", err))
+		return
+	}
+	batch, err := api.server.Batch(r.Context(), chi.URLParam(r, "root"))
+	if err != nil {
+		RespondWithError(w, requestErrorCode(err), err)
+		return
+	}
+	reader, proof, err := api.server.Request(r.Context(), batch.Root, index)
+	if err != nil {
+		RespondWithError(w, requestErrorCode(err), err)
+		return
+	}
+	defer reader.Close()
+	leaf, err := api.server.Leaf(r.Context(), batch.Root, index)
+	if err != nil {
+		RespondWithError(w, requestErrorCode(err), err)
+		return
+	}
+	info, err := api.server.Info(r.Context(), batch.Root, index)
+	if err != nil {
+		RespondWithError(w, requestErrorCode(err), err)
+		return
+	}
+
+	w.Header().Set(proofHeader, encodeProofHeader(proof.Siblings()))
+	w.Header().Set(totalHeader, strconv.Itoa(proof.Size()))
+	w.Header().Set(hashHeader, batch.Hash)
+	w.Header().Set(oddNodeHeader, string(proof.OddNode()))
+	w.Header().Set("ETag", fmt.Sprintf(`"%x"`, leaf))
+	setFileHeaders(w, info)
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		var modTime time.Time
+		if info.ModTime != 0 {
+			modTime = time.Unix(info.ModTime, 0)
+		}
+		http.ServeContent(w, r, "", modTime, seeker)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.Copy(w, reader)
+}
+
+// downloadMany streams the files whose indexes are given by the index query
+// parameters with a single proof for all of them. The response is a
+// multipart/mixed body whose first part is the JSON multiproof, followed by a
+// part per file in increasing index order with its index in X-Merkle-Index.
+func (api API) downloadMany(w http.ResponseWriter, r *http.Request) {
+	var indexes []int
+	for _, value := range r.URL.Query()["index"] {
+		index, err := strconv.Atoi(value)
+		if err != nil {
+			RespondWithError(w, http.StatusBadRequest, fmt.Errorf("invalid index: %w", err))
+			return
+		}
+		indexes = append(indexes, index)
+	}
+	root := chi.URLParam(r, "root")
+	proof, err := api.server.MultiProof(r.Context(), root, indexes)
+	if err != nil {
+		RespondWithError(w, requestErrorCode(err), err)
+		return
+	}
+	b, err := json.Marshal(proof)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	parts := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+parts.Boundary())
+	w.WriteHeader(http.StatusOK)
+	part, err := parts.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/json"}})
+	if err == nil {
+		_, err = part.Write(b)
+	}
+	for _, index := range proof.Indexes() {
+		if err != nil {
+			break
+		}
+		err = api.writePart(r.Context(), parts, root, index)
+	}
+	if err != nil {
+		// the status is already sent, the client sees a truncated body
+		logger.Error("download", "root", root, "error", err.Error())
+		return
+	}
+	_ = parts.Close()
+}
+
+func (api API) writePart(ctx context.Context, parts *multipart.Writer, root string, index int) error {
+	file, err := api.server.Open(ctx, root, index)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	part, err := parts.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"application/octet-stream"},
+		indexHeader:    {strconv.Itoa(index)},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(part, file)
+	return err
+}
+
+// proof serves the canonical encoding of a file proof, in JSON unless the
+// binary one is requested with an application/octet-stream Accept header.
+func (api API) proof(w http.ResponseWriter, r *http.Request) {
+	index, err := strconv.Atoi(chi.URLParam(r, "index"))
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, fmt.Errorf("invalid index: %w", err))
+		return
+	}
+	proof, err := api.server.Proof(r.Context(), chi.URLParam(r, "root"), index)
+	if err != nil {
+		RespondWithError(w, requestErrorCode(err), err)
+		return
+	}
+	if r.Header.Get("Accept") != "application/octet-stream" {
+		RespondWithJSON(w, http.StatusOK, proof)
+		return
+	}
+	b, err := proof.MarshalBinary()
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(b)
+}
+
+func (api API) batch(w http.ResponseWriter, r *http.Request) {
+	batch, err := api.server.Batch(r.Context(), chi.URLParam(r, "root"))
+	if err != nil {
+		RespondWithError(w, requestErrorCode(err), err)
+		return
+	}
+	RespondWithJSON(w, http.StatusOK, batch)
+}
+
+// delete removes a batch, the files it shares with other batches are kept.
+func (api API) delete(w http.ResponseWriter, r *http.Request) {
+	if err := api.server.Delete(r.Context(), chi.URLParam(r, "root")); err != nil {
+		RespondWithError(w, requestErrorCode(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (api API) stats(w http.ResponseWriter, r *http.Request) {
+	RespondWithJSON(w, http.StatusOK, api.server.Stats(r.Context()))
+}
+
+// hashes serves the hex encoded file hashes of a batch, enough for a client
+// to check them against the root and compute the root of an extension.
+func (api API) hashes(w http.ResponseWriter, r *http.Request) {
+	hashes, err := api.server.Hashes(r.Context(), chi.URLParam(r, "root"))
+	if err != nil {
+		RespondWithError(w, requestErrorCode(err), err)
+		return
+	}
+	RespondWithJSON(w, http.StatusOK, encodeHashes(hashes))
+}
+
+func (api API) consistency(w http.ResponseWriter, r *http.Request) {
+	proof, err := api.server.Consistency(r.Context(), chi.URLParam(r, "old"), chi.URLParam(r, "root"))
+	if err != nil {
+		RespondWithError(w, requestErrorCode(err), err)
+		return
+	}
+	RespondWithJSON(w, http.StatusOK, proof)
+}
+
+func (api API) status(w http.ResponseWriter, r *http.Request) {
+	status, err := api.server.Status(r.Context(), chi.URLParam(r, "root"))
+	if err != nil {
+		RespondWithError(w, requestErrorCode(err), err)
+		return
+	}
+	RespondWithJSON(w, http.StatusOK, status)
+}
+
+// block serves a single block of a chunked file. The file proof is sent in
+// X-Merkle-Proof, the proof of the block inside the file in
+// X-Merkle-Block-Proof, the root of the file blocks in X-Merkle-File-Hash and
+// the hash algorithm in X-Merkle-Hash. X-Merkle-Total and X-Merkle-Block-Count
+// give the size of both trees.
+func (api API) block(w http.ResponseWriter, r *http.Request) {
+	index, err := strconv.Atoi(chi.URLParam(r, "index"))
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, fmt.Errorf("invalid index: %w", err))
+		return
+	}
+	block, err := strconv.Atoi(chi.URLParam(r, "block"))
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, fmt.Errorf("invalid block: %w", err))
+		return
+	}
+	reader, proof, err := api.server.RequestBlock(r.Context(), chi.URLParam(r, "root"), index, block)
+	if err != nil {
+		RespondWithError(w, requestErrorCode(err), err)
+		return
+	}
+	defer reader.Close()
+
+	w.Header().Set(proofHeader, encodeProofHeader(proof.File.Siblings()))
+	w.Header().Set(totalHeader, strconv.Itoa(proof.File.Size()))
+	w.Header().Set(blockProofHeader, encodeProofHeader(proof.Block.Siblings()))
+	w.Header().Set(blockCountHeader, strconv.Itoa(proof.Block.Size()))
+	w.Header().Set(fileHashHeader, hex.EncodeToString(proof.FileHash))
+	w.Header().Set(hashHeader, proof.Hash)
+	w.Header().Set(oddNodeHeader, string(proof.File.OddNode()))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.Copy(w, reader)
+}
+
+// downloadName streams the file of a named batch, its sparse proof is sent
+// base64 encoded in the X-Merkle-Name-Proof header.
+func (api API) downloadName(w http.ResponseWriter, r *http.Request) {
+	root, name := chi.URLParam(r, "root"), r.URL.Query().Get("name")
+	reader, proof, err := api.server.RequestName(r.Context(), root, name)
+	if err != nil {
+		RespondWithError(w, requestErrorCode(err), err)
+		return
+	}
+	defer reader.Close()
+	b, err := proof.MarshalBinary()
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set(nameProofHeader, base64.StdEncoding.EncodeToString(b))
+	w.Header().Set(hashHeader, proof.Hash())
+	setFileHeaders(w, FileInfo{Name: path.Base(name)})
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.Copy(w, reader)
+}
+
+// NameProofResponse proves the file hash of a name of a named batch, or the
+// absence of the name when Hash is empty.
+type NameProofResponse struct {
+	Hash  string                  `json:"hash,omitempty"`
+	Proof *merkletree.SparseProof `json:"proof"`
+}
+
+func (api API) nameProof(w http.ResponseWriter, r *http.Request) {
+	root, name := chi.URLParam(r, "root"), r.URL.Query().Get("name")
+	proof, hash, err := api.server.NameProof(r.Context(), root, name)
+	if err != nil {
+		RespondWithError(w, requestErrorCode(err), err)
+		return
+	}
+	RespondWithJSON(w, http.StatusOK, NameProofResponse{Hash: hex.EncodeToString(hash), Proof: proof})
+}
+
+// list serves the FileInfo of every file of a named or Metadata batch in
+// index order.
+func (api API) list(w http.ResponseWriter, r *http.Request) {
+	infos, err := api.server.Infos(r.Context(), chi.URLParam(r, "root"))
+	if err != nil {
+		RespondWithError(w, requestErrorCode(err), err)
+		return
+	}
+	RespondWithJSON(w, http.StatusOK, infos)
+}
+
+// setFileHeaders sets the Content-Type and Content-Disposition of a file
+// download from its metadata.
+func setFileHeaders(w http.ResponseWriter, info FileInfo) {
+	contentType := info.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	if info.Name != "" {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": info.Name}))
+	}
+}
+
+func decodeFileInfoHeader(header http.Header) (*FileInfo, error) {
+	value := header.Get(fileInfoHeader)
+	if value == "" {
+		return nil, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s header: %w", fileInfoHeader, err)
+	}
+	var info FileInfo
+	if err := json.Unmarshal(b, &info); err != nil {
+		return nil, fmt.Errorf("invalid %s header: %w", fileInfoHeader, err)
+	}
+	return &info, nil
+}
+
+func requestErrorCode(err error) int {
+	switch {
+	case errors.Is(err, ErrUnknownRoot), errors.Is(err, ErrUnknownName):
+		return http.StatusNotFound
+	case errors.Is(err, ErrInvalidBatch):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func encodeProofHeader(hashes [][]byte) string {
+	return strings.Join(encodeHashes(hashes), ",")
+}
+
+func encodeHashes(hashes [][]byte) []string {
+	encoded := make([]string, len(hashes))
+	for i, h := range hashes {
+		encoded[i] = hex.EncodeToString(h)
+	}
+	return encoded
+}
+
+func decodeProofHeader(header string) ([][]byte, error) {
+	if header == "" {
+		// a tree of a single leaf has no siblings
+		return nil, nil
+	}
+	var hashes [][]byte
+	for _, encoded := range strings.Split(header, ",") {
+		h, err := hex.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proof header: %w", err)
+		}
+		hashes = append(hashes, h)
+	}
+	return hashes, nil
+}
+
 func RespondWithError(w http.ResponseWriter, code int, msg interface{}) {
-	var message string
+	var message JSONError
 	switch m := msg.(type) {
 	case error:
-		message = m.Error()
+		message = JSONError{Error: m.Error(), Code: errorCode(m)}
 	case string:
-		message = m
+		message = JSONError{Error: m}
 	}
-	RespondWithJSON(w, code, JSONError{Error: message})
+	RespondWithJSON(w, code, message)
 }
 
+// JSONError is the body of error responses. Code names the error of the
+// server the response reports, see errorCodes.
 type JSONError struct {
 	Error string `json:"error,omitempty"`
+	Code  string `json:"code,omitempty"`
+}
+
+// errorCodes are the codes of the errors clients can tell apart.
+var errorCodes = map[string]error{
+	"root_mismatch":  ErrRootMismatch,
+	"root_exists":    ErrRootExists,
+	"unknown_root":   ErrUnknownRoot,
+	"invalid_batch":  ErrInvalidBatch,
+	"index_received": ErrIndexReceived,
+	"unknown_name":   ErrUnknownName,
+	"unknown_blob":   ErrUnknownBlob,
+}
+
+// errorCode returns the code of the first error of errorCodes wrapped by err,
+// errors are unwrapped in the order they were wrapped.
+func errorCode(err error) string {
+	for code, known := range errorCodes {
+		if err == known {
+			return code
+		}
+	}
+	switch wrapped := err.(type) {
+	case interface{ Unwrap() error }:
+		return errorCode(wrapped.Unwrap())
+	case interface{ Unwrap() []error }:
+		for _, err := range wrapped.Unwrap() {
+			if code := errorCode(err); code != "" {
+				return code
+			}
+		}
+	}
+	return ""
 }
 
 func RespondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
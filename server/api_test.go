@@ -0,0 +1,522 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tclairet/merklestore/merkletree"
+)
+
+func TestAPIDownload(t *testing.T) {
+	s, _ := newTestServer(t)
+	contents := []string{"hello world", "b"}
+	root := rootOf(t, contents)
+	for i, content := range contents {
+		if err := s.Upload(ctx, Batch{Root: root, Total: len(contents)}, i, strings.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	httpServer := httptest.NewServer(NewAPI(s).Routes())
+	defer httpServer.Close()
+	url := fmt.Sprintf("%s/roots/%s/files/0", httpServer.URL, root)
+
+	rootHash, _ := hex.DecodeString(root)
+	leaf := indexedHash(0, contents[0])
+
+	t.Run("full", func(t *testing.T) {
+		response, err := http.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		if got, want := response.StatusCode, http.StatusOK; got != want {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		b, _ := io.ReadAll(response.Body)
+		if got, want := string(b), contents[0]; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if got, want := response.Header.Get("ETag"), fmt.Sprintf(`"%x"`, leaf); got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		siblings, err := decodeProofHeader(response.Header.Get(proofHeader))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := response.Header.Get(totalHeader), "2"; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if got, want := response.Header.Get(oddNodeHeader), string(merkletree.Promote); got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if err := merkletree.NewProof(0, len(contents), siblings).Verify(leaf, rootHash); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("range", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		req.Header.Set("Range", "bytes=6-")
+		response, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		if got, want := response.StatusCode, http.StatusPartialContent; got != want {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		b, _ := io.ReadAll(response.Body)
+		if got, want := string(b), "world"; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("if-none-match", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		req.Header.Set("If-None-Match", fmt.Sprintf(`"%x"`, leaf))
+		response, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		if got, want := response.StatusCode, http.StatusNotModified; got != want {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("proof", func(t *testing.T) {
+		response, err := http.Get(url + "/proof")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		var proof merkletree.Proof
+		if err := json.NewDecoder(response.Body).Decode(&proof); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := proof.Hash(), merkletree.SHA256; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if err := proof.Verify(leaf, rootHash); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("binary proof", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, url+"/proof", nil)
+		req.Header.Set("Accept", "application/octet-stream")
+		response, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		b, _ := io.ReadAll(response.Body)
+		var proof merkletree.Proof
+		if err := proof.UnmarshalBinary(b); err != nil {
+			t.Fatal(err)
+		}
+		if err := proof.Verify(leaf, rootHash); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("unknown root", func(t *testing.T) {
+		response, err := http.Get(fmt.Sprintf("%s/roots/unknown/files/0", httpServer.URL))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		if got, want := response.StatusCode, http.StatusNotFound; got != want {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	})
+}
+
+func TestAPIDownloadMany(t *testing.T) {
+	s, _ := newTestServer(t)
+	contents := []string{"a", "b", "c", "d", "e"}
+	root := rootOf(t, contents)
+	for i, content := range contents {
+		if err := s.Upload(ctx, Batch{Root: root, Total: len(contents)}, i, strings.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	httpServer := httptest.NewServer(NewAPI(s).Routes())
+	defer httpServer.Close()
+	client := NewClient(httpServer.URL)
+	rootHash, _ := hex.DecodeString(root)
+
+	var received []string
+	var leaves [][]byte
+	proof, err := client.RequestMany(root, []int{4, 1, 3, 1}, func(index int, file io.Reader) error {
+		b, err := io.ReadAll(file)
+		received = append(received, string(b))
+		leaves = append(leaves, indexedHash(index, string(b)))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := received, []string{"b", "d", "e"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if err := proof.Verify(leaves, rootHash); err != nil {
+		t.Error(err)
+	}
+
+	save := func(int, io.Reader) error { return nil }
+	if _, err := client.RequestMany(root, []int{0, 5}, save); !errors.Is(err, ErrInvalidBatch) {
+		t.Errorf("got %v, want %v", err, ErrInvalidBatch)
+	}
+	if _, err := client.RequestMany(root, nil, save); !errors.Is(err, ErrInvalidBatch) {
+		t.Errorf("got %v, want %v", err, ErrInvalidBatch)
+	}
+	if _, err := client.RequestMany("unknown", []int{0}, save); !errors.Is(err, ErrUnknownRoot) {
+		t.Errorf("got %v, want %v", err, ErrUnknownRoot)
+	}
+}
+
+func TestAPIAppend(t *testing.T) {
+	s, _ := newTestServer(t)
+	httpServer := httptest.NewServer(NewAPI(s).Routes())
+	defer httpServer.Close()
+	client := NewClient(httpServer.URL)
+
+	contents := []string{"a", "b", "c", "d"}
+	parent := Batch{Root: rootOf(t, contents[:3]), Total: 3}
+	for i := 0; i < parent.Total; i++ {
+		if err := client.Upload(parent, i, strings.NewReader(contents[i])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	batch := Batch{Root: rootOf(t, contents), Total: len(contents), Parent: parent.Root}
+	if err := client.Upload(batch, 3, strings.NewReader(contents[3])); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := client.Batch(batch.Root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stored.Parent, parent.Root; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	hashes, err := client.Hashes(batch.Root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, content := range contents {
+		h := sha256.Sum256([]byte(content))
+		if got, want := hex.EncodeToString(hashes[i]), hex.EncodeToString(h[:]); got != want {
+			t.Errorf("%d: got %v, want %v", i, got, want)
+		}
+	}
+
+	proof, err := client.Consistency(parent.Root, batch.Root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldRoot, _ := hex.DecodeString(parent.Root)
+	newRoot, _ := hex.DecodeString(batch.Root)
+	if err := proof.Verify(oldRoot, newRoot); err != nil {
+		t.Error(err)
+	}
+	if _, err := client.Consistency(batch.Root, parent.Root); !errors.Is(err, ErrInvalidBatch) {
+		t.Errorf("got %v, want %v", err, ErrInvalidBatch)
+	}
+	if _, err := client.Consistency("unknown", batch.Root); !errors.Is(err, ErrUnknownRoot) {
+		t.Errorf("got %v, want %v", err, ErrUnknownRoot)
+	}
+
+	stats, err := client.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stats, (Stats{Blobs: 4, Files: 7, Bytes: 4, FileBytes: 7}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if err := client.Delete(parent.Root); !errors.Is(err, ErrInvalidBatch) {
+		t.Errorf("got %v, want %v", err, ErrInvalidBatch)
+	}
+	if err := client.Delete(batch.Root); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Delete(batch.Root); !errors.Is(err, ErrUnknownRoot) {
+		t.Errorf("got %v, want %v", err, ErrUnknownRoot)
+	}
+}
+
+func indexedHash(index int, content string) []byte {
+	h := sha256.Sum256([]byte(content))
+	hasher := sha256.New()
+	hasher.Write([]byte(fmt.Sprint(index)))
+	hasher.Write(h[:])
+	return hasher.Sum(nil)
+}
+
+func TestAPINamed(t *testing.T) {
+	s, _ := newTestServer(t)
+	httpServer := httptest.NewServer(NewAPI(s).Routes())
+	defer httpServer.Close()
+	client := NewClient(httpServer.URL)
+
+	names := []string{"a b.txt", "dir/ü?.txt"}
+	contents := []string{"a", "b"}
+	batch := Batch{Root: namedRoot(t, names, contents), Total: len(names)}
+	for i, content := range contents {
+		if err := client.UploadNamed(batch, i, names[i], strings.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stored, err := client.Batch(batch.Root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stored.Named {
+		t.Error("batch should be named")
+	}
+
+	root, _ := hex.DecodeString(batch.Root)
+	for i, name := range names {
+		reader, proof, err := client.RequestName(batch.Root, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(reader)
+		reader.Close()
+		if got, want := string(b), contents[i]; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		h := sha256.Sum256(b)
+		if err := proof.Verify(name, h[:], root); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		proof, hash, err := client.NameProof(batch.Root, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := proof.Verify(name, hash, root); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if got, want := hex.EncodeToString(hash), hex.EncodeToString(h[:]); got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+
+	if _, _, err := client.RequestName(batch.Root, "missing"); !errors.Is(err, ErrUnknownName) {
+		t.Errorf("got %v, want %v", err, ErrUnknownName)
+	}
+	proof, hash, err := client.NameProof(batch.Root, "missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != nil {
+		t.Errorf("got %x, want nil", hash)
+	}
+	if err := proof.VerifyAbsence("missing", root); err != nil {
+		t.Error(err)
+	}
+	if _, _, err := client.NameProof("unknown", "missing"); !errors.Is(err, ErrUnknownRoot) {
+		t.Errorf("got %v, want %v", err, ErrUnknownRoot)
+	}
+}
+
+func TestAPIMetadata(t *testing.T) {
+	s, _ := newTestServer(t)
+	httpServer := httptest.NewServer(NewAPI(s).Routes())
+	defer httpServer.Close()
+	client := NewClient(httpServer.URL)
+
+	contents := []string{"hello", "b"}
+	infos := []FileInfo{
+		{Name: "résumé.txt", Size: 5, ContentType: "text/plain", ModTime: 1700000000},
+		{Size: 1},
+	}
+	batch := Batch{Root: metadataRoot(t, infos, contents), Total: len(contents)}
+	for i, content := range contents {
+		if err := client.UploadWithInfo(batch, i, infos[i], strings.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	listed, err := client.Infos(batch.Root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := listed, infos; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	headers := []map[string]string{
+		{
+			"Content-Type":        "text/plain",
+			"Content-Disposition": "attachment; filename*=utf-8''r%C3%A9sum%C3%A9.txt",
+			"Last-Modified":       "Tue, 14 Nov 2023 22:13:20 GMT",
+		},
+		{
+			"Content-Type":        "application/octet-stream",
+			"Content-Disposition": "",
+			"Last-Modified":       "",
+		},
+	}
+	for i, expected := range headers {
+		response, err := http.Get(fmt.Sprintf("%s/roots/%s/files/%d", httpServer.URL, batch.Root, i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		for name, want := range expected {
+			if got := response.Header.Get(name); got != want {
+				t.Errorf("%d %s: got %v, want %v", i, name, got, want)
+			}
+		}
+	}
+
+	plain := Batch{Root: rootOf(t, contents[:1]), Total: 1}
+	if err := client.Upload(plain, 0, strings.NewReader(contents[0])); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Infos(plain.Root); !errors.Is(err, ErrInvalidBatch) {
+		t.Errorf("got %v, want %v", err, ErrInvalidBatch)
+	}
+
+	// a file is either named or has metadata
+	other := Batch{Root: metadataRoot(t, infos[1:], contents[1:]), Total: 1}
+	encoded, _ := json.Marshal(infos[1])
+	request, _ := http.NewRequest(http.MethodPut, fmt.Sprintf("%s/roots/%s/files/0", httpServer.URL, other.Root), strings.NewReader(contents[1]))
+	request.Header.Set(totalHeader, "1")
+	request.Header.Set(nameHeader, "b")
+	request.Header.Set(fileInfoHeader, base64.StdEncoding.EncodeToString(encoded))
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if got, want := response.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if err := responseError(response); !errors.Is(err, ErrInvalidBatch) {
+		t.Errorf("got %v, want %v", err, ErrInvalidBatch)
+	}
+	if _, err := s.Status(ctx, other.Root); !errors.Is(err, ErrUnknownRoot) {
+		t.Errorf("got %v, want %v", err, ErrUnknownRoot)
+	}
+}
+
+func TestAPIStored(t *testing.T) {
+	s, _ := newTestServer(t)
+	httpServer := httptest.NewServer(NewAPI(s).Routes())
+	defer httpServer.Close()
+	client := NewClient(httpServer.URL)
+
+	plain := Batch{Root: rootOf(t, []string{"hello"}), Total: 1}
+	if err := client.Upload(plain, 0, strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	hello, other := sha256.Sum256([]byte("hello")), sha256.Sum256([]byte("other"))
+	have, err := client.Have(Batch{}, [][]byte{other[:], hello[:]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := have, []bool{false, true}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	infos := []FileInfo{{Name: "a.txt", Size: 5, ContentType: "text/plain"}, {Size: 5}}
+	batch := Batch{Root: metadataRoot(t, infos, []string{"hello", "other"}), Total: 2, Metadata: true}
+	if err := client.UploadStored(batch, 1, infos[1], other[:]); !errors.Is(err, ErrUnknownBlob) {
+		t.Errorf("got %v, want %v", err, ErrUnknownBlob)
+	}
+	if err := client.UploadStored(batch, 0, infos[0], hello[:]); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.UploadWithInfo(batch, 1, infos[1], strings.NewReader("other")); err != nil {
+		t.Fatal(err)
+	}
+	listed, err := client.Infos(batch.Root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := listed, infos; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestAPIInvalidRoot(t *testing.T) {
+	s, handler := newTestServer(t)
+	httpServer := httptest.NewServer(NewAPI(s).Routes())
+	defer httpServer.Close()
+
+	body, _ := json.Marshal(UploadRequest{Root: "blobs", Total: 1, Content: []byte("a")})
+	response, err := http.Post(httpServer.URL+uploadRoute, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if got, want := response.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	request, _ := http.NewRequest(http.MethodPut, httpServer.URL+"/roots/blobs/files/0", strings.NewReader("a"))
+	request.Header.Set(totalHeader, "1")
+	response, err = http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if got, want := response.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := len(handler.saved), 0; got != want {
+		t.Errorf("got %v files, want %v", got, want)
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	s, _ := newTestServer(t)
+	httpServer := httptest.NewServer(NewAPI(s).Routes())
+	defer httpServer.Close()
+	client := NewClient(httpServer.URL)
+
+	batch := Batch{Root: rootOf(t, []string{"a"}), Total: 1}
+	if err := client.Upload(batch, 0, strings.NewReader("a")); err != nil {
+		t.Fatal(err)
+	}
+	other := sha256.Sum256([]byte("other"))
+	_, _, requestErr := client.Request(testRoot, 0)
+	_, _, nameErr := client.RequestName(batch.Root, "a")
+	cases := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"unknown root", requestErr, ErrUnknownRoot},
+		{"unknown blob", client.UploadStored(Batch{Root: testRoot, Total: 1}, 0, FileInfo{}, other[:]), ErrUnknownBlob},
+		{"root exists", client.Upload(batch, 0, strings.NewReader("a")), ErrRootExists},
+		{"root mismatch", client.Upload(Batch{Root: testRoot, Total: 1}, 0, strings.NewReader("a")), ErrRootMismatch},
+		{"invalid batch", client.Upload(Batch{Root: "blobs", Total: 1}, 0, strings.NewReader("a")), ErrInvalidBatch},
+		{"not named", nameErr, ErrInvalidBatch},
+	}
+	for _, c := range cases {
+		for _, known := range errorCodes {
+			if got, want := errors.Is(c.err, known), known == c.want; got != want {
+				t.Errorf("%s: %v is %v: got %v, want %v", c.name, c.err, known, got, want)
+			}
+		}
+	}
+
+	wrapped := fmt.Errorf("%w: parent: %w", ErrInvalidBatch, ErrUnknownRoot)
+	if got, want := errorCode(wrapped), "invalid_batch"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
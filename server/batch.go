@@ -0,0 +1,161 @@
+package server
+
+import (
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/tclairet/merklestore/merkletree"
+)
+
+// Batch describes a set of files committed under a single merkle root.
+// When BlockSize is set every file is split in blocks of BlockSize bytes and
+// its hash is the root of the tree of those blocks. Hash names the algorithm
+// used for files and tree nodes, sha256 when empty. OddNode is the strategy
+// for the last node of odd levels in those trees, promote when empty.
+// Parent is the root of a completed batch extended by this one: its files are
+// the first files of the batch and are not uploaded again. The files of a
+// Named batch are addressed by name: its root is the root of the sparse tree
+// mapping the name of every file to its hash. The last file of a Manifest
+// batch describes the directory layout of the others, the server stores it
+// as any other file. The leaf of every file of a Metadata batch commits to
+// its FileInfo.
+type Batch struct {
+	Root      string             `json:"root"`
+	Total     int                `json:"total"`
+	BlockSize int                `json:"block_size,omitempty"`
+	Hash      string             `json:"hash,omitempty"`
+	OddNode   merkletree.OddNode `json:"odd_node,omitempty"`
+	Parent    string             `json:"parent,omitempty"`
+	Named     bool               `json:"named,omitempty"`
+	Manifest  bool               `json:"manifest,omitempty"`
+	Metadata  bool               `json:"metadata,omitempty"`
+}
+
+func (batch Batch) withDefaults() Batch {
+	if batch.Hash == "" {
+		batch.Hash = merkletree.SHA256
+	}
+	if batch.OddNode == "" {
+		batch.OddNode = merkletree.Promote
+	}
+	return batch
+}
+
+func (batch Batch) validate(index int) error {
+	if index < 0 || index >= batch.Total {
+		return fmt.Errorf("%w: index %d out of range for %d files", ErrInvalidBatch, index, batch.Total)
+	}
+	if batch.BlockSize < 0 {
+		return fmt.Errorf("%w: block size %d", ErrInvalidBatch, batch.BlockSize)
+	}
+	if err := batch.validateRoot(batch.Root); err != nil {
+		return err
+	}
+	if batch.Parent != "" {
+		if err := batch.validateRoot(batch.Parent); err != nil {
+			return err
+		}
+	}
+	if oddNode := batch.withDefaults().OddNode; !slices.Contains(merkletree.OddNodes(), oddNode) {
+		return fmt.Errorf("%w: unknown odd node strategy %q", ErrInvalidBatch, oddNode)
+	}
+	if batch.Parent != "" && batch.Parent == batch.Root {
+		return fmt.Errorf("%w: %s cannot extend itself", ErrInvalidBatch, batch.Root)
+	}
+	if batch.Parent != "" && batch.Named {
+		return fmt.Errorf("%w: named batches cannot be extended", ErrInvalidBatch)
+	}
+	if batch.Parent != "" && batch.Manifest {
+		return fmt.Errorf("%w: the manifest must list every file of the batch", ErrInvalidBatch)
+	}
+	if batch.Named && batch.Manifest {
+		return fmt.Errorf("%w: named batches have no manifest", ErrInvalidBatch)
+	}
+	if batch.Named && batch.Metadata {
+		return fmt.Errorf("%w: named batches have no file metadata", ErrInvalidBatch)
+	}
+	return nil
+}
+
+// validateFile checks that the file at index of batch can have the metadata
+// info.
+func (batch Batch) validateFile(index int, info FileInfo) error {
+	if err := batch.validate(index); err != nil {
+		return err
+	}
+	switch {
+	case batch.Named:
+		if info.Name == "" || info != (FileInfo{Name: info.Name}) {
+			return fmt.Errorf("%w: files of named batches only have a name", ErrInvalidBatch)
+		}
+	case batch.Metadata:
+		if err := info.validate(); err != nil {
+			return err
+		}
+	case info != FileInfo{}:
+		return fmt.Errorf("%w: files of named and Metadata batches only have metadata", ErrInvalidBatch)
+	}
+	return nil
+}
+
+// validateRoot checks that root is the lowercase hex of a digest of the hash
+// of the batch. Roots name paths of the file handler, anything else is
+// rejected before it reaches it.
+func (batch Batch) validateRoot(root string) error {
+	newHash, err := batch.NewHash()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidBatch, err)
+	}
+	if len(root) != 2*newHash().Size() || strings.Trim(root, "0123456789abcdef") != "" {
+		return fmt.Errorf("%w: root %q is not a %s digest", ErrInvalidBatch, root, batch.withDefaults().Hash)
+	}
+	return nil
+}
+
+// NewHash returns the hash algorithm of the batch.
+func (batch Batch) NewHash() (func() hash.Hash, error) {
+	return merkletree.HashFunc(batch.withDefaults().Hash)
+}
+
+// Options returns the options of the trees of the batch.
+func (batch Batch) Options() []merkletree.Option {
+	batch = batch.withDefaults()
+	return []merkletree.Option{merkletree.WithHashName(batch.Hash), merkletree.WithOddNode(batch.OddNode)}
+}
+
+// FileHasher returns the writer used to compute the hash of a file of the
+// batch and a function returning that hash once everything has been written.
+func (batch Batch) FileHasher() (io.Writer, func() ([]byte, error)) {
+	newHash, err := batch.NewHash()
+	if err != nil {
+		return io.Discard, func() ([]byte, error) { return nil, err }
+	}
+	if batch.BlockSize == 0 {
+		hasher := newHash()
+		return hasher, func() ([]byte, error) { return hasher.Sum(nil), nil }
+	}
+	hasher := merkletree.NewBlockHasher(batch.BlockSize, batch.Options()...)
+	return hasher, func() ([]byte, error) {
+		tree, err := hasher.Tree()
+		if err != nil {
+			return nil, err
+		}
+		return tree.Root(), nil
+	}
+}
+
+// blobKey returns the key of the blob holding a file of the batch whose hash
+// is h. The key is namespaced by everything the hash depends on, so files
+// with the same key have the same content.
+func (batch Batch) blobKey(h []byte) string {
+	batch = batch.withDefaults()
+	space := batch.Hash
+	if batch.BlockSize != 0 {
+		space = fmt.Sprintf("%s-%d-%s", batch.Hash, batch.BlockSize, batch.OddNode)
+	}
+	return space + "/" + hex.EncodeToString(h)
+}
@@ -0,0 +1,339 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	recordsBucket = []byte("records")
+	metaKey       = []byte("meta")
+	hashesBucket  = []byte("hashes")
+	namesBucket   = []byte("names")
+	infosBucket   = []byte("infos")
+	blobsBucket   = []byte("blobs")
+)
+
+// BoltStore is a MetadataStore keeping records in an embedded bbolt
+// database. Every root has its own bucket holding the record metadata and one
+// key per received hash, per name or file info for named and Metadata batches
+// and per blob key, so a save only writes the uploaded hash and is committed
+// atomically.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(recordsBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+func (store *BoltStore) Close() error {
+	return store.db.Close()
+}
+
+func (store *BoltStore) Save(ctx context.Context, batch Batch, index int, info FileInfo, hash []byte, blob string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(recordsBucket).Bucket([]byte(batch.Root))
+		if bucket == nil {
+			var err error
+			if bucket, err = createRecordBucket(tx, NewRecord(batch)); err != nil {
+				return err
+			}
+		}
+		meta, err := readMeta(bucket)
+		if err != nil {
+			return err
+		}
+		if index < 0 || index >= meta.Total {
+			return fmt.Errorf("invalid index %d", index)
+		}
+		if err := putFile(bucket, index, info, blob); err != nil {
+			return err
+		}
+		return bucket.Bucket(hashesBucket).Put(indexKey(index), hash)
+	})
+}
+
+func (store *BoltStore) Hash(ctx context.Context, root string, index int) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var hash []byte
+	err := store.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(recordsBucket).Bucket([]byte(root))
+		if bucket == nil {
+			return fmt.Errorf("%w: %s", ErrRecordNotFound, root)
+		}
+		meta, err := readMeta(bucket)
+		if err != nil {
+			return err
+		}
+		if index < 0 || index >= meta.Total {
+			return fmt.Errorf("invalid index %d", index)
+		}
+		if h := bucket.Bucket(hashesBucket).Get(indexKey(index)); h != nil {
+			hash = bytes.Clone(h)
+		}
+		return nil
+	})
+	return hash, err
+}
+
+func (store *BoltStore) Get(ctx context.Context, root string) (Record, error) {
+	if err := ctx.Err(); err != nil {
+		return Record{}, err
+	}
+	var record Record
+	err := store.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(recordsBucket).Bucket([]byte(root))
+		if bucket == nil {
+			return fmt.Errorf("%w: %s", ErrRecordNotFound, root)
+		}
+		var err error
+		record, err = readRecord(bucket)
+		return err
+	})
+	return record, err
+}
+
+func (store *BoltStore) List(ctx context.Context) ([]Record, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	records := []Record{}
+	err := store.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(recordsBucket).ForEachBucket(func(root []byte) error {
+			record, err := readRecord(tx.Bucket(recordsBucket).Bucket(root))
+			if err != nil {
+				return fmt.Errorf("record %s: %w", root, err)
+			}
+			records = append(records, record)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func (store *BoltStore) Pending(ctx context.Context) ([]Record, error) {
+	records, err := store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(records, Record.Complete), nil
+}
+
+func (store *BoltStore) Delete(ctx context.Context, root string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return store.db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket(recordsBucket).DeleteBucket([]byte(root))
+		if err == bolt.ErrBucketNotFound {
+			return nil
+		}
+		return err
+	})
+}
+
+// Import copies every record of another store into the bolt database in a
+// single transaction and returns the number of imported records.
+func (store *BoltStore) Import(ctx context.Context, from MetadataStore) (int, error) {
+	records, err := from.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+	err = store.db.Update(func(tx *bolt.Tx) error {
+		for _, record := range records {
+			if err := tx.Bucket(recordsBucket).DeleteBucket([]byte(record.Root)); err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+			bucket, err := createRecordBucket(tx, &record)
+			if err != nil {
+				return err
+			}
+			for index, hash := range record.Hashes {
+				if len(hash) == 0 {
+					continue
+				}
+				if err := bucket.Bucket(hashesBucket).Put(indexKey(index), hash); err != nil {
+					return err
+				}
+				var info FileInfo
+				if record.Infos != nil {
+					info = record.Infos[index]
+				}
+				if record.Names != nil {
+					info.Name = record.Names[index]
+				}
+				var blob string
+				if record.Blobs != nil {
+					blob = record.Blobs[index]
+				}
+				if err := putFile(bucket, index, info, blob); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(records), nil
+}
+
+func createRecordBucket(tx *bolt.Tx, record *Record) (*bolt.Bucket, error) {
+	bucket, err := tx.Bucket(recordsBucket).CreateBucket([]byte(record.Root))
+	if err != nil {
+		return nil, err
+	}
+	meta := *record
+	meta.Hashes, meta.Names, meta.Infos, meta.Blobs = nil, nil, nil, nil
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return nil, err
+	}
+	if err := bucket.Put(metaKey, b); err != nil {
+		return nil, err
+	}
+	if _, err := bucket.CreateBucket(hashesBucket); err != nil {
+		return nil, err
+	}
+	if record.Names != nil {
+		if _, err := bucket.CreateBucket(namesBucket); err != nil {
+			return nil, err
+		}
+	}
+	if record.Infos != nil {
+		if _, err := bucket.CreateBucket(infosBucket); err != nil {
+			return nil, err
+		}
+	}
+	return bucket, nil
+}
+
+// putFile stores the name or the metadata of the file at index when the
+// record keeps them, and its blob key unless it is empty.
+func putFile(bucket *bolt.Bucket, index int, info FileInfo, blob string) error {
+	if names := bucket.Bucket(namesBucket); names != nil {
+		if err := names.Put(indexKey(index), []byte(info.Name)); err != nil {
+			return err
+		}
+	}
+	if infos := bucket.Bucket(infosBucket); infos != nil {
+		b, err := json.Marshal(info)
+		if err != nil {
+			return err
+		}
+		if err := infos.Put(indexKey(index), b); err != nil {
+			return err
+		}
+	}
+	if blob == "" {
+		if blobs := bucket.Bucket(blobsBucket); blobs != nil {
+			return blobs.Delete(indexKey(index))
+		}
+		return nil
+	}
+	blobs, err := bucket.CreateBucketIfNotExists(blobsBucket)
+	if err != nil {
+		return err
+	}
+	return blobs.Put(indexKey(index), []byte(blob))
+}
+
+func readMeta(bucket *bolt.Bucket) (Record, error) {
+	var meta Record
+	if err := json.Unmarshal(bucket.Get(metaKey), &meta); err != nil {
+		return Record{}, err
+	}
+	return meta, nil
+}
+
+func readRecord(bucket *bolt.Bucket) (Record, error) {
+	record, err := readMeta(bucket)
+	if err != nil {
+		return Record{}, err
+	}
+	record.Hashes = make([][]byte, record.Total)
+	err = bucket.Bucket(hashesBucket).ForEach(func(k, v []byte) error {
+		index := int(binary.BigEndian.Uint64(k))
+		if index >= record.Total {
+			return fmt.Errorf("hash stored at index %d of %d", index, record.Total)
+		}
+		record.Hashes[index] = bytes.Clone(v)
+		return nil
+	})
+	if err != nil {
+		return Record{}, err
+	}
+	if names := bucket.Bucket(namesBucket); names != nil {
+		record.Names = make([]string, record.Total)
+		err = names.ForEach(func(k, v []byte) error {
+			index := int(binary.BigEndian.Uint64(k))
+			if index >= record.Total {
+				return fmt.Errorf("name stored at index %d of %d", index, record.Total)
+			}
+			record.Names[index] = string(v)
+			return nil
+		})
+		if err != nil {
+			return Record{}, err
+		}
+	}
+	if infos := bucket.Bucket(infosBucket); infos != nil {
+		record.Infos = make([]FileInfo, record.Total)
+		err = infos.ForEach(func(k, v []byte) error {
+			index := int(binary.BigEndian.Uint64(k))
+			if index >= record.Total {
+				return fmt.Errorf("file info stored at index %d of %d", index, record.Total)
+			}
+			return json.Unmarshal(v, &record.Infos[index])
+		})
+		if err != nil {
+			return Record{}, err
+		}
+	}
+	if blobs := bucket.Bucket(blobsBucket); blobs != nil {
+		record.Blobs = make([]string, record.Total)
+		err = blobs.ForEach(func(k, v []byte) error {
+			index := int(binary.BigEndian.Uint64(k))
+			if index >= record.Total {
+				return fmt.Errorf("blob stored at index %d of %d", index, record.Total)
+			}
+			record.Blobs[index] = string(v)
+			return nil
+		})
+		if err != nil {
+			return Record{}, err
+		}
+	}
+	return record, nil
+}
+
+func indexKey(index int) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(index))
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBoltStoreReopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "merkle.db")
+	store, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := Batch{Root: "root", Total: 3, BlockSize: 2}
+	for index, hash := range map[int]string{0: "a", 2: "c"} {
+		if err := store.Save(ctx, batch, index, FileInfo{}, []byte(hash), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before, err := store.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if store, err = NewBoltStore(path); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	after, err := store.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := after, before; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestBoltStoreImport(t *testing.T) {
+	ctx := context.Background()
+	handler := newFakeFileHandler()
+	handler.saved["backup.json"] = []byte(`{"names":{"legacy":["YQ==",null]}}`)
+	backup, err := NewJsonStore(handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backup.Save(ctx, Batch{Root: "recent", Total: 1}, 0, FileInfo{}, []byte("b"), "sha256/62"); err != nil {
+		t.Fatal(err)
+	}
+	if err := backup.Save(ctx, Batch{Root: "named", Total: 1, Named: true}, 0, FileInfo{Name: "a.txt"}, []byte("c"), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := backup.Save(ctx, Batch{Root: "metadata", Total: 1, Metadata: true}, 0, FileInfo{Name: "a.txt", Size: 1}, []byte("d"), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewBoltStore(filepath.Join(t.TempDir(), "merkle.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	count, err := store.Import(ctx, backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := count, 4; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	imported, err := store.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := backup.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := imported, expected; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	contents := []string{"a", "b"}
+	root := rootOf(t, contents)
+	s, err := New(ctx, handler, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, content := range contents {
+		if err := s.Upload(ctx, Batch{Root: root, Total: 2}, i, strings.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Proof(ctx, root, 1); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -2,11 +2,16 @@ package server
 
 import (
 	"bytes"
-	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/url"
+	"strconv"
 
 	"github.com/tclairet/merklestore/merkletree"
 )
@@ -21,64 +26,455 @@ func NewClient(url string) Client {
 	}
 }
 
-func (c Client) Upload(root string, index, total int, file io.Reader) error {
-	content, err := io.ReadAll(file)
+func (c Client) Upload(batch Batch, index int, file io.Reader) error {
+	return c.upload(batch, index, FileInfo{}, file, nil)
+}
+
+// UploadNamed uploads the file at index of a named batch under name.
+func (c Client) UploadNamed(batch Batch, index int, name string, file io.Reader) error {
+	batch.Named = true
+	return c.upload(batch, index, FileInfo{Name: name}, file, nil)
+}
+
+// UploadWithInfo uploads the file at index of a Metadata batch along with its
+// metadata.
+func (c Client) UploadWithInfo(batch Batch, index int, info FileInfo, file io.Reader) error {
+	batch.Metadata = true
+	return c.upload(batch, index, info, file, nil)
+}
+
+// UploadStored adds the file at index of batch from the file the server
+// stores with hash, without sending its content. It fails with
+// ErrUnknownBlob when the server stores no such file.
+func (c Client) UploadStored(batch Batch, index int, info FileInfo, hash []byte) error {
+	return c.upload(batch, index, info, http.NoBody, hash)
+}
+
+// Have reports which of hashes are the hash of a file the server stores for
+// batches hashed as batch.
+func (c Client) Have(batch Batch, hashes [][]byte) ([]bool, error) {
+	b, err := json.Marshal(HaveRequest{
+		BlockSize: batch.BlockSize,
+		Hash:      batch.Hash,
+		OddNode:   batch.OddNode,
+		Hashes:    encodeHashes(hashes),
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	b, err := json.Marshal(UploadRequest{
-		Root:    root,
-		Index:   index,
-		Total:   total,
-		Content: content,
-	})
+	response, err := http.Post(c.url+haveRoute, "application/json", bytes.NewReader(b))
 	if err != nil {
-		return err
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, responseError(response)
 	}
-	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s%s", c.url, uploadRoute), bytes.NewBuffer(b))
+	var have []bool
+	if err := json.NewDecoder(response.Body).Decode(&have); err != nil {
+		return nil, err
+	}
+	if len(have) != len(hashes) {
+		return nil, fmt.Errorf("got %d answers for %d hashes", len(have), len(hashes))
+	}
+	return have, nil
+}
+
+func (c Client) upload(batch Batch, index int, info FileInfo, file io.Reader, stored []byte) error {
+	req, err := http.NewRequest(http.MethodPut, c.fileURL(batch.Root, index), file)
 	if err != nil {
 		return err
 	}
+	req.Header.Set(totalHeader, strconv.Itoa(batch.Total))
+	if batch.BlockSize != 0 {
+		req.Header.Set(blockSizeHeader, strconv.Itoa(batch.BlockSize))
+	}
+	if batch.Hash != "" {
+		req.Header.Set(hashHeader, batch.Hash)
+	}
+	if batch.Parent != "" {
+		req.Header.Set(parentHeader, batch.Parent)
+	}
+	if batch.OddNode != "" {
+		req.Header.Set(oddNodeHeader, string(batch.OddNode))
+	}
+	if batch.Named {
+		req.Header.Set(nameHeader, url.PathEscape(info.Name))
+	}
+	if batch.Metadata {
+		b, err := json.Marshal(info)
+		if err != nil {
+			return err
+		}
+		req.Header.Set(fileInfoHeader, base64.StdEncoding.EncodeToString(b))
+	}
+	if batch.Manifest {
+		req.Header.Set(manifestHeader, strconv.FormatBool(batch.Manifest))
+	}
+	if stored != nil {
+		req.Header.Set(storedHeader, hex.EncodeToString(stored))
+	}
 	response, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return err
 	}
+	defer response.Body.Close()
 	if response.StatusCode != http.StatusOK {
-		var message JSONError
-		if err := json.NewDecoder(response.Body).Decode(&message); err != nil {
-			return err
-		}
-		return fmt.Errorf("invalid server response %d error '%s'", response.StatusCode, message.Error)
+		return responseError(response)
 	}
 	return nil
 }
 
-func (c Client) Request(root string, index int) (io.Reader, *merkletree.Proof, error) {
-	b, err := json.Marshal(RequestRequest{
-		Root:  root,
-		Index: index,
-	})
+// Request streams the file stored at index, the caller must close the returned
+// reader.
+func (c Client) Request(root string, index int) (io.ReadCloser, *merkletree.Proof, error) {
+	response, err := http.Get(c.fileURL(root, index))
 	if err != nil {
 		return nil, nil, err
 	}
-	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s%s", c.url, requestRoute), bytes.NewBuffer(b))
+	if response.StatusCode != http.StatusOK {
+		defer response.Body.Close()
+		return nil, nil, responseError(response)
+	}
+	siblings, err := decodeProofHeader(response.Header.Get(proofHeader))
 	if err != nil {
+		response.Body.Close()
 		return nil, nil, err
 	}
-	response, err := http.DefaultClient.Do(req)
+	total, err := decodeSizeHeader(response.Header, totalHeader)
+	if err != nil {
+		response.Body.Close()
+		return nil, nil, err
+	}
+	return response.Body, merkletree.NewProof(index, total, siblings, batchOf(response.Header).Options()...), nil
+}
+
+// RequestName streams the file called name of a named batch, the caller must
+// close the returned reader.
+func (c Client) RequestName(root, name string) (io.ReadCloser, *merkletree.SparseProof, error) {
+	response, err := http.Get(c.nameURL(root, name, ""))
+	if err != nil {
+		return nil, nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		defer response.Body.Close()
+		return nil, nil, responseError(response)
+	}
+	b, err := base64.StdEncoding.DecodeString(response.Header.Get(nameProofHeader))
 	if err != nil {
+		response.Body.Close()
+		return nil, nil, fmt.Errorf("invalid %s header: %w", nameProofHeader, err)
+	}
+	var proof merkletree.SparseProof
+	if err := proof.UnmarshalBinary(b); err != nil {
+		response.Body.Close()
+		return nil, nil, err
+	}
+	return response.Body, &proof, nil
+}
+
+// NameProof returns the proof of name in a named batch along with the hash
+// of its file, nil when the proof is the one of the absence of name.
+func (c Client) NameProof(root, name string) (*merkletree.SparseProof, []byte, error) {
+	response, err := http.Get(c.nameURL(root, name, "/proof"))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, nil, responseError(response)
+	}
+	var decoded NameProofResponse
+	if err := json.NewDecoder(response.Body).Decode(&decoded); err != nil {
 		return nil, nil, err
 	}
+	if decoded.Proof == nil {
+		return nil, nil, fmt.Errorf("missing proof")
+	}
+	if decoded.Hash == "" {
+		return decoded.Proof, nil, nil
+	}
+	hash, err := hex.DecodeString(decoded.Hash)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid hash: %w", err)
+	}
+	return decoded.Proof, hash, nil
+}
+
+// RequestMany downloads the files at indexes with a single proof. save is
+// called for every file in increasing index order, the proof must be checked
+// once every file was saved.
+func (c Client) RequestMany(root string, indexes []int, save func(index int, file io.Reader) error) (*merkletree.MultiProof, error) {
+	query := url.Values{}
+	for _, index := range indexes {
+		query.Add("index", strconv.Itoa(index))
+	}
+	response, err := http.Get(fmt.Sprintf("%s/roots/%s/files?%s", c.url, url.PathEscape(root), query.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, responseError(response)
+	}
+	mediaType, params, err := mime.ParseMediaType(response.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+	if mediaType != "multipart/mixed" {
+		return nil, fmt.Errorf("unexpected content type %s", mediaType)
+	}
+	parts := multipart.NewReader(response.Body, params["boundary"])
+
+	part, err := parts.NextPart()
+	if err != nil {
+		return nil, fmt.Errorf("missing proof: %w", err)
+	}
+	var proof merkletree.MultiProof
+	if err := json.NewDecoder(part).Decode(&proof); err != nil {
+		return nil, err
+	}
+	for _, index := range proof.Indexes() {
+		part, err := parts.NextPart()
+		if err != nil {
+			return nil, fmt.Errorf("file %d: %w", index, err)
+		}
+		if got := part.Header.Get(indexHeader); got != strconv.Itoa(index) {
+			return nil, fmt.Errorf("got file %s instead of %d", got, index)
+		}
+		if err := save(index, part); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := parts.NextPart(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected part after the files: %v", err)
+	}
+	return &proof, nil
+}
+
+// Proof returns the proof of the file at index.
+func (c Client) Proof(root string, index int) (*merkletree.Proof, error) {
+	response, err := http.Get(c.fileURL(root, index) + "/proof")
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, responseError(response)
+	}
+	var proof merkletree.Proof
+	if err := json.NewDecoder(response.Body).Decode(&proof); err != nil {
+		return nil, err
+	}
+	return &proof, nil
+}
+
+// Hashes returns the file hashes of a completed batch in index order.
+func (c Client) Hashes(root string) ([][]byte, error) {
+	response, err := http.Get(fmt.Sprintf("%s/roots/%s/hashes", c.url, url.PathEscape(root)))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
 	if response.StatusCode != http.StatusOK {
-		var message JSONError
-		if err := json.NewDecoder(response.Body).Decode(&message); err != nil {
-			return nil, nil, err
+		return nil, responseError(response)
+	}
+	var encoded []string
+	if err := json.NewDecoder(response.Body).Decode(&encoded); err != nil {
+		return nil, err
+	}
+	hashes := make([][]byte, len(encoded))
+	for i := range encoded {
+		if hashes[i], err = hex.DecodeString(encoded[i]); err != nil {
+			return nil, fmt.Errorf("invalid hash %d: %w", i, err)
 		}
-		return nil, nil, fmt.Errorf("invalid server response %d error '%s'", response.StatusCode, message.Error)
 	}
-	var requestResponse RequestResponse
-	if err := json.NewDecoder(response.Body).Decode(&requestResponse); err != nil {
+	return hashes, nil
+}
+
+// Infos returns the metadata of every file of a named or Metadata batch in
+// index order.
+func (c Client) Infos(root string) ([]FileInfo, error) {
+	response, err := http.Get(fmt.Sprintf("%s/roots/%s/list", c.url, url.PathEscape(root)))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, responseError(response)
+	}
+	var infos []FileInfo
+	if err := json.NewDecoder(response.Body).Decode(&infos); err != nil {
+		return nil, err
+	}
+	return infos, nil
+}
+
+// Consistency returns the proof that the batch of old is a previous version
+// of the batch of root.
+func (c Client) Consistency(old, root string) (*merkletree.ConsistencyProof, error) {
+	response, err := http.Get(fmt.Sprintf("%s/roots/%s/consistency/%s", c.url, url.PathEscape(root), url.PathEscape(old)))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, responseError(response)
+	}
+	var proof merkletree.ConsistencyProof
+	if err := json.NewDecoder(response.Body).Decode(&proof); err != nil {
+		return nil, err
+	}
+	return &proof, nil
+}
+
+func (c Client) Batch(root string) (Batch, error) {
+	response, err := http.Get(fmt.Sprintf("%s/roots/%s", c.url, url.PathEscape(root)))
+	if err != nil {
+		return Batch{}, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return Batch{}, responseError(response)
+	}
+	var batch Batch
+	if err := json.NewDecoder(response.Body).Decode(&batch); err != nil {
+		return Batch{}, err
+	}
+	return batch, nil
+}
+
+// Delete removes a batch from the server.
+func (c Client) Delete(root string) error {
+	req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("%s/roots/%s", c.url, url.PathEscape(root)), nil)
+	if err != nil {
+		return err
+	}
+	response, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return responseError(response)
+	}
+	return nil
+}
+
+// Stats returns the deduplication statistics of the server.
+func (c Client) Stats() (Stats, error) {
+	response, err := http.Get(c.url + "/stats")
+	if err != nil {
+		return Stats{}, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return Stats{}, responseError(response)
+	}
+	var stats Stats
+	if err := json.NewDecoder(response.Body).Decode(&stats); err != nil {
+		return Stats{}, err
+	}
+	return stats, nil
+}
+
+func (c Client) Status(root string) (Status, error) {
+	response, err := http.Get(fmt.Sprintf("%s/roots/%s/status", c.url, url.PathEscape(root)))
+	if err != nil {
+		return Status{}, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return Status{}, responseError(response)
+	}
+	var status Status
+	if err := json.NewDecoder(response.Body).Decode(&status); err != nil {
+		return Status{}, err
+	}
+	return status, nil
+}
+
+// RequestBlock streams a single block of a chunked file, the caller must close
+// the returned reader.
+func (c Client) RequestBlock(root string, index, block int) (io.ReadCloser, *BlockProof, error) {
+	response, err := http.Get(fmt.Sprintf("%s/blocks/%d", c.fileURL(root, index), block))
+	if err != nil {
 		return nil, nil, err
 	}
-	return bytes.NewBuffer(requestResponse.Content), merkletree.NewProof(sha256.New, requestResponse.Proof), nil
+	if response.StatusCode != http.StatusOK {
+		defer response.Body.Close()
+		return nil, nil, responseError(response)
+	}
+	proof, err := decodeBlockProof(response.Header, index, block)
+	if err != nil {
+		response.Body.Close()
+		return nil, nil, err
+	}
+	return response.Body, proof, nil
+}
+
+func decodeBlockProof(header http.Header, index, block int) (*BlockProof, error) {
+	batch := batchOf(header).withDefaults()
+	if _, err := batch.NewHash(); err != nil {
+		return nil, err
+	}
+	fileHashes, err := decodeProofHeader(header.Get(proofHeader))
+	if err != nil {
+		return nil, err
+	}
+	blockHashes, err := decodeProofHeader(header.Get(blockProofHeader))
+	if err != nil {
+		return nil, err
+	}
+	total, err := decodeSizeHeader(header, totalHeader)
+	if err != nil {
+		return nil, err
+	}
+	blocks, err := decodeSizeHeader(header, blockCountHeader)
+	if err != nil {
+		return nil, err
+	}
+	fileHash, err := hex.DecodeString(header.Get(fileHashHeader))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s header: %w", fileHashHeader, err)
+	}
+	return &BlockProof{
+		Hash:     batch.Hash,
+		FileHash: fileHash,
+		Block:    merkletree.NewProof(block, blocks, blockHashes, batch.Options()...),
+		File:     merkletree.NewProof(index, total, fileHashes, batch.Options()...),
+	}, nil
+}
+
+// batchOf returns the tree settings of the batch a response is from.
+func batchOf(header http.Header) Batch {
+	return Batch{Hash: header.Get(hashHeader), OddNode: merkletree.OddNode(header.Get(oddNodeHeader))}
+}
+
+func decodeSizeHeader(header http.Header, name string) (int, error) {
+	size, err := strconv.Atoi(header.Get(name))
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s header: %w", name, err)
+	}
+	return size, nil
+}
+
+func (c Client) nameURL(root, name, suffix string) string {
+	return fmt.Sprintf("%s/roots/%s/name%s?%s", c.url, url.PathEscape(root), suffix, url.Values{"name": {name}}.Encode())
+}
+
+func (c Client) fileURL(root string, index int) string {
+	return fmt.Sprintf("%s/roots/%s/files/%d", c.url, url.PathEscape(root), index)
+}
+
+func responseError(response *http.Response) error {
+	var message JSONError
+	if err := json.NewDecoder(response.Body).Decode(&message); err != nil {
+		message.Error = http.StatusText(response.StatusCode)
+	}
+	err := fmt.Errorf("invalid server response %d error '%s'", response.StatusCode, message.Error)
+	if known, exist := errorCodes[message.Code]; exist {
+		return fmt.Errorf("%w: %w", known, err)
+	}
+	return err
 }
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"encoding/binary"
+	"fmt"
+	"mime"
+)
+
+// FileInfo is the metadata of a file. In a Metadata batch it is committed in
+// the leaf of the file along with the hash of its content, so the proof of a
+// file covers its metadata too.
+type FileInfo struct {
+	Name        string `json:"name,omitempty"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type,omitempty"`
+	// ModTime is the modification time in seconds since the Unix epoch.
+	ModTime int64 `json:"mod_time,omitempty"`
+}
+
+// Commit returns the hash committed in the leaf of a file of batch whose
+// content has hash h:
+//
+//	hash(h || len(name) || name || size || len(content type) || content type || mod time)
+//
+// with every length and number encoded on 8 big endian bytes.
+func (info FileInfo) Commit(batch Batch, h []byte) ([]byte, error) {
+	newHash, err := batch.NewHash()
+	if err != nil {
+		return nil, err
+	}
+	b := append([]byte{}, h...)
+	b = binary.BigEndian.AppendUint64(b, uint64(len(info.Name)))
+	b = append(b, info.Name...)
+	b = binary.BigEndian.AppendUint64(b, uint64(info.Size))
+	b = binary.BigEndian.AppendUint64(b, uint64(len(info.ContentType)))
+	b = append(b, info.ContentType...)
+	b = binary.BigEndian.AppendUint64(b, uint64(info.ModTime))
+	hasher := newHash()
+	hasher.Write(b)
+	return hasher.Sum(nil), nil
+}
+
+func (info FileInfo) validate() error {
+	if info.Size < 0 {
+		return fmt.Errorf("%w: size %d", ErrInvalidBatch, info.Size)
+	}
+	if info.ContentType == "" {
+		return nil
+	}
+	if _, _, err := mime.ParseMediaType(info.ContentType); err != nil {
+		return fmt.Errorf("%w: content type %q: %w", ErrInvalidBatch, info.ContentType, err)
+	}
+	return nil
+}
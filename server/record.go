@@ -0,0 +1,190 @@
+package server
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/tclairet/merklestore/merkletree"
+)
+
+const recordVersion = 1
+
+const (
+	// leafSchemeIndexed leaves are hash(decimal index || file hash), as built
+	// by merkletree.IndexedBuilder.
+	leafSchemeIndexed = "indexed"
+	// leafSchemeNamed leaves are the entries of a merkletree.SparseMerkleTree
+	// mapping file names to file hashes.
+	leafSchemeNamed = "named"
+	// leafSchemeMetadata leaves are indexed leaves of the hash committing to
+	// the file hash and its FileInfo, see FileInfo.Commit.
+	leafSchemeMetadata = "metadata"
+)
+
+var ErrCorruptedTree = errors.New("stored tree does not match its root")
+
+// Record is the self describing form of a batch kept by the store, it holds
+// everything needed to rebuild the exact tree computed at upload time.
+type Record struct {
+	Version    int    `json:"version"`
+	LeafScheme string `json:"leaf_scheme"`
+	Batch
+	Hashes [][]byte `json:"hashes,omitempty"`
+	// Names holds the name of every file of a named batch.
+	Names []string `json:"names,omitempty"`
+	// Infos holds the metadata of every file of a Metadata batch.
+	Infos []FileInfo `json:"infos,omitempty"`
+	// Blobs holds the key of the blob storing every file. It is nil until a
+	// blob is saved, files without one are stored under ROOT/INDEX as they
+	// were before blobs.
+	Blobs []string `json:"blobs,omitempty"`
+}
+
+// NewRecord returns the record of a batch for which no hash was received yet.
+func NewRecord(batch Batch) *Record {
+	record := &Record{
+		Version:    recordVersion,
+		LeafScheme: leafSchemeIndexed,
+		Batch:      batch.withDefaults(),
+		Hashes:     make([][]byte, batch.Total),
+	}
+	if batch.Named {
+		record.LeafScheme = leafSchemeNamed
+		record.Names = make([]string, batch.Total)
+	}
+	if batch.Metadata {
+		record.LeafScheme = leafSchemeMetadata
+		record.Infos = make([]FileInfo, batch.Total)
+	}
+	return record
+}
+
+func (record Record) clone() Record {
+	hashes := make([][]byte, len(record.Hashes))
+	for i, h := range record.Hashes {
+		hashes[i] = bytes.Clone(h)
+	}
+	record.Hashes = hashes
+	record.Names = slices.Clone(record.Names)
+	record.Infos = slices.Clone(record.Infos)
+	record.Blobs = slices.Clone(record.Blobs)
+	return record
+}
+
+// Received returns the indexes of the files whose hash is stored.
+func (record Record) Received() []int {
+	received := []int{}
+	for index, h := range record.Hashes {
+		if len(h) != 0 {
+			received = append(received, index)
+		}
+	}
+	return received
+}
+
+func (record Record) Complete() bool {
+	return len(record.Received()) == record.Total
+}
+
+func (record Record) validate() error {
+	if record.Version != recordVersion {
+		return fmt.Errorf("unsupported record version %d", record.Version)
+	}
+	switch {
+	case record.LeafScheme == leafSchemeIndexed && !record.Named && !record.Metadata:
+	case record.LeafScheme == leafSchemeNamed && record.Named:
+		if len(record.Names) != record.Total {
+			return fmt.Errorf("record holds %d names for %d files", len(record.Names), record.Total)
+		}
+	case record.LeafScheme == leafSchemeMetadata && record.Metadata:
+		if len(record.Infos) != record.Total {
+			return fmt.Errorf("record holds %d file infos for %d files", len(record.Infos), record.Total)
+		}
+	default:
+		return fmt.Errorf("unsupported leaf scheme %q", record.LeafScheme)
+	}
+	if record.Blobs != nil && len(record.Blobs) != record.Total {
+		return fmt.Errorf("record holds %d blobs for %d files", len(record.Blobs), record.Total)
+	}
+	if _, err := merkletree.HashFunc(record.Hash); err != nil {
+		return err
+	}
+	if len(record.Hashes) != record.Total {
+		return fmt.Errorf("record holds %d hashes for %d files", len(record.Hashes), record.Total)
+	}
+	return nil
+}
+
+// builder returns an IndexedBuilder filled with every hash received so far.
+func (record Record) builder() (*merkletree.IndexedBuilder, error) {
+	if err := record.validate(); err != nil {
+		return nil, err
+	}
+	builder := merkletree.NewIndexedBuilder(record.Total, record.Batch.Options()...)
+	for index, hash := range record.Hashes {
+		if len(hash) == 0 {
+			continue
+		}
+		if _, err := builder.AddHash(index, hash); err != nil {
+			return nil, err
+		}
+	}
+	return builder, nil
+}
+
+// tree rebuilds the tree of a complete record and checks it against the
+// recorded root.
+func (record Record) tree() (*storedTree, error) {
+	if !record.Complete() {
+		return nil, fmt.Errorf("record holds %d of %d hashes", len(record.Received()), record.Total)
+	}
+	stored, err := record.build()
+	if err != nil {
+		return nil, err
+	}
+	root, err := hex.DecodeString(record.Root)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid root: %w", ErrCorruptedTree, err)
+	}
+	if !bytes.Equal(stored.root(), root) {
+		return nil, fmt.Errorf("%w: rebuilt %x", ErrCorruptedTree, stored.root())
+	}
+	return stored, nil
+}
+
+// build builds the tree of a complete record according to its leaf scheme.
+func (record Record) build() (*storedTree, error) {
+	if record.LeafScheme != leafSchemeNamed {
+		builder, err := record.builder()
+		if err != nil {
+			return nil, err
+		}
+		tree, err := builder.Build()
+		if err != nil {
+			return nil, err
+		}
+		return &storedTree{batch: record.Batch, tree: tree, infos: slices.Clone(record.Infos), blobs: slices.Clone(record.Blobs)}, nil
+	}
+	if err := record.validate(); err != nil {
+		return nil, err
+	}
+	entries := make(map[string][]byte, record.Total)
+	indexes := make(map[string]int, record.Total)
+	infos := make([]FileInfo, record.Total)
+	for index, name := range record.Names {
+		if _, exist := entries[name]; exist {
+			return nil, fmt.Errorf("name %q used twice", name)
+		}
+		entries[name] = record.Hashes[index]
+		indexes[name] = index
+		infos[index].Name = name
+	}
+	tree, err := merkletree.NewSparse(entries, record.Batch.Options()...)
+	if err != nil {
+		return nil, err
+	}
+	return &storedTree{batch: record.Batch, sparse: tree, indexes: indexes, infos: infos, blobs: slices.Clone(record.Blobs)}, nil
+}
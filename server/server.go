@@ -1,13 +1,18 @@
 package server
 
 import (
-	"crypto/sha256"
+	"bytes"
+	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"os"
-	"strconv"
+	"path"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/tclairet/merklestore/files"
 	"github.com/tclairet/merklestore/merkletree"
@@ -15,101 +20,694 @@ import (
 
 var logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
 
+var (
+	ErrRootMismatch  = errors.New("computed root does not match claimed root")
+	ErrRootExists    = errors.New("root already stored")
+	ErrUnknownRoot   = errors.New("unknown or unfinished tree")
+	ErrInvalidBatch  = errors.New("invalid batch")
+	ErrIndexReceived = errors.New("index already received")
+	ErrUnknownName   = errors.New("unknown file name")
+	ErrUnknownBlob   = errors.New("no stored file has this hash")
+)
+
+// Server stores batches of files and serves them with their merkle proofs.
+// It is safe for concurrent use: mu guards the maps below, file contents are
+// streamed without holding it and the stored trees are never modified.
+//
+// Files are stored once per content, in blobs shared by every batch holding
+// them. Blobs are counted by the records referencing them and deleted with
+// the last one.
 type Server struct {
-	files    files.Handler
-	db       store
-	builders map[string]*merkletree.IndexedBuilder
-	trees    map[string]*merkletree.MerkleTree
+	files files.Handler
+	db    MetadataStore
+
+	mu      sync.RWMutex
+	pending map[string]*pending
+	trees   map[string]*storedTree
+	// unavailable holds the stored batches refused at startup
+	unavailable map[string]error
+
+	// blobsMu guards blobs and serializes the moves and deletions of blob
+	// files, it is taken after mu when both are held.
+	blobsMu sync.Mutex
+	blobs   map[string]*blob
 }
 
-func New(files files.Handler, db store) (*Server, error) {
-	savedTrees := db.read()
-	trees := make(map[string]*merkletree.MerkleTree)
-	for root, hashes := range savedTrees {
-		tree, err := merkletree.FromHashes(hashes, sha256.New)
-		if err != nil {
-			return nil, err
-		}
-		trees[root] = tree
+// blob is a stored file content referenced refs times.
+type blob struct {
+	refs int
+	size int64
+	// blocks is the block tree of a blob of a chunked batch, loaded from
+	// its block hashes on the first block request
+	blocks *merkletree.MerkleTree
+}
+
+// storedTree is a completed batch. tree is set for batches addressed by
+// index, sparse and the index of every name for named batches. infos holds
+// the metadata of the files of named and Metadata batches, blobs the blob
+// key of every file when the record keeps them.
+type storedTree struct {
+	batch   Batch
+	tree    *merkletree.MerkleTree
+	sparse  *merkletree.SparseMerkleTree
+	indexes map[string]int
+	infos   []FileInfo
+	blobs   []string
+}
+
+func (stored *storedTree) root() []byte {
+	if stored.sparse != nil {
+		return stored.sparse.Root()
 	}
-	return &Server{
-		files:    files,
-		db:       db,
-		builders: make(map[string]*merkletree.IndexedBuilder),
-		trees:    trees,
-	}, nil
+	return stored.tree.Root()
 }
 
-func (s *Server) Upload(root string, index, total int, file io.Reader) error {
-	if err := s.files.Save(fmt.Sprintf("%s/%d", root, index), file); err != nil {
-		return err
+// indexed returns the tree of a batch addressed by index.
+func (stored *storedTree) indexed() (*merkletree.MerkleTree, error) {
+	if stored.tree == nil {
+		return nil, fmt.Errorf("%w: %s is addressed by name", ErrInvalidBatch, stored.batch.Root)
+	}
+	return stored.tree, nil
+}
+
+// pending is a batch still receiving files. An index is reserved in inflight
+// while its file is streamed so a concurrent upload of the same index is
+// rejected instead of racing on the stored file. names holds the index of
+// the names received or being received for a named batch. updated is the
+// last time a file started or finished uploading, see Expire.
+type pending struct {
+	batch    Batch
+	builder  *merkletree.IndexedBuilder
+	inflight map[int]bool
+	names    map[string]int
+	updated  time.Time
+}
+
+func newPending(batch Batch, builder *merkletree.IndexedBuilder) *pending {
+	return &pending{
+		batch:    batch,
+		builder:  builder,
+		inflight: make(map[int]bool),
+		names:    make(map[string]int),
+		updated:  time.Now(),
 	}
-	reader, err := s.files.Open(fmt.Sprintf("%s/%d", root, index))
+}
+
+func New(ctx context.Context, files files.Handler, db MetadataStore) (*Server, error) {
+	s := &Server{
+		files:       files,
+		db:          db,
+		pending:     make(map[string]*pending),
+		trees:       make(map[string]*storedTree),
+		unavailable: make(map[string]error),
+		blobs:       make(map[string]*blob),
+	}
+	records, err := db.List(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	hasher := sha256.New()
-	if _, err := io.Copy(hasher, reader); err != nil {
-		return err
+	for _, record := range records {
+		s.restore(record)
+		s.retain(record.Blobs...)
 	}
+	return s, nil
+}
 
-	if err := s.db.save(root, hasher.Sum(nil), index, total); err != nil {
-		return err
+// restore rebuilds the state of a stored batch, completed batches get their
+// tree back while partial ones get a builder holding the received hashes.
+// Records that cannot be rebuilt exactly are kept out of service.
+func (s *Server) restore(record Record) {
+	root := record.Root
+	if !record.Complete() {
+		builder, err := record.builder()
+		if err == nil {
+			upload := newPending(record.Batch, builder)
+			for index, name := range record.Names {
+				if name != "" {
+					upload.names[name] = index
+				}
+			}
+			s.pending[root] = upload
+			return
+		}
+		s.unavailable[root] = err
+	} else {
+		stored, err := record.tree()
+		if err == nil {
+			s.trees[root] = stored
+			return
+		}
+		s.unavailable[root] = err
+	}
+	logger.Error("cannot restore batch",
+		"root", root,
+		"error", s.unavailable[root].Error(),
+	)
+}
+
+// Upload stores the file at index of batch. Uploads of different indexes can
+// run concurrently, an index already received or being received is rejected
+// with ErrIndexReceived. The upload completing the batch builds its tree and
+// checks it against the claimed root.
+func (s *Server) Upload(ctx context.Context, batch Batch, index int, file io.Reader) error {
+	return s.uploadFile(ctx, batch, index, FileInfo{}, file)
+}
+
+// UploadNamed stores the file at index of a named batch under name, as
+// Upload does. Every file of the batch must have a different name.
+func (s *Server) UploadNamed(ctx context.Context, batch Batch, index int, name string, file io.Reader) error {
+	batch.Named = true
+	return s.uploadFile(ctx, batch, index, FileInfo{Name: name}, file)
+}
+
+// UploadWithInfo stores the file at index of a Metadata batch along with its
+// metadata, as Upload does. The size of the file must be the one of info.
+func (s *Server) UploadWithInfo(ctx context.Context, batch Batch, index int, info FileInfo, file io.Reader) error {
+	batch.Metadata = true
+	return s.uploadFile(ctx, batch, index, info, file)
+}
+
+// UploadStored stores the file at index of batch from the stored file whose
+// hash is hash, as Upload does with its content. The batch is named or
+// Metadata as given and info is the one of UploadNamed or UploadWithInfo. It
+// fails with ErrUnknownBlob when the server stores no such file, see Have.
+func (s *Server) UploadStored(ctx context.Context, batch Batch, index int, info FileInfo, hash []byte) error {
+	return s.upload(ctx, batch, index, info, func(batch Batch) ([]byte, error) {
+		return s.link(ctx, batch, index, info, hash)
+	})
+}
+
+// Have reports which of hashes are the hash of a file stored for a batch of
+// the hash algorithm, block size and odd node strategy of batch. Those files
+// can be uploaded with UploadStored without sending their content.
+func (s *Server) Have(_ context.Context, batch Batch, hashes [][]byte) ([]bool, error) {
+	if _, err := batch.NewHash(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidBatch, err)
 	}
+	s.blobsMu.Lock()
+	defer s.blobsMu.Unlock()
+	have := make([]bool, len(hashes))
+	for i, h := range hashes {
+		have[i] = s.blobs[batch.blobKey(h)] != nil
+	}
+	return have, nil
+}
 
-	if s.builders[root] == nil {
-		s.builders[root] = merkletree.NewIndexedBuilder(total)
+func (s *Server) uploadFile(ctx context.Context, batch Batch, index int, info FileInfo, file io.Reader) error {
+	return s.upload(ctx, batch, index, info, func(batch Batch) ([]byte, error) {
+		return s.save(ctx, batch, index, info, file)
+	})
+}
+
+// upload adds the file at index of batch, save stores it and returns the hash
+// of its leaf.
+func (s *Server) upload(ctx context.Context, batch Batch, index int, info FileInfo, save func(Batch) ([]byte, error)) error {
+	batch = batch.withDefaults()
+	root := batch.Root
+	if err := batch.validateFile(index, info); err != nil {
+		return err
+	}
+	inherited, err := s.inherit(ctx, batch)
+	if err != nil {
+		return err
+	}
+	if err := s.reserve(ctx, batch, index, info, inherited); err != nil {
+		return err
 	}
 
-	done, err := s.builders[root].AddHash(index, hasher.Sum(nil))
+	hash, err := save(batch)
 	if err != nil {
+		s.mu.Lock()
+		upload := s.pending[root]
+		delete(upload.inflight, index)
+		if batch.Named {
+			delete(upload.names, info.Name)
+		}
+		if upload.builder.Count() == 0 && len(upload.inflight) == 0 {
+			// nothing received, a later upload may start the batch over
+			delete(s.pending, root)
+		}
+		s.mu.Unlock()
 		return err
 	}
 
 	logger.Info("uploaded",
 		"root", root,
 		"index", index,
-		"hash", hex.EncodeToString(hasher.Sum(nil)),
+		"hash", hex.EncodeToString(hash),
 	)
 
-	if !done {
-		return nil
+	s.mu.Lock()
+	upload := s.pending[root]
+	upload.updated = time.Now()
+	done, err := upload.builder.AddHash(index, hash)
+	if err != nil || !done {
+		delete(upload.inflight, index)
+		s.mu.Unlock()
+		return err
 	}
+	s.mu.Unlock()
 
-	s.trees[root], err = s.builders[root].Build()
+	// the last file stays in flight until the batch is stored, so that the
+	// batch cannot be deleted while it is built
+	stored, err := s.build(ctx, upload)
+	if err == nil {
+		if computed := hex.EncodeToString(stored.root()); computed != root {
+			logger.Warn("root mismatch",
+				"root", root,
+				"computed", computed,
+			)
+			err = fmt.Errorf("%w: got %s", ErrRootMismatch, computed)
+			if discardErr := s.discard(ctx, root); discardErr != nil {
+				err = discardErr
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pending, root)
 	if err != nil {
 		return err
 	}
+	s.trees[root] = stored
+	return nil
+}
 
-	delete(s.builders, root)
+// build returns the tree of a complete upload, the names, metadata and blobs
+// of the files are read back from the store.
+func (s *Server) build(ctx context.Context, upload *pending) (*storedTree, error) {
+	record, err := s.db.Get(ctx, upload.batch.Root)
+	if err != nil {
+		return nil, err
+	}
+	if upload.batch.Named || upload.batch.Metadata {
+		return record.build()
+	}
+	tree, err := upload.builder.Build()
+	if err != nil {
+		return nil, err
+	}
+	return &storedTree{batch: upload.batch, tree: tree, blobs: record.Blobs}, nil
+}
+
+// inherit returns the record of the parent of a valid batch which did not
+// start yet, after recording its files for the batch. The files keep the
+// blobs of the parent, the record is deleted when they cannot all be
+// recorded.
+func (s *Server) inherit(ctx context.Context, batch Batch) (*Record, error) {
+	s.mu.RLock()
+	_, unavailable := s.unavailable[batch.Root]
+	started := s.pending[batch.Root] != nil || s.trees[batch.Root] != nil || unavailable
+	s.mu.RUnlock()
+	if batch.Parent == "" || started {
+		return nil, nil
+	}
+	parent, err := s.Batch(ctx, batch.Parent)
+	if err != nil {
+		return nil, fmt.Errorf("%w: parent %s: %w", ErrInvalidBatch, batch.Parent, err)
+	}
+	parent = parent.withDefaults()
+	if parent.Total >= batch.Total || parent.BlockSize != batch.BlockSize || parent.Hash != batch.Hash || parent.OddNode != batch.OddNode || parent.Metadata != batch.Metadata || parent.Named || parent.Manifest {
+		return nil, fmt.Errorf("%w: %s of %d files cannot extend %s of %d files", ErrInvalidBatch, batch.Root, batch.Total, parent.Root, parent.Total)
+	}
+	record, err := s.db.Get(ctx, parent.Root)
+	if err != nil {
+		return nil, err
+	}
+	for index, hash := range record.Hashes {
+		var info FileInfo
+		if record.Infos != nil {
+			info = record.Infos[index]
+		}
+		var blob string
+		if record.Blobs != nil {
+			blob = record.Blobs[index]
+		}
+		if err := s.db.Save(ctx, batch, index, info, hash, blob); err != nil {
+			return nil, errors.Join(err, s.db.Delete(ctx, batch.Root))
+		}
+	}
+	return &record, nil
+}
+
+// reserve checks that index of a valid batch can be uploaded and marks it in
+// flight, the inherited files are the first ones of a batch starting with
+// this upload. Their record is deleted when the parent was deleted since.
+func (s *Server) reserve(ctx context.Context, batch Batch, index int, info FileInfo, inherited *Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	root := batch.Root
+	if err := s.unavailable[root]; err != nil {
+		return err
+	}
+	if s.trees[root] != nil {
+		return ErrRootExists
+	}
+	upload := s.pending[root]
+	if upload == nil {
+		builder := merkletree.NewIndexedBuilder(batch.Total, batch.Options()...)
+		if inherited != nil {
+			if s.trees[batch.Parent] == nil {
+				err := fmt.Errorf("%w: parent %s: %w", ErrInvalidBatch, batch.Parent, ErrUnknownRoot)
+				return errors.Join(err, s.db.Delete(ctx, root))
+			}
+			for i, hash := range inherited.Hashes {
+				if _, err := builder.AddHash(i, hash); err != nil {
+					return err
+				}
+			}
+			s.retain(inherited.Blobs...)
+		}
+		upload = newPending(batch, builder)
+		s.pending[root] = upload
+	}
+	if started := upload.batch; started != batch {
+		return fmt.Errorf("%w: %s already started with %d files and block size %d", ErrInvalidBatch, root, started.Total, started.BlockSize)
+	}
+	if upload.inflight[index] || upload.builder.Has(index) {
+		return fmt.Errorf("%w: %d", ErrIndexReceived, index)
+	}
+	if batch.Named {
+		if other, used := upload.names[info.Name]; used {
+			return fmt.Errorf("%w: name %q already used by file %d", ErrInvalidBatch, info.Name, other)
+		}
+		upload.names[info.Name] = index
+	}
+	upload.inflight[index] = true
+	upload.updated = time.Now()
 	return nil
 }
 
-func (s *Server) Request(root string, index int) (io.Reader, *merkletree.Proof, error) {
-	if s.trees[root] == nil {
-		return nil, nil, fmt.Errorf("unknow or unfinished tree")
+// save streams file to its blob and records its hash, the one committing to
+// its metadata for a Metadata batch. The file is staged under
+// staging/ROOT/INDEX until its hash gives the key of its blob, and deleted
+// when the file cannot be saved.
+func (s *Server) save(ctx context.Context, batch Batch, index int, info FileInfo, file io.Reader) (_ []byte, err error) {
+	hasher, sum := batch.FileHasher()
+	counter := &countingWriter{Writer: hasher}
+	staged := stagingPath(batch.Root, index)
+	defer func() {
+		if err != nil {
+			err = errors.Join(err, s.files.Delete(staged))
+		}
+	}()
+	if err := s.files.Save(staged, io.TeeReader(file, counter)); err != nil {
+		return nil, err
 	}
-	file, err := s.files.Open(fmt.Sprintf("%s/%d", root, index))
+	hash, err := sum()
 	if err != nil {
-		return nil, nil, err
+		return nil, err
+	}
+	if batch.Metadata && counter.n != info.Size {
+		return nil, fmt.Errorf("%w: got %d bytes for a file of %d", ErrInvalidBatch, counter.n, info.Size)
+	}
+	var blocks [][]byte
+	if hasher, ok := hasher.(*merkletree.BlockHasher); ok {
+		blocks = hasher.Hashes()
+	}
+	key := batch.blobKey(hash)
+	if err := s.store(staged, key, counter.n, blocks); err != nil {
+		return nil, err
+	}
+	return s.saveHash(ctx, batch, index, info, hash, key)
+}
+
+// link records the stored file whose hash is hash at index of batch.
+func (s *Server) link(ctx context.Context, batch Batch, index int, info FileInfo, hash []byte) ([]byte, error) {
+	key := batch.blobKey(hash)
+	var err error
+	s.blobsMu.Lock()
+	switch stored := s.blobs[key]; {
+	case stored == nil:
+		err = fmt.Errorf("%w: %x", ErrUnknownBlob, hash)
+	case batch.Metadata && stored.size != info.Size:
+		err = fmt.Errorf("%w: stored file has %d bytes for a file of %d", ErrInvalidBatch, stored.size, info.Size)
+	default:
+		stored.refs++
 	}
-	hash, err := s.db.get(root, index)
+	s.blobsMu.Unlock()
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	hasher := sha256.New()
-	hasher.Write([]byte(strconv.Itoa(index)))
-	hasher.Write(hash)
+	return s.saveHash(ctx, batch, index, info, hash, key)
+}
 
-	proof, err := s.trees[root].ProofFor(hasher.Sum(nil))
+// saveHash records the file at index stored in the blob of key, the blob is
+// released when it cannot be recorded.
+func (s *Server) saveHash(ctx context.Context, batch Batch, index int, info FileInfo, hash []byte, key string) ([]byte, error) {
+	var err error
+	if batch.Metadata {
+		if hash, err = info.Commit(batch, hash); err != nil {
+			return nil, errors.Join(err, s.release(key))
+		}
+	}
+	if err := s.db.Save(ctx, batch, index, info, hash, key); err != nil {
+		return nil, errors.Join(err, s.release(key))
+	}
+	return hash, nil
+}
+
+// store moves a staged file to the blob of key, unless the blob is already
+// stored, and adds a reference to the blob. The hashes of the blocks of a
+// file of a chunked batch are saved along with its blob.
+func (s *Server) store(staged, key string, size int64, blocks [][]byte) error {
+	s.blobsMu.Lock()
+	defer s.blobsMu.Unlock()
+	stored := s.blobs[key]
+	if stored != nil {
+		if err := s.files.Delete(staged); err != nil {
+			return err
+		}
+	} else {
+		if blocks != nil {
+			if err := s.files.Save(blocksPath(key), bytes.NewReader(bytes.Join(blocks, nil))); err != nil {
+				return err
+			}
+		}
+		if err := files.Move(s.files, staged, blobPath(key)); err != nil {
+			return err
+		}
+		stored = &blob{size: size}
+		s.blobs[key] = stored
+	}
+	stored.refs++
+	return nil
+}
+
+// retain adds a reference to the blobs of keys, empty keys are skipped.
+func (s *Server) retain(keys ...string) {
+	s.blobsMu.Lock()
+	defer s.blobsMu.Unlock()
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		stored := s.blobs[key]
+		if stored == nil {
+			stored = &blob{size: s.size(blobPath(key))}
+			s.blobs[key] = stored
+		}
+		stored.refs++
+	}
+}
+
+// release removes a reference to the blobs of keys and deletes the blobs no
+// longer referenced, empty keys are skipped.
+func (s *Server) release(keys ...string) error {
+	s.blobsMu.Lock()
+	defer s.blobsMu.Unlock()
+	for _, key := range keys {
+		stored := s.blobs[key]
+		if stored == nil {
+			continue
+		}
+		if stored.refs--; stored.refs > 0 {
+			continue
+		}
+		delete(s.blobs, key)
+		if err := s.files.Delete(blobPath(key)); err != nil {
+			return err
+		}
+		if err := s.files.Delete(blocksPath(key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// size returns the size of the file at path, read when the file handler is
+// not a files.Stater. Missing files have no size.
+func (s *Server) size(path string) int64 {
+	if stater, ok := s.files.(files.Stater); ok {
+		if info, err := stater.Stat(path); err == nil {
+			return info.Size()
+		}
+		return 0
+	}
+	file, err := s.files.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+	size, _ := io.Copy(io.Discard, file)
+	return size
+}
+
+// Staged files, blobs and the block hashes of blobs live under their own
+// prefixes, so that no root can name a blob.
+func stagingPath(root string, index int) string {
+	return fmt.Sprintf("staging/%s/%d", root, index)
+}
+
+func blobPath(key string) string {
+	return "blobs/" + key
+}
+
+func blocksPath(key string) string {
+	return "blocks/" + key
+}
+
+// countingWriter counts the bytes written to Writer.
+type countingWriter struct {
+	io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// discard removes the record of a batch and the files no other batch
+// references.
+func (s *Server) discard(ctx context.Context, root string) error {
+	record, err := s.db.Get(ctx, root)
+	if err != nil && !errors.Is(err, ErrRecordNotFound) {
+		return err
+	}
+	if err := s.db.Delete(ctx, root); err != nil {
+		return err
+	}
+	// files stored before blobs and staged files, only under a root which
+	// cannot name anything else
+	if err := record.Batch.validateRoot(root); err != nil {
+		return err
+	}
+	if err := s.files.Delete("staging/" + root); err != nil {
+		return err
+	}
+	if err := s.files.Delete(root); err != nil {
+		return err
+	}
+	return s.release(record.Blobs...)
+}
+
+// Delete removes a batch along with the blobs no other batch references.
+// Batches extended by another one and batches receiving files are kept.
+func (s *Server) Delete(ctx context.Context, root string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	upload := s.pending[root]
+	if _, unavailable := s.unavailable[root]; !unavailable && upload == nil && s.trees[root] == nil {
+		return ErrUnknownRoot
+	}
+	if upload != nil && len(upload.inflight) != 0 {
+		return fmt.Errorf("%w: %s is receiving files", ErrInvalidBatch, root)
+	}
+	if child := s.extended(root); child != "" {
+		return fmt.Errorf("%w: %s is extended by %s", ErrInvalidBatch, root, child)
+	}
+	if err := s.discard(ctx, root); err != nil {
+		return err
+	}
+	delete(s.pending, root)
+	delete(s.trees, root)
+	delete(s.unavailable, root)
+	logger.Info("deleted",
+		"root", root,
+	)
+	return nil
+}
+
+// Expire deletes the partial batches which received no file for idle, as
+// Delete does, and returns their roots. It keeps anyone from holding a root
+// by starting its batch without finishing it.
+func (s *Server) Expire(ctx context.Context, idle time.Duration) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var expired []string
+	for root, upload := range s.pending {
+		if len(upload.inflight) != 0 || time.Since(upload.updated) < idle || s.extended(root) != "" {
+			continue
+		}
+		if err := s.discard(ctx, root); err != nil {
+			return expired, err
+		}
+		delete(s.pending, root)
+		expired = append(expired, root)
+		logger.Info("expired",
+			"root", root,
+		)
+	}
+	slices.Sort(expired)
+	return expired, nil
+}
+
+// extended returns the root of a batch extending root, if any.
+func (s *Server) extended(root string) string {
+	for _, stored := range s.trees {
+		if stored.batch.Parent == root {
+			return stored.batch.Root
+		}
+	}
+	for _, upload := range s.pending {
+		if upload.batch.Parent == root {
+			return upload.batch.Root
+		}
+	}
+	return ""
+}
+
+// Stats reports the deduplication of the stored files: Files files of the
+// stored batches are kept in Blobs blobs. Bytes is the size of the blobs
+// and FileBytes the size of the files they hold.
+type Stats struct {
+	Blobs     int   `json:"blobs"`
+	Files     int   `json:"files"`
+	Bytes     int64 `json:"bytes"`
+	FileBytes int64 `json:"file_bytes"`
+}
+
+// Stats returns the deduplication statistics of the blobs, files stored
+// before blobs are not counted.
+func (s *Server) Stats(_ context.Context) Stats {
+	s.blobsMu.Lock()
+	defer s.blobsMu.Unlock()
+	stats := Stats{Blobs: len(s.blobs)}
+	for _, stored := range s.blobs {
+		stats.Files += stored.refs
+		stats.Bytes += stored.size
+		stats.FileBytes += int64(stored.refs) * stored.size
+	}
+	return stats
+}
+
+func (s *Server) Request(ctx context.Context, root string, index int) (io.ReadCloser, *merkletree.Proof, error) {
+	proof, err := s.Proof(ctx, root, index)
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := s.files.Open(s.path(root, index))
 	if err != nil {
 		return nil, nil, err
 	}
 	logger.Info("request",
 		"root", root,
 		"index", index,
-		"proof", func() (hashes []string) {
-			for _, h := range proof.Hashes() {
+		"siblings", func() (hashes []string) {
+			for _, h := range proof.Siblings() {
 				hashes = append(hashes, hex.EncodeToString(h))
 			}
 			return
@@ -117,3 +715,440 @@ func (s *Server) Request(root string, index int) (io.Reader, *merkletree.Proof,
 	)
 	return file, proof, nil
 }
+
+func (s *Server) Proof(_ context.Context, root string, index int) (*merkletree.Proof, error) {
+	stored, err := s.tree(root)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := stored.indexed()
+	if err != nil {
+		return nil, err
+	}
+	if err := stored.batch.validate(index); err != nil {
+		return nil, err
+	}
+	return tree.ProofForIndex(index)
+}
+
+// MultiProof returns a single proof for the files at indexes, the files are
+// proven in increasing index order.
+func (s *Server) MultiProof(_ context.Context, root string, indexes []int) (*merkletree.MultiProof, error) {
+	stored, err := s.tree(root)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := stored.indexed()
+	if err != nil {
+		return nil, err
+	}
+	if len(indexes) == 0 {
+		return nil, fmt.Errorf("%w: no index requested", ErrInvalidBatch)
+	}
+	for _, index := range indexes {
+		if err := stored.batch.validate(index); err != nil {
+			return nil, err
+		}
+	}
+	return tree.MultiProofFor(indexes)
+}
+
+// Open returns the content of the file at index of a completed batch.
+func (s *Server) Open(_ context.Context, root string, index int) (io.ReadCloser, error) {
+	stored, err := s.tree(root)
+	if err != nil {
+		return nil, err
+	}
+	if err := stored.batch.validate(index); err != nil {
+		return nil, err
+	}
+	return s.files.Open(s.path(root, index))
+}
+
+// path returns where the file at index of a completed batch is stored, its
+// blob or, for files stored before blobs, ROOT/INDEX of the batch it was
+// uploaded with.
+func (s *Server) path(root string, index int) string {
+	key, root := s.blob(root, index)
+	if key != "" {
+		return blobPath(key)
+	}
+	return fmt.Sprintf("%s/%d", root, index)
+}
+
+// blob returns the key of the blob of the file at index of a completed batch.
+// For files stored before blobs the key is empty and the root is the one of
+// the batch the file was uploaded with.
+func (s *Server) blob(root string, index int) (string, string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for stored := s.trees[root]; stored != nil; stored = s.trees[root] {
+		if stored.blobs != nil && stored.blobs[index] != "" {
+			return stored.blobs[index], root
+		}
+		if stored.batch.Parent == "" {
+			break
+		}
+		parent := s.trees[stored.batch.Parent]
+		if parent == nil || index >= parent.batch.Total {
+			break
+		}
+		root = parent.batch.Root
+	}
+	return "", root
+}
+
+// Leaf returns the leaf of the file at index in the tree of root.
+func (s *Server) Leaf(_ context.Context, root string, index int) ([]byte, error) {
+	stored, err := s.tree(root)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := stored.indexed()
+	if err != nil {
+		return nil, err
+	}
+	if err := stored.batch.validate(index); err != nil {
+		return nil, err
+	}
+	return tree.Leaf(index)
+}
+
+// tree returns the completed tree of root, unless it was refused at startup.
+func (s *Server) tree(root string) (*storedTree, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if err := s.unavailable[root]; err != nil {
+		return nil, err
+	}
+	if s.trees[root] == nil {
+		return nil, ErrUnknownRoot
+	}
+	return s.trees[root], nil
+}
+
+// Batch returns the description of a stored batch.
+func (s *Server) Batch(_ context.Context, root string) (Batch, error) {
+	stored, err := s.tree(root)
+	if err != nil {
+		return Batch{}, err
+	}
+	return stored.batch, nil
+}
+
+// Hashes returns the file hashes of a completed batch in index order.
+func (s *Server) Hashes(ctx context.Context, root string) ([][]byte, error) {
+	if _, err := s.tree(root); err != nil {
+		return nil, err
+	}
+	record, err := s.db.Get(ctx, root)
+	if err != nil {
+		return nil, err
+	}
+	return record.Hashes, nil
+}
+
+// Consistency proves that the tree of old is a previous version of the tree
+// of root, old must be root or one of the batches it extends.
+func (s *Server) Consistency(_ context.Context, old, root string) (*merkletree.ConsistencyProof, error) {
+	stored, err := s.tree(root)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.tree(old); err != nil {
+		return nil, err
+	}
+	ancestor := stored
+	for ancestor.batch.Root != old {
+		if ancestor.batch.Parent == "" {
+			return nil, fmt.Errorf("%w: %s does not extend %s", ErrInvalidBatch, root, old)
+		}
+		if ancestor, err = s.tree(ancestor.batch.Parent); err != nil {
+			return nil, err
+		}
+	}
+	tree, err := stored.indexed()
+	if err != nil {
+		return nil, err
+	}
+	proof, err := tree.ConsistencyProof(ancestor.batch.Total)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidBatch, err)
+	}
+	return proof, nil
+}
+
+// RequestName returns the file called name in a named batch along with the
+// proof of its hash.
+func (s *Server) RequestName(_ context.Context, root, name string) (io.ReadCloser, *merkletree.SparseProof, error) {
+	stored, err := s.named(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	index, exist := stored.indexes[name]
+	if !exist {
+		return nil, nil, fmt.Errorf("%w: %s", ErrUnknownName, name)
+	}
+	file, err := s.files.Open(s.path(root, index))
+	if err != nil {
+		return nil, nil, err
+	}
+	logger.Info("request",
+		"root", root,
+		"name", name,
+	)
+	return file, stored.sparse.ProofFor(name), nil
+}
+
+// NameProof returns the proof of name in a named batch along with the hash
+// of its file. It proves the hash when the batch holds name and the absence
+// of name otherwise, the hash is then nil.
+func (s *Server) NameProof(_ context.Context, root, name string) (*merkletree.SparseProof, []byte, error) {
+	stored, err := s.named(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	hash, _ := stored.sparse.Get(name)
+	return stored.sparse.ProofFor(name), hash, nil
+}
+
+// Info returns the metadata of the file at index, the zero FileInfo for
+// batches keeping none.
+func (s *Server) Info(_ context.Context, root string, index int) (FileInfo, error) {
+	stored, err := s.tree(root)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	if err := stored.batch.validate(index); err != nil {
+		return FileInfo{}, err
+	}
+	if stored.infos == nil {
+		return FileInfo{}, nil
+	}
+	return stored.infos[index], nil
+}
+
+// Infos returns the metadata of every file of a named or Metadata batch in
+// index order, only names are known for named batches.
+func (s *Server) Infos(_ context.Context, root string) ([]FileInfo, error) {
+	stored, err := s.tree(root)
+	if err != nil {
+		return nil, err
+	}
+	if stored.infos == nil {
+		return nil, fmt.Errorf("%w: %s keeps no file metadata", ErrInvalidBatch, root)
+	}
+	return slices.Clone(stored.infos), nil
+}
+
+func (s *Server) named(root string) (*storedTree, error) {
+	stored, err := s.tree(root)
+	if err != nil {
+		return nil, err
+	}
+	if stored.sparse == nil {
+		return nil, fmt.Errorf("%w: %s is addressed by index", ErrInvalidBatch, root)
+	}
+	return stored, nil
+}
+
+// Status reports the progress of an upload, Received lists the indexes
+// already stored for the batch.
+type Status struct {
+	Batch
+	Received []int `json:"received"`
+	Complete bool  `json:"complete"`
+}
+
+func (s *Server) Status(ctx context.Context, root string) (Status, error) {
+	s.mu.RLock()
+	err := s.unavailable[root]
+	complete := s.trees[root] != nil
+	var batch Batch
+	if upload := s.pending[root]; upload != nil {
+		batch = upload.batch
+	} else if err == nil && !complete {
+		err = ErrUnknownRoot
+	}
+	s.mu.RUnlock()
+	if err != nil {
+		return Status{}, err
+	}
+	record, err := s.db.Get(ctx, root)
+	if errors.Is(err, ErrRecordNotFound) && !complete {
+		// the first file of the batch is still being uploaded
+		return Status{Batch: batch, Received: []int{}}, nil
+	}
+	if err != nil {
+		return Status{}, err
+	}
+	return Status{
+		Batch:    record.Batch,
+		Received: record.Received(),
+		Complete: complete,
+	}, nil
+}
+
+// BlockProof proves a single block of a chunked file: Block links the block
+// to FileHash and File links FileHash to the batch root.
+type BlockProof struct {
+	Hash     string
+	FileHash []byte
+	Block    *merkletree.Proof
+	File     *merkletree.Proof
+}
+
+func (proof BlockProof) Verify(root []byte, index, block int, content []byte) error {
+	batch := Batch{Hash: proof.Hash}
+	newHash, err := batch.NewHash()
+	if err != nil {
+		return err
+	}
+	h := newHash()
+	h.Write(content)
+	if proof.Block.Index() != block || proof.File.Index() != index {
+		return fmt.Errorf("proof is for block %d of file %d", proof.Block.Index(), proof.File.Index())
+	}
+	if err := proof.Block.Verify(merkletree.LeafHash(block, h.Sum(nil), batch.Options()...), proof.FileHash); err != nil {
+		return fmt.Errorf("block %d: %w", block, err)
+	}
+	return proof.File.Verify(merkletree.LeafHash(index, proof.FileHash, batch.Options()...), root)
+}
+
+// RequestBlock returns a single block of a file from a chunked batch along
+// with the proofs binding it to the batch root.
+func (s *Server) RequestBlock(ctx context.Context, root string, index, block int) (io.ReadCloser, *BlockProof, error) {
+	batch, err := s.Batch(ctx, root)
+	if err != nil {
+		return nil, nil, err
+	}
+	if batch.BlockSize == 0 {
+		return nil, nil, fmt.Errorf("%w: %s is not chunked", ErrInvalidBatch, root)
+	}
+	fileProof, err := s.Proof(ctx, root, index)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	blocks, err := s.blockTree(batch, root, index)
+	if err != nil {
+		return nil, nil, err
+	}
+	if block < 0 || block >= blocks.LeafCount() {
+		return nil, nil, fmt.Errorf("%w: block %d out of range for %d blocks", ErrInvalidBatch, block, blocks.LeafCount())
+	}
+	blockProof, err := blocks.ProofForIndex(block)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := s.files.Open(s.path(root, index))
+	if err != nil {
+		return nil, nil, err
+	}
+	offset := int64(block) * int64(batch.BlockSize)
+	if seeker, ok := file.(io.Seeker); ok {
+		_, err = seeker.Seek(offset, io.SeekStart)
+	} else {
+		_, err = io.CopyN(io.Discard, file, offset)
+	}
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	content := struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(file, int64(batch.BlockSize)), file}
+
+	return content, &BlockProof{
+		Hash:     batch.Hash,
+		FileHash: blocks.Root(),
+		Block:    blockProof,
+		File:     fileProof,
+	}, nil
+}
+
+// blockTree returns the block tree of the file at index of a chunked batch.
+// Blobs keep it once built from the block hashes saved with them, files
+// without saved block hashes are hashed again.
+func (s *Server) blockTree(batch Batch, root string, index int) (*merkletree.MerkleTree, error) {
+	key, root := s.blob(root, index)
+	if key == "" {
+		return s.hashBlocks(batch, fmt.Sprintf("%s/%d", root, index))
+	}
+	s.blobsMu.Lock()
+	stored := s.blobs[key]
+	var tree *merkletree.MerkleTree
+	if stored != nil {
+		tree = stored.blocks
+	}
+	s.blobsMu.Unlock()
+	if stored == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownBlob, key)
+	}
+	if tree != nil {
+		return tree, nil
+	}
+
+	tree, err := s.readBlocks(batch, key)
+	if err == nil && tree == nil {
+		tree, err = s.hashBlocks(batch, blobPath(key))
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.blobsMu.Lock()
+	stored.blocks = tree
+	s.blobsMu.Unlock()
+	return tree, nil
+}
+
+// readBlocks builds the block tree of the blob of key from its saved block
+// hashes, nil for blobs saved without them or whose block hashes do not
+// have the hash of the blob as root.
+func (s *Server) readBlocks(batch Batch, key string) (*merkletree.MerkleTree, error) {
+	file, err := s.files.Open(blocksPath(key))
+	if err != nil {
+		return nil, nil
+	}
+	defer file.Close()
+	b, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	newHash, err := batch.NewHash()
+	if err != nil {
+		return nil, err
+	}
+	size := newHash().Size()
+	if len(b) == 0 || len(b)%size != 0 {
+		return nil, fmt.Errorf("block hashes of %s: %d bytes", key, len(b))
+	}
+	hashes := make([][]byte, 0, len(b)/size)
+	for ; len(b) > 0; b = b[size:] {
+		hashes = append(hashes, b[:size])
+	}
+	tree, err := merkletree.BlockTreeOf(hashes, batch.Options()...)
+	if err != nil {
+		return nil, err
+	}
+	if computed, hash := hex.EncodeToString(tree.Root()), path.Base(key); computed != hash {
+		logger.Warn("block hashes mismatch",
+			"blob", key,
+			"computed", computed,
+		)
+		return nil, nil
+	}
+	return tree, nil
+}
+
+// hashBlocks builds the block tree of the file at path by reading it.
+func (s *Server) hashBlocks(batch Batch, path string) (*merkletree.MerkleTree, error) {
+	file, err := s.files.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return merkletree.BlockTree(file, batch.BlockSize, batch.Options()...)
+}
@@ -0,0 +1,1223 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tclairet/merklestore/merkletree"
+)
+
+var ctx = context.Background()
+
+// testRoot is a valid sha256 root of no batch.
+var testRoot = strings.Repeat("0", 2*sha256.Size)
+
+type fakeFileHandler struct {
+	saved map[string][]byte
+
+	mu sync.Mutex
+}
+
+func newFakeFileHandler() *fakeFileHandler {
+	return &fakeFileHandler{saved: make(map[string][]byte)}
+}
+
+func (f *fakeFileHandler) Open(name string) (io.ReadCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b, exist := f.saved[name]
+	if !exist {
+		return nil, fmt.Errorf("%s not found", name)
+	}
+	return nopSeekCloser{bytes.NewReader(b)}, nil
+}
+
+type nopSeekCloser struct {
+	*bytes.Reader
+}
+
+func (nopSeekCloser) Close() error { return nil }
+
+func (f *fakeFileHandler) Delete(path string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for name := range f.saved {
+		if name == path || strings.HasPrefix(name, path+"/") {
+			delete(f.saved, name)
+		}
+	}
+	return nil
+}
+
+func (f *fakeFileHandler) Save(name string, content io.Reader) error {
+	b, err := io.ReadAll(content)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.saved[name] = b
+	return nil
+}
+
+func newTestServer(t *testing.T) (*Server, *fakeFileHandler) {
+	handler := newFakeFileHandler()
+	s, err := New(ctx, handler, NewMemStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, handler
+}
+
+func rootOf(t *testing.T, contents []string) string {
+	return batchRoot(t, Batch{Total: len(contents)}, contents)
+}
+
+func batchRoot(t *testing.T, batch Batch, contents []string) string {
+	builder := merkletree.NewIndexedBuilder(len(contents), batch.Options()...)
+	for i, content := range contents {
+		hasher, sum := batch.FileHasher()
+		_, _ = io.WriteString(hasher, content)
+		h, err := sum()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := builder.AddHash(i, h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tree, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hex.EncodeToString(tree.Root())
+}
+
+func TestServerUpload(t *testing.T) {
+	t.Run("valid root", func(t *testing.T) {
+		s, _ := newTestServer(t)
+		contents := []string{"a", "b", "c"}
+		root := rootOf(t, contents)
+		for i, content := range contents {
+			if err := s.Upload(ctx, Batch{Root: root, Total: len(contents)}, i, strings.NewReader(content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i, content := range contents {
+			reader, _, err := s.Request(ctx, root, i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, _ := io.ReadAll(reader)
+			if got, want := string(b), content; got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		}
+		if err := s.Upload(ctx, Batch{Root: root, Total: len(contents)}, 0, strings.NewReader("a")); !errors.Is(err, ErrRootExists) {
+			t.Errorf("got %v, want %v", err, ErrRootExists)
+		}
+	})
+
+	t.Run("root mismatch", func(t *testing.T) {
+		s, handler := newTestServer(t)
+		root := rootOf(t, []string{"a", "b"})
+		if err := s.Upload(ctx, Batch{Root: root, Total: 2}, 0, strings.NewReader("a")); err != nil {
+			t.Fatal(err)
+		}
+		err := s.Upload(ctx, Batch{Root: root, Total: 2}, 1, strings.NewReader("not b"))
+		if !errors.Is(err, ErrRootMismatch) {
+			t.Fatalf("got %v, want %v", err, ErrRootMismatch)
+		}
+		if got, want := len(handler.saved), 0; got != want {
+			t.Errorf("got %v files, want %v", got, want)
+		}
+		if _, err := s.db.Get(ctx, root); !errors.Is(err, ErrRecordNotFound) {
+			t.Errorf("hashes of %s still stored", root)
+		}
+		if _, _, err := s.Request(ctx, root, 0); err == nil {
+			t.Errorf("request on rejected root should fail")
+		}
+
+		// the root can be uploaded again with the right content
+		for i, content := range []string{"a", "b"} {
+			if err := s.Upload(ctx, Batch{Root: root, Total: 2}, i, strings.NewReader(content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestServerAppend(t *testing.T) {
+	s, handler := newTestServer(t)
+	contents := []string{"a", "b", "c", "d", "e", "f"}
+	var batches []Batch
+	for _, total := range []int{3, 5, 6} {
+		batch := Batch{Root: rootOf(t, contents[:total]), Total: total}
+		first := 0
+		if len(batches) > 0 {
+			parent := batches[len(batches)-1]
+			batch.Parent, first = parent.Root, parent.Total
+		}
+		for i := first; i < total; i++ {
+			if err := s.Upload(ctx, batch, i, strings.NewReader(contents[i])); err != nil {
+				t.Fatal(err)
+			}
+		}
+		batches = append(batches, batch)
+	}
+	last := batches[2]
+
+	root, _ := hex.DecodeString(last.Root)
+	for i, content := range contents {
+		reader, proof, err := s.Request(ctx, last.Root, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(reader)
+		if got, want := string(b), content; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if err := proof.Verify(indexedHash(i, content), root); err != nil {
+			t.Error(err)
+		}
+	}
+	if got, want := len(handler.saved), len(contents); got != want {
+		t.Errorf("got %v files, want %v", got, want)
+	}
+
+	for _, old := range batches {
+		proof, err := s.Consistency(ctx, old.Root, last.Root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		oldRoot, _ := hex.DecodeString(old.Root)
+		if err := proof.Verify(oldRoot, root); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := s.Consistency(ctx, last.Root, batches[0].Root); !errors.Is(err, ErrInvalidBatch) {
+		t.Errorf("got %v, want %v", err, ErrInvalidBatch)
+	}
+
+	invalid := []struct {
+		name  string
+		batch Batch
+		index int
+		err   error
+	}{
+		{"inherited index", Batch{Root: rootOf(t, []string{"a", "b", "c", "x"}), Total: 4, Parent: batches[0].Root}, 1, ErrIndexReceived},
+		{"no new file", Batch{Root: testRoot, Total: 3, Parent: batches[0].Root}, 2, ErrInvalidBatch},
+		{"other hash", Batch{Root: strings.Repeat("0", 128), Total: 4, Hash: merkletree.SHA512, Parent: batches[0].Root}, 3, ErrInvalidBatch},
+		{"other odd node", Batch{Root: testRoot, Total: 4, OddNode: merkletree.Duplicate, Parent: batches[0].Root}, 3, ErrInvalidBatch},
+		{"unknown parent", Batch{Root: testRoot, Total: 4, Parent: strings.Repeat("1", 64)}, 3, ErrInvalidBatch},
+		{"invalid parent", Batch{Root: testRoot, Total: 4, Parent: "../" + batches[0].Root}, 3, ErrInvalidBatch},
+		{"with manifest", Batch{Root: testRoot, Total: 4, Parent: batches[0].Root, Manifest: true}, 3, ErrInvalidBatch},
+		{"index out of range", Batch{Root: testRoot, Total: 4, Parent: batches[0].Root}, 4, ErrInvalidBatch},
+	}
+	for _, c := range invalid {
+		if err := s.Upload(ctx, c.batch, c.index, strings.NewReader("x")); !errors.Is(err, c.err) {
+			t.Errorf("%s: got %v, want %v", c.name, err, c.err)
+		}
+	}
+	// invalid uploads inherit nothing
+	if _, err := s.db.Get(ctx, testRoot); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("got %v, want %v", err, ErrRecordNotFound)
+	}
+}
+
+func TestServerOddNode(t *testing.T) {
+	s, _ := newTestServer(t)
+	contents := []string{"abcdefgh", "ij", "k", "l"}
+	var batches []Batch
+	for _, total := range []int{3, 4} {
+		batch := Batch{Total: total, BlockSize: 3, OddNode: merkletree.Duplicate}
+		batch.Root = batchRoot(t, batch, contents[:total])
+		first := 0
+		if len(batches) > 0 {
+			batch.Parent, first = batches[0].Root, batches[0].Total
+		}
+		for i := first; i < total; i++ {
+			if err := s.Upload(ctx, batch, i, strings.NewReader(contents[i])); err != nil {
+				t.Fatal(err)
+			}
+		}
+		batches = append(batches, batch)
+	}
+	if batches[0].Root == rootOf(t, contents[:3]) {
+		t.Fatal("strategies should give different roots")
+	}
+
+	stored, err := s.Batch(ctx, batches[0].Root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stored.OddNode, merkletree.Duplicate; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	root, _ := hex.DecodeString(batches[0].Root)
+	reader, proof, err := s.RequestBlock(ctx, batches[0].Root, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(reader)
+	if err := proof.Verify(root, 0, 2, b); err != nil {
+		t.Error(err)
+	}
+	if got, want := proof.File.OddNode(), merkletree.Duplicate; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if _, err := s.Consistency(ctx, batches[0].Root, batches[1].Root); !errors.Is(err, ErrInvalidBatch) {
+		t.Errorf("got %v, want %v", err, ErrInvalidBatch)
+	}
+	if err := s.Upload(ctx, Batch{Root: testRoot, Total: 1, OddNode: "unknown"}, 0, strings.NewReader("a")); !errors.Is(err, ErrInvalidBatch) {
+		t.Errorf("got %v, want %v", err, ErrInvalidBatch)
+	}
+}
+
+func namedRoot(t *testing.T, names, contents []string) string {
+	entries := make(map[string][]byte)
+	for i, name := range names {
+		h := sha256.Sum256([]byte(contents[i]))
+		entries[name] = h[:]
+	}
+	tree, err := merkletree.NewSparse(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hex.EncodeToString(tree.Root())
+}
+
+func TestServerNamed(t *testing.T) {
+	handler := newFakeFileHandler()
+	db := NewMemStore()
+	s, err := New(ctx, handler, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"a.txt", "dir/b.txt", "c"}
+	contents := []string{"a", "b", "c"}
+	batch := Batch{Root: namedRoot(t, names, contents), Total: len(names)}
+	for i, content := range contents {
+		if err := s.UploadNamed(ctx, batch, i, names[i], strings.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			if err := s.UploadNamed(ctx, batch, 1, names[0], strings.NewReader(content)); !errors.Is(err, ErrInvalidBatch) {
+				t.Errorf("got %v, want %v", err, ErrInvalidBatch)
+			}
+			if err := s.Upload(ctx, batch, 1, strings.NewReader(content)); !errors.Is(err, ErrInvalidBatch) {
+				t.Errorf("got %v, want %v", err, ErrInvalidBatch)
+			}
+		}
+	}
+
+	// the names are kept in the records
+	s, err = New(ctx, handler, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, _ := hex.DecodeString(batch.Root)
+	for i, name := range names {
+		reader, proof, err := s.RequestName(ctx, batch.Root, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(reader)
+		if got, want := string(b), contents[i]; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		h := sha256.Sum256(b)
+		if err := proof.Verify(name, h[:], root); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	if _, _, err := s.RequestName(ctx, batch.Root, "missing"); !errors.Is(err, ErrUnknownName) {
+		t.Errorf("got %v, want %v", err, ErrUnknownName)
+	}
+	proof, hash, err := s.NameProof(ctx, batch.Root, "missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != nil {
+		t.Errorf("got %x, want nil", hash)
+	}
+	if err := proof.VerifyAbsence("missing", root); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := s.Proof(ctx, batch.Root, 0); !errors.Is(err, ErrInvalidBatch) {
+		t.Errorf("got %v, want %v", err, ErrInvalidBatch)
+	}
+	indexed := rootOf(t, contents[:1])
+	if err := s.Upload(ctx, Batch{Root: indexed, Total: 1}, 0, strings.NewReader(contents[0])); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.NameProof(ctx, indexed, "a.txt"); !errors.Is(err, ErrInvalidBatch) {
+		t.Errorf("got %v, want %v", err, ErrInvalidBatch)
+	}
+	appended := Batch{Root: rootOf(t, append(contents, "d")), Total: 4, Parent: batch.Root}
+	if err := s.Upload(ctx, appended, 3, strings.NewReader("d")); !errors.Is(err, ErrInvalidBatch) {
+		t.Errorf("got %v, want %v", err, ErrInvalidBatch)
+	}
+}
+
+func TestServerManifest(t *testing.T) {
+	s, _ := newTestServer(t)
+	contents := []string{"a", `{"files":[{"path":"a"}]}`}
+	batch := Batch{Root: rootOf(t, contents), Total: len(contents), Manifest: true}
+	for i, content := range contents {
+		if err := s.Upload(ctx, batch, i, strings.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stored, err := s.Batch(ctx, batch.Root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stored.Manifest {
+		t.Error("batch should have a manifest")
+	}
+
+	appended := Batch{Root: rootOf(t, append(contents, "b")), Total: 3, Parent: batch.Root}
+	if err := s.Upload(ctx, appended, 2, strings.NewReader("b")); !errors.Is(err, ErrInvalidBatch) {
+		t.Errorf("got %v, want %v", err, ErrInvalidBatch)
+	}
+	if err := s.UploadNamed(ctx, Batch{Root: testRoot, Total: 1, Manifest: true}, 0, "a", strings.NewReader("a")); !errors.Is(err, ErrInvalidBatch) {
+		t.Errorf("got %v, want %v", err, ErrInvalidBatch)
+	}
+}
+
+func metadataRoot(t *testing.T, infos []FileInfo, contents []string) string {
+	batch := Batch{Metadata: true}
+	builder := merkletree.NewIndexedBuilder(len(contents))
+	for i, content := range contents {
+		h := sha256.Sum256([]byte(content))
+		commit, err := infos[i].Commit(batch, h[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := builder.AddHash(i, commit); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tree, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hex.EncodeToString(tree.Root())
+}
+
+func TestServerMetadata(t *testing.T) {
+	handler := newFakeFileHandler()
+	db := NewMemStore()
+	s, err := New(ctx, handler, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := []string{"hello", "{}"}
+	infos := []FileInfo{
+		{Name: "a.txt", Size: 5, ContentType: "text/plain; charset=utf-8", ModTime: 1700000000},
+		{Name: "b.json", Size: 2, ContentType: "application/json"},
+	}
+	batch := Batch{Root: metadataRoot(t, infos, contents), Total: len(contents)}
+	if batch.Root == rootOf(t, contents) {
+		t.Fatal("metadata should change the root")
+	}
+	invalid := []struct {
+		name string
+		info FileInfo
+	}{
+		{"other size", FileInfo{Name: "a.txt", Size: 4}},
+		{"invalid content type", FileInfo{Name: "a.txt", Size: 5, ContentType: "text/"}},
+	}
+	for _, c := range invalid {
+		if err := s.UploadWithInfo(ctx, batch, 0, c.info, strings.NewReader(contents[0])); !errors.Is(err, ErrInvalidBatch) {
+			t.Errorf("%s: got %v, want %v", c.name, err, ErrInvalidBatch)
+		}
+	}
+	if _, exist := handler.saved[stagingPath(batch.Root, 0)]; exist {
+		t.Error("a file of another size should not stay staged")
+	}
+	if got, want := len(handler.saved), 0; got != want {
+		t.Errorf("got %v files, want %v", got, want)
+	}
+	for i, content := range contents {
+		if err := s.UploadWithInfo(ctx, batch, i, infos[i], strings.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the metadata is kept in the records
+	s, err = New(ctx, handler, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := s.Infos(ctx, batch.Root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stored, infos; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	root, _ := hex.DecodeString(batch.Root)
+	for i, content := range contents {
+		info, err := s.Info(ctx, batch.Root, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, proof, err := s.Request(ctx, batch.Root, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := sha256.Sum256([]byte(content))
+		commit, _ := info.Commit(batch, h[:])
+		if err := proof.Verify(merkletree.LeafHash(i, commit), root); err != nil {
+			t.Error(err)
+		}
+		if err := proof.Verify(indexedHash(i, content), root); err == nil {
+			t.Error("proof should not verify without the metadata")
+		}
+	}
+
+	plain := rootOf(t, contents[:1])
+	if err := s.Upload(ctx, Batch{Root: plain, Total: 1}, 0, strings.NewReader(contents[0])); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Infos(ctx, plain); !errors.Is(err, ErrInvalidBatch) {
+		t.Errorf("got %v, want %v", err, ErrInvalidBatch)
+	}
+	if info, err := s.Info(ctx, plain, 0); err != nil || info != (FileInfo{}) {
+		t.Errorf("got %v %v, want no metadata", info, err)
+	}
+}
+
+func TestServerBlobs(t *testing.T) {
+	handler := newFakeFileHandler()
+	db := NewMemStore()
+	s, err := New(ctx, handler, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload := func(batch Batch, from int, contents []string) {
+		t.Helper()
+		for i, content := range contents[from:] {
+			if err := s.Upload(ctx, batch, from+i, strings.NewReader(content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	first := Batch{Root: rootOf(t, []string{"a", "b", "c"}), Total: 3}
+	upload(first, 0, []string{"a", "b", "c"})
+	second := Batch{Root: rootOf(t, []string{"b", "c", "b"}), Total: 3}
+	upload(second, 0, []string{"b", "c", "b"})
+	extended := Batch{Root: rootOf(t, []string{"b", "c", "b", "d"}), Total: 4, Parent: second.Root}
+	upload(extended, 3, []string{"b", "c", "b", "d"})
+
+	if got, want := s.Stats(ctx), (Stats{Blobs: 4, Files: 10, Bytes: 4, FileBytes: 10}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got, want := len(handler.saved), 4; got != want {
+		t.Errorf("got %v files, want %v", got, want)
+	}
+
+	if err := s.Delete(ctx, "unknown"); !errors.Is(err, ErrUnknownRoot) {
+		t.Errorf("got %v, want %v", err, ErrUnknownRoot)
+	}
+	if err := s.Delete(ctx, second.Root); !errors.Is(err, ErrInvalidBatch) {
+		t.Errorf("extended batch: got %v, want %v", err, ErrInvalidBatch)
+	}
+	if err := s.Delete(ctx, first.Root); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Batch(ctx, first.Root); !errors.Is(err, ErrUnknownRoot) {
+		t.Errorf("got %v, want %v", err, ErrUnknownRoot)
+	}
+	if got, want := s.Stats(ctx), (Stats{Blobs: 3, Files: 7, Bytes: 3, FileBytes: 7}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	for i, content := range []string{"b", "c", "b", "d"} {
+		reader, _, err := s.Request(ctx, extended.Root, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(reader)
+		if got, want := string(b), content; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+
+	// the references are counted again from the records on restart
+	s, err = New(ctx, handler, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, extended.Root); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, second.Root); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := s.Stats(ctx), (Stats{}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got, want := len(handler.saved), 0; got != want {
+		t.Errorf("got %v files, want %v", got, want)
+	}
+
+	// files stored before blobs are still served from ROOT/INDEX
+	contents := []string{"a", "b"}
+	legacy := Batch{Root: rootOf(t, contents), Total: len(contents)}
+	for i, content := range contents {
+		h := sha256.Sum256([]byte(content))
+		if err := db.Save(ctx, legacy, i, FileInfo{}, h[:], ""); err != nil {
+			t.Fatal(err)
+		}
+		handler.saved[fmt.Sprintf("%s/%d", legacy.Root, i)] = []byte(content)
+	}
+	s, err = New(ctx, handler, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, _, err := s.Request(ctx, legacy.Root, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := io.ReadAll(reader); string(b) != "b" {
+		t.Errorf("got %s, want b", b)
+	}
+}
+
+func TestServerInvalidRoot(t *testing.T) {
+	s, handler := newTestServer(t)
+	batch := Batch{Root: rootOf(t, []string{"a"}), Total: 1}
+	if err := s.Upload(ctx, batch, 0, strings.NewReader("a")); err != nil {
+		t.Fatal(err)
+	}
+	saved := len(handler.saved)
+
+	for _, root := range []string{"", "blobs", "../" + batch.Root, strings.ToUpper(batch.Root), batch.Root[2:], batch.Root + "00"} {
+		if err := s.Upload(ctx, Batch{Root: root, Total: 1}, 0, strings.NewReader("b")); !errors.Is(err, ErrInvalidBatch) {
+			t.Errorf("%q: got %v, want %v", root, err, ErrInvalidBatch)
+		}
+	}
+	if got, want := len(handler.saved), saved; got != want {
+		t.Errorf("got %v files, want %v", got, want)
+	}
+	reader, _, err := s.Request(ctx, batch.Root, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(reader)
+	if got, want := string(b), "a"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestServerBlobOwnership(t *testing.T) {
+	s, handler := newTestServer(t)
+	batch := Batch{Root: rootOf(t, []string{"a"}), Total: 1}
+	if err := s.Upload(ctx, batch, 0, strings.NewReader("a")); err != nil {
+		t.Fatal(err)
+	}
+	hash := sha256.Sum256([]byte("a"))
+	blob := blobPath(batch.blobKey(hash[:]))
+	if _, ok := handler.saved[blob]; !ok {
+		t.Fatalf("missing blob %s", blob)
+	}
+
+	// a batch sharing the blob whose root does not match is discarded
+	if err := s.Upload(ctx, Batch{Root: testRoot, Total: 1}, 0, strings.NewReader("a")); !errors.Is(err, ErrRootMismatch) {
+		t.Errorf("got %v, want %v", err, ErrRootMismatch)
+	}
+	// nor can a root name the blobs
+	if err := s.Upload(ctx, Batch{Root: "blobs", Total: 1}, 0, strings.NewReader("b")); !errors.Is(err, ErrInvalidBatch) {
+		t.Errorf("got %v, want %v", err, ErrInvalidBatch)
+	}
+	if got, want := string(handler.saved[blob]), "a"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for path := range handler.saved {
+		if !strings.HasPrefix(path, "blobs/") {
+			t.Errorf("%s is not a blob", path)
+		}
+	}
+	if got, want := s.Stats(ctx), (Stats{Blobs: 1, Files: 1, Bytes: 1, FileBytes: 1}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestServerStored(t *testing.T) {
+	s, handler := newTestServer(t)
+	contents := []string{"a", "bb"}
+	first := Batch{Root: rootOf(t, contents), Total: len(contents)}
+	for i, content := range contents {
+		if err := s.Upload(ctx, first, i, strings.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hashes := make([][]byte, 3)
+	for i, content := range []string{"a", "bb", "c"} {
+		h := sha256.Sum256([]byte(content))
+		hashes[i] = h[:]
+	}
+	have, err := s.Have(ctx, Batch{}, hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := have, []bool{true, true, false}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// the files of batches hashed another way are other blobs
+	for _, batch := range []Batch{{BlockSize: 1}, {Hash: merkletree.SHA256d}} {
+		have, err := s.Have(ctx, batch, hashes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := have, []bool{false, false, false}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+	if _, err := s.Have(ctx, Batch{Hash: "md5"}, hashes); !errors.Is(err, ErrInvalidBatch) {
+		t.Errorf("got %v, want %v", err, ErrInvalidBatch)
+	}
+
+	named := Batch{Root: namedRoot(t, []string{"x", "y"}, []string{"bb", "c"}), Total: 2, Named: true}
+	if err := s.UploadStored(ctx, named, 1, FileInfo{Name: "y"}, hashes[2]); !errors.Is(err, ErrUnknownBlob) {
+		t.Errorf("got %v, want %v", err, ErrUnknownBlob)
+	}
+	if err := s.UploadNamed(ctx, named, 1, "y", strings.NewReader("c")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UploadStored(ctx, named, 0, FileInfo{Name: "x"}, hashes[1]); err != nil {
+		t.Fatal(err)
+	}
+	reader, _, err := s.RequestName(ctx, named.Root, "x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := io.ReadAll(reader); string(b) != "bb" {
+		t.Errorf("got %s, want bb", b)
+	}
+
+	infos := []FileInfo{{Name: "a.txt", Size: 1}}
+	metadata := Batch{Root: metadataRoot(t, infos, contents[:1]), Total: 1, Metadata: true}
+	if err := s.UploadStored(ctx, metadata, 0, FileInfo{Name: "a.txt", Size: 2}, hashes[0]); !errors.Is(err, ErrInvalidBatch) {
+		t.Errorf("other size: got %v, want %v", err, ErrInvalidBatch)
+	}
+	if err := s.UploadStored(ctx, metadata, 0, infos[0], hashes[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Proof(ctx, metadata.Root, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := s.Stats(ctx), (Stats{Blobs: 3, Files: 5, Bytes: 4, FileBytes: 7}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got, want := len(handler.saved), 3; got != want {
+		t.Errorf("got %v files, want %v", got, want)
+	}
+}
+
+func TestServerBlocks(t *testing.T) {
+	s, handler := newTestServer(t)
+	contents := []string{"abcdefgh", "ij"}
+	batch := Batch{Total: len(contents), BlockSize: 3}
+	batch.Root = batchRoot(t, batch, contents)
+	for i, content := range contents {
+		if err := s.Upload(ctx, batch, i, strings.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root, _ := hex.DecodeString(batch.Root)
+
+	// the block hashes are saved with the blobs, blobs saved without them
+	// or with block hashes of another content are hashed again
+	hasher, sum := batch.FileHasher()
+	_, _ = io.WriteString(hasher, contents[0])
+	h, _ := sum()
+	blocks := handler.saved[blocksPath(batch.blobKey(h))]
+	if got, want := len(blocks), 3*sha256.Size; got != want {
+		t.Errorf("got %v bytes of block hashes, want %v", got, want)
+	}
+	swapped := [][]byte{blocks[sha256.Size : 2*sha256.Size], blocks[:sha256.Size], blocks[2*sha256.Size:]}
+	handler.saved[blocksPath(batch.blobKey(h))] = bytes.Join(swapped, nil)
+	hasher, sum = batch.FileHasher()
+	_, _ = io.WriteString(hasher, contents[1])
+	h, _ = sum()
+	delete(handler.saved, blocksPath(batch.blobKey(h)))
+
+	cases := []struct {
+		index    int
+		block    int
+		expected string
+	}{
+		{0, 0, "abc"},
+		{0, 1, "def"},
+		{0, 2, "gh"},
+		{1, 0, "ij"},
+	}
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("%d/%d", c.index, c.block), func(t *testing.T) {
+			reader, proof, err := s.RequestBlock(ctx, batch.Root, c.index, c.block)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, _ := io.ReadAll(reader)
+			if got, want := string(b), c.expected; got != want {
+				t.Fatalf("got %v, want %v", got, want)
+			}
+			if err := proof.Verify(root, c.index, c.block, b); err != nil {
+				t.Error(err)
+			}
+			if err := proof.Verify(root, c.index, c.block, []byte("xyz")); err == nil {
+				t.Error("proof should not verify another block content")
+			}
+		})
+	}
+
+	if _, _, err := s.RequestBlock(ctx, batch.Root, 0, 3); !errors.Is(err, ErrInvalidBatch) {
+		t.Errorf("got %v, want %v", err, ErrInvalidBatch)
+	}
+	if err := s.Upload(ctx, Batch{Root: testRoot, Total: 2}, 0, strings.NewReader("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Upload(ctx, Batch{Root: testRoot, Total: 2, BlockSize: 1}, 1, strings.NewReader("b")); !errors.Is(err, ErrInvalidBatch) {
+		t.Errorf("got %v, want %v", err, ErrInvalidBatch)
+	}
+}
+
+func TestServerHashes(t *testing.T) {
+	contents := []string{"abcdefgh", "ij", "k"}
+	roots := make(map[string]string)
+	for _, name := range merkletree.HashNames() {
+		t.Run(name, func(t *testing.T) {
+			s, _ := newTestServer(t)
+			batch := Batch{Total: len(contents), BlockSize: 3, Hash: name, OddNode: merkletree.Promote}
+			batch.Root = batchRoot(t, batch, contents)
+			if other, exist := roots[batch.Root]; exist {
+				t.Fatalf("%s and %s give the same root", name, other)
+			}
+			roots[batch.Root] = name
+			for i, content := range contents {
+				if err := s.Upload(ctx, batch, i, strings.NewReader(content)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			stored, err := s.Batch(ctx, batch.Root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := stored, batch; got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+
+			root, _ := hex.DecodeString(batch.Root)
+			reader, proof, err := s.RequestBlock(ctx, batch.Root, 0, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, _ := io.ReadAll(reader)
+			if got, want := proof.Hash, name; got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+			if err := proof.Verify(root, 0, 1, b); err != nil {
+				t.Error(err)
+			}
+			leaf, err := s.Leaf(ctx, batch.Root, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := proof.File.Verify(leaf, root); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+
+	s, _ := newTestServer(t)
+	if err := s.Upload(ctx, Batch{Root: testRoot, Total: 1, Hash: "md5"}, 0, strings.NewReader("a")); !errors.Is(err, ErrInvalidBatch) {
+		t.Errorf("got %v, want %v", err, ErrInvalidBatch)
+	}
+}
+
+func TestServerExpire(t *testing.T) {
+	s, handler := newTestServer(t)
+	contents := []string{"a", "b"}
+	batch := Batch{Root: rootOf(t, contents), Total: len(contents)}
+	stored := Batch{Root: rootOf(t, []string{"c"}), Total: 1}
+	if err := s.Upload(ctx, stored, 0, strings.NewReader("c")); err != nil {
+		t.Fatal(err)
+	}
+	// the root of batch is held by a batch of another size never finished
+	squatter := Batch{Root: batch.Root, Total: 3}
+	if err := s.Upload(ctx, squatter, 0, strings.NewReader("x")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Upload(ctx, batch, 0, strings.NewReader("a")); !errors.Is(err, ErrInvalidBatch) {
+		t.Errorf("got %v, want %v", err, ErrInvalidBatch)
+	}
+
+	expired, err := s.Expire(ctx, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 0 {
+		t.Errorf("got %v, want no expired batch", expired)
+	}
+	expired, err = s.Expire(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := expired, []string{batch.Root}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := s.Status(ctx, batch.Root); !errors.Is(err, ErrUnknownRoot) {
+		t.Errorf("got %v, want %v", err, ErrUnknownRoot)
+	}
+	if got, want := len(handler.saved), 1; got != want {
+		t.Errorf("got %v files, want %v", got, want)
+	}
+
+	for i, content := range contents {
+		if err := s.Upload(ctx, batch, i, strings.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, root := range []string{batch.Root, stored.Root} {
+		if status, err := s.Status(ctx, root); err != nil || !status.Complete {
+			t.Errorf("%s: got %+v %v, want a complete batch", root, status, err)
+		}
+	}
+}
+
+func TestServerStatus(t *testing.T) {
+	s, _ := newTestServer(t)
+	contents := []string{"a", "b", "c"}
+	batch := Batch{Root: rootOf(t, contents), Total: len(contents), Hash: merkletree.SHA256, OddNode: merkletree.Promote}
+
+	if _, err := s.Status(ctx, batch.Root); !errors.Is(err, ErrUnknownRoot) {
+		t.Fatalf("got %v, want %v", err, ErrUnknownRoot)
+	}
+	for _, i := range []int{2, 0} {
+		if err := s.Upload(ctx, batch, i, strings.NewReader(contents[i])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	status, err := s.Status(ctx, batch.Root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := status, (Status{Batch: batch, Received: []int{0, 2}}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	if err := s.Upload(ctx, batch, 1, strings.NewReader(contents[1])); err != nil {
+		t.Fatal(err)
+	}
+	status, err = s.Status(ctx, batch.Root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := status, (Status{Batch: batch, Received: []int{0, 1, 2}, Complete: true}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestServerRestart(t *testing.T) {
+	handler := newFakeFileHandler()
+	restart := func() *Server {
+		db, err := NewJsonStore(handler)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := New(ctx, handler, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	contents := []string{"a", "b", "c"}
+	batch := Batch{Root: rootOf(t, contents), Total: len(contents)}
+	s := restart()
+	if err := s.Upload(ctx, batch, 1, strings.NewReader(contents[1])); err != nil {
+		t.Fatal(err)
+	}
+
+	s = restart()
+	status, err := s.Status(ctx, batch.Root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := status.Received, []int{1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if err := s.Upload(ctx, batch, 1, strings.NewReader(contents[1])); err == nil {
+		t.Fatal("index 1 should already be received")
+	}
+	for _, i := range []int{0, 2} {
+		if err := s.Upload(ctx, batch, i, strings.NewReader(contents[i])); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s = restart()
+	root, _ := hex.DecodeString(batch.Root)
+	for i, content := range contents {
+		_, proof, err := s.Request(ctx, batch.Root, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := proof.Verify(indexedHash(i, content), root); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestServerLoadRecords(t *testing.T) {
+	contents := []string{"a", "b", "c"}
+	root := rootOf(t, contents)
+	var hashes [][]byte
+	for _, content := range contents {
+		h := sha256.Sum256([]byte(content))
+		hashes = append(hashes, h[:])
+	}
+
+	load := func(t *testing.T, backup any) *Server {
+		handler := newFakeFileHandler()
+		b, err := json.Marshal(backup)
+		if err != nil {
+			t.Fatal(err)
+		}
+		handler.saved["backup.json"] = b
+		db, err := NewJsonStore(handler)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := New(ctx, handler, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	t.Run("legacy backup", func(t *testing.T) {
+		s := load(t, map[string]any{"names": map[string][][]byte{root: hashes}})
+		if _, err := s.Proof(ctx, root, 1); err != nil {
+			t.Fatal(err)
+		}
+		record, err := s.db.Get(ctx, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := record.Version, recordVersion; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("record", func(t *testing.T) {
+		record := NewRecord(Batch{Root: root, Total: len(hashes)})
+		record.Hashes = hashes
+		s := load(t, map[string]any{"records": map[string]*Record{root: record}})
+		rootHash, _ := hex.DecodeString(root)
+		proof, err := s.Proof(ctx, root, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := proof.Verify(indexedHash(2, "c"), rootHash); err != nil {
+			t.Error(err)
+		}
+	})
+
+	cases := []struct {
+		name   string
+		change func(record *Record)
+	}{
+		{"tampered hash", func(record *Record) { record.Hashes[1] = hashes[0] }},
+		{"unknown version", func(record *Record) { record.Version = recordVersion + 1 }},
+		{"unknown hash", func(record *Record) { record.Hash = "md5" }},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			record := NewRecord(Batch{Root: root, Total: len(hashes)})
+			record.Hashes = slices.Clone(hashes)
+			c.change(record)
+			s := load(t, map[string]any{"records": map[string]*Record{root: record}})
+			if _, err := s.Proof(ctx, root, 0); err == nil || errors.Is(err, ErrUnknownRoot) {
+				t.Fatalf("corrupted tree should not be served, got %v", err)
+			}
+			if err := s.Upload(ctx, Batch{Root: root, Total: len(hashes)}, 0, strings.NewReader("a")); err == nil {
+				t.Fatal("corrupted tree should not accept uploads")
+			}
+		})
+	}
+}
+
+func TestServerConcurrency(t *testing.T) {
+	t.Run("parallel batches", func(t *testing.T) {
+		s, _ := newTestServer(t)
+		var batches []Batch
+		var contents [][]string
+		for b := 0; b < 8; b++ {
+			var content []string
+			for i := 0; i < 16; i++ {
+				content = append(content, fmt.Sprintf("batch %d file %d", b, i))
+			}
+			contents = append(contents, content)
+			batches = append(batches, Batch{Root: rootOf(t, content), Total: len(content)})
+		}
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 8*16*2)
+		for b, batch := range batches {
+			for i, content := range contents[b] {
+				wg.Add(2)
+				go func(batch Batch, i int, content string) {
+					defer wg.Done()
+					errs <- s.Upload(ctx, batch, i, strings.NewReader(content))
+				}(batch, i, content)
+				go func(root string, i int) {
+					defer wg.Done()
+					// requests race with uploads, only unfinished tree errors are expected
+					if _, _, err := s.Request(ctx, root, i); err != nil && !errors.Is(err, ErrUnknownRoot) {
+						errs <- err
+					}
+					if _, err := s.Status(ctx, root); err != nil && !errors.Is(err, ErrUnknownRoot) {
+						errs <- err
+					}
+				}(batch.Root, i)
+			}
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for b, batch := range batches {
+			root, _ := hex.DecodeString(batch.Root)
+			for i, content := range contents[b] {
+				_, proof, err := s.Request(ctx, batch.Root, i)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := proof.Verify(indexedHash(i, content), root); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	})
+
+	t.Run("same index", func(t *testing.T) {
+		s, handler := newTestServer(t)
+		contents := []string{"a", "b"}
+		batch := Batch{Root: rootOf(t, contents), Total: len(contents)}
+
+		const uploads = 16
+		var wg sync.WaitGroup
+		errs := make(chan error, uploads)
+		for u := 0; u < uploads; u++ {
+			wg.Add(1)
+			go func(u int) {
+				defer wg.Done()
+				// every upload but one is rejected, whichever content wins is
+				// the one stored and hashed
+				errs <- s.Upload(ctx, batch, 0, strings.NewReader(fmt.Sprintf("candidate %d", u)))
+			}(u)
+		}
+		wg.Wait()
+		close(errs)
+
+		var succeeded int
+		for err := range errs {
+			switch {
+			case err == nil:
+				succeeded++
+			case !errors.Is(err, ErrIndexReceived):
+				t.Fatal(err)
+			}
+		}
+		if got, want := succeeded, 1; got != want {
+			t.Fatalf("got %v successful uploads, want %v", got, want)
+		}
+		stored, err := s.db.Hash(ctx, batch.Root, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := sha256.Sum256(handler.saved["blobs/"+batch.blobKey(stored)])
+		if got, want := stored, h[:]; !bytes.Equal(got, want) {
+			t.Fatalf("stored hash %x does not match stored file %x", got, want)
+		}
+	})
+
+	t.Run("delete parent", func(t *testing.T) {
+		// the parent is deleted while the child records its files, before
+		// the child is pending
+		handler := newFakeFileHandler()
+		db := &hookStore{MetadataStore: NewMemStore()}
+		s, err := New(ctx, handler, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents := []string{"a", "b", "c"}
+		parent := Batch{Root: rootOf(t, contents[:2]), Total: 2}
+		for i, content := range contents[:2] {
+			if err := s.Upload(ctx, parent, i, strings.NewReader(content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		child := Batch{Root: rootOf(t, contents), Total: 3, Parent: parent.Root}
+		var deleteErr error
+		db.onSave = func(batch Batch) {
+			if batch.Root == child.Root && deleteErr == nil {
+				deleteErr = s.Delete(ctx, parent.Root)
+				db.onSave = nil
+			}
+		}
+
+		if err := s.Upload(ctx, child, 2, strings.NewReader("c")); !errors.Is(err, ErrInvalidBatch) {
+			t.Errorf("got %v, want %v", err, ErrInvalidBatch)
+		}
+		if deleteErr != nil {
+			t.Fatal(deleteErr)
+		}
+		if _, err := db.Get(ctx, child.Root); !errors.Is(err, ErrRecordNotFound) {
+			t.Errorf("got %v, want %v", err, ErrRecordNotFound)
+		}
+		if got, want := s.Stats(ctx), (Stats{}); got != want {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+		if got, want := len(handler.saved), 0; got != want {
+			t.Errorf("got %v files, want %v", got, want)
+		}
+	})
+}
+
+// hookStore calls onSave before saving a file.
+type hookStore struct {
+	MetadataStore
+	onSave func(Batch)
+}
+
+func (db *hookStore) Save(ctx context.Context, batch Batch, index int, info FileInfo, hash []byte, blob string) error {
+	if db.onSave != nil {
+		db.onSave(batch)
+	}
+	return db.MetadataStore.Save(ctx, batch, index, info, hash, blob)
+}
@@ -2,76 +2,208 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
+	"sort"
 	"sync"
 
 	"github.com/tclairet/merklestore/files"
 )
 
-type store interface {
-	save(root string, hash []byte, index, total int) error
-	get(root string, index int) ([]byte, error)
-	read() map[string][][]byte
+var ErrRecordNotFound = errors.New("record not found")
+
+// MetadataStore persists the record of every batch known by the server.
+// Implementations must be safe for concurrent use, storetest.Run checks that
+// an implementation behaves as the server expects.
+type MetadataStore interface {
+	// Save stores the hash of the file at index, with its name for a named
+	// batch, its metadata for a Metadata batch and the key of its blob unless
+	// blob is empty. The record of the batch is created with NewRecord on its
+	// first save and is not modified afterward.
+	Save(ctx context.Context, batch Batch, index int, info FileInfo, hash []byte, blob string) error
+	// Hash returns the hash saved at index, nil when it was not received yet.
+	Hash(ctx context.Context, root string, index int) ([]byte, error)
+	// Get returns the record of root or ErrRecordNotFound.
+	Get(ctx context.Context, root string) (Record, error)
+	// List returns every record sorted by root.
+	List(ctx context.Context) ([]Record, error)
+	// Pending returns the records still missing some files sorted by root.
+	Pending(ctx context.Context) ([]Record, error)
+	// Delete removes the record of root, deleting an unknown root is a no-op.
+	Delete(ctx context.Context, root string) error
 }
 
-type memStore struct {
-	Hashes map[string][][]byte `json:"names,omitempty"`
+// MemStore is a MetadataStore keeping records in memory.
+type MemStore struct {
+	records map[string]*Record
 
 	mu sync.Mutex
 }
 
-func (mem *memStore) save(root string, hash []byte, index, total int) error {
+func NewMemStore() *MemStore {
+	return &MemStore{
+		records: make(map[string]*Record),
+	}
+}
+
+func (mem *MemStore) Save(_ context.Context, batch Batch, index int, info FileInfo, hash []byte, blob string) error {
 	mem.mu.Lock()
 	defer mem.mu.Unlock()
-	if len(mem.Hashes[root]) == 0 {
-		mem.Hashes[root] = make([][]byte, total)
+	if mem.records[batch.Root] == nil {
+		mem.records[batch.Root] = NewRecord(batch)
+	}
+	record := mem.records[batch.Root]
+	if index < 0 || index >= len(record.Hashes) {
+		return fmt.Errorf("invalid index %d", index)
+	}
+	record.Hashes[index] = bytes.Clone(hash)
+	if record.Names != nil {
+		record.Names[index] = info.Name
+	}
+	if record.Infos != nil {
+		record.Infos[index] = info
+	}
+	if blob != "" && record.Blobs == nil {
+		record.Blobs = make([]string, record.Total)
+	}
+	if record.Blobs != nil {
+		record.Blobs[index] = blob
 	}
-	mem.Hashes[root][index] = hash
 	return nil
 }
 
-func (mem *memStore) get(root string, index int) ([]byte, error) {
+func (mem *MemStore) Hash(_ context.Context, root string, index int) ([]byte, error) {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	record := mem.records[root]
+	if record == nil {
+		return nil, fmt.Errorf("%w: %s", ErrRecordNotFound, root)
+	}
+	if index < 0 || index >= len(record.Hashes) {
+		return nil, fmt.Errorf("invalid index %d", index)
+	}
+	return bytes.Clone(record.Hashes[index]), nil
+}
+
+func (mem *MemStore) Get(_ context.Context, root string) (Record, error) {
 	mem.mu.Lock()
 	defer mem.mu.Unlock()
-	if len(mem.Hashes[root]) < index {
-		return nil, fmt.Errorf("invalid index")
+	record := mem.records[root]
+	if record == nil {
+		return Record{}, fmt.Errorf("%w: %s", ErrRecordNotFound, root)
 	}
-	return mem.Hashes[root][index], nil
+	return record.clone(), nil
 }
 
-func (mem *memStore) read() map[string][][]byte {
-	return mem.Hashes
+func (mem *MemStore) List(_ context.Context) ([]Record, error) {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	records := make([]Record, 0, len(mem.records))
+	for _, record := range mem.records {
+		records = append(records, record.clone())
+	}
+	sortRecords(records)
+	return records, nil
+}
+
+func (mem *MemStore) Pending(ctx context.Context) ([]Record, error) {
+	records, err := mem.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(records, Record.Complete), nil
 }
 
+func (mem *MemStore) Delete(_ context.Context, root string) error {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	delete(mem.records, root)
+	return nil
+}
+
+func sortRecords(records []Record) {
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Root < records[j].Root
+	})
+}
+
+// JsonStore is a MemStore backed up to backup.json after every change.
 type JsonStore struct {
-	*memStore
+	*MemStore
 	files files.Handler
+
+	// backupMu orders the backups, so that an older one never replaces a
+	// newer one
+	backupMu sync.Mutex
+}
+
+// jsonBackup is the content of backup.json. Hashes and Batches are only
+// filled by backups written before records were versioned, they hold the raw
+// file hashes of batches built with indexed sha256 leaves.
+type jsonBackup struct {
+	Records map[string]*Record  `json:"records,omitempty"`
+	Hashes  map[string][][]byte `json:"names,omitempty"`
+	Batches map[string]Batch    `json:"batches,omitempty"`
 }
 
 func NewJsonStore(files files.Handler) (*JsonStore, error) {
-	store := memStore{
-		Hashes: make(map[string][][]byte),
-	}
+	store := NewMemStore()
 	reader, err := files.Open("backup.json")
 	if err == nil { // backup exist
-		if err := json.NewDecoder(reader).Decode(&store); err != nil {
+		defer reader.Close()
+		var backup jsonBackup
+		if err := json.NewDecoder(reader).Decode(&backup); err != nil {
 			return nil, err
 		}
+		for root, hashes := range backup.Hashes {
+			batch, exist := backup.Batches[root]
+			if !exist {
+				batch = Batch{Root: root, Total: len(hashes)}
+			}
+			record := NewRecord(batch)
+			record.Hashes = hashes
+			store.records[root] = record
+		}
+		for root, record := range backup.Records {
+			store.records[root] = record
+		}
 	}
 	return &JsonStore{
-		memStore: &store,
+		MemStore: store,
 		files:    files,
 	}, nil
 }
 
-func (store *JsonStore) save(root string, hash []byte, index, total int) error {
-	if err := store.memStore.save(root, hash, index, total); err != nil {
+func (store *JsonStore) Save(ctx context.Context, batch Batch, index int, info FileInfo, hash []byte, blob string) error {
+	if err := store.MemStore.Save(ctx, batch, index, info, hash, blob); err != nil {
+		return err
+	}
+	return store.backup()
+}
+
+func (store *JsonStore) Delete(ctx context.Context, root string) error {
+	if err := store.MemStore.Delete(ctx, root); err != nil {
 		return err
 	}
-	b, err := json.Marshal(store.Hashes)
+	return store.backup()
+}
+
+// backup writes the records to backup.json through a temporary file, so
+// that an interrupted backup leaves the previous one intact.
+func (store *JsonStore) backup() error {
+	store.backupMu.Lock()
+	defer store.backupMu.Unlock()
+	store.mu.Lock()
+	b, err := json.Marshal(jsonBackup{Records: store.records})
+	store.mu.Unlock()
 	if err != nil {
 		return err
 	}
-	return store.files.Save("backup.json", bytes.NewBuffer(b))
+	if err := store.files.Save("backup.json.tmp", bytes.NewBuffer(b)); err != nil {
+		return err
+	}
+	return files.Move(store.files, "backup.json.tmp", "backup.json")
 }
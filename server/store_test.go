@@ -0,0 +1,100 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/tclairet/merklestore/files"
+	"github.com/tclairet/merklestore/server"
+	"github.com/tclairet/merklestore/server/storetest"
+)
+
+func TestMemStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) server.MetadataStore {
+		return server.NewMemStore()
+	})
+}
+
+func TestJsonStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) server.MetadataStore {
+		store, err := server.NewJsonStore(dirHandler{dir: t.TempDir()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	})
+}
+
+func TestJsonStoreBackup(t *testing.T) {
+	handler := dirHandler{dir: t.TempDir()}
+	store, err := server.NewJsonStore(handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := server.Batch{Root: "root", Total: 50}
+	var wg sync.WaitGroup
+	for i := 0; i < batch.Total; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := store.Save(context.Background(), batch, i, server.FileInfo{}, []byte{byte(i)}, ""); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	restored, err := server.NewJsonStore(handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := restored.Get(context.Background(), batch.Root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(record.Received()), batch.Total; got != want {
+		t.Errorf("got %v files, want %v", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(handler.dir, "backup.json.tmp")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got %v, want %v", err, fs.ErrNotExist)
+	}
+}
+
+func TestBoltStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) server.MetadataStore {
+		store, err := server.NewBoltStore(filepath.Join(t.TempDir(), "merkle.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { store.Close() })
+		return store
+	})
+}
+
+// dirHandler is a files.OS rooted in dir.
+type dirHandler struct {
+	files.OS
+	dir string
+}
+
+func (h dirHandler) Open(path string) (io.ReadCloser, error) {
+	return h.OS.Open(filepath.Join(h.dir, path))
+}
+
+func (h dirHandler) Delete(path string) error {
+	return h.OS.Delete(filepath.Join(h.dir, path))
+}
+
+func (h dirHandler) Save(name string, content io.Reader) error {
+	return h.OS.Save(filepath.Join(h.dir, name), content)
+}
+
+func (h dirHandler) Rename(from, to string) error {
+	return h.OS.Rename(filepath.Join(h.dir, from), filepath.Join(h.dir, to))
+}
@@ -0,0 +1,281 @@
+// Package storetest checks that a server.MetadataStore implementation behaves
+// as the server expects.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/tclairet/merklestore/merkletree"
+	"github.com/tclairet/merklestore/server"
+)
+
+// Run runs the conformance suite, newStore must return an empty store.
+func Run(t *testing.T, newStore func(t *testing.T) server.MetadataStore) {
+	ctx := context.Background()
+
+	t.Run("unknown root", func(t *testing.T) {
+		store := newStore(t)
+		if _, err := store.Get(ctx, "unknown"); !errors.Is(err, server.ErrRecordNotFound) {
+			t.Errorf("got %v, want %v", err, server.ErrRecordNotFound)
+		}
+		if _, err := store.Hash(ctx, "unknown", 0); !errors.Is(err, server.ErrRecordNotFound) {
+			t.Errorf("got %v, want %v", err, server.ErrRecordNotFound)
+		}
+		if err := store.Delete(ctx, "unknown"); err != nil {
+			t.Errorf("deleting an unknown root: %v", err)
+		}
+		records, err := store.List(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := len(records), 0; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("save", func(t *testing.T) {
+		store := newStore(t)
+		batch := server.Batch{Root: "root", Total: 3, BlockSize: 4}
+		save(t, store, batch, 2, "c")
+		save(t, store, batch, 0, "a")
+
+		expected := server.NewRecord(batch)
+		expected.Hashes[0] = []byte("a")
+		expected.Hashes[2] = []byte("c")
+		record, err := store.Get(ctx, "root")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := record, *expected; !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %+v, want %+v", got, want)
+		}
+
+		hash, err := store.Hash(ctx, "root", 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(hash), "c"; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		hash, err = store.Hash(ctx, "root", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hash != nil {
+			t.Errorf("got %v, want nil", hash)
+		}
+	})
+
+	t.Run("names", func(t *testing.T) {
+		store := newStore(t)
+		batch := server.Batch{Root: "root", Total: 3, Named: true}
+		for index, name := range map[int]string{0: "a.txt", 2: "dir/c.txt"} {
+			if err := store.Save(ctx, batch, index, server.FileInfo{Name: name}, []byte(name), ""); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		expected := server.NewRecord(batch)
+		expected.Hashes[0], expected.Names[0] = []byte("a.txt"), "a.txt"
+		expected.Hashes[2], expected.Names[2] = []byte("dir/c.txt"), "dir/c.txt"
+		record, err := store.Get(ctx, "root")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := record, *expected; !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("file infos", func(t *testing.T) {
+		store := newStore(t)
+		batch := server.Batch{Root: "root", Total: 2, Metadata: true}
+		info := server.FileInfo{Name: "a.txt", Size: 3, ContentType: "text/plain", ModTime: 1700000000}
+		if err := store.Save(ctx, batch, 1, info, []byte("a"), ""); err != nil {
+			t.Fatal(err)
+		}
+
+		expected := server.NewRecord(batch)
+		expected.Hashes[1], expected.Infos[1] = []byte("a"), info
+		record, err := store.Get(ctx, "root")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := record, *expected; !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("blobs", func(t *testing.T) {
+		store := newStore(t)
+		batch := server.Batch{Root: "root", Total: 3}
+		save(t, store, batch, 0, "a")
+		if err := store.Save(ctx, batch, 2, server.FileInfo{}, []byte("c"), "sha256/63"); err != nil {
+			t.Fatal(err)
+		}
+
+		expected := server.NewRecord(batch)
+		expected.Hashes[0], expected.Hashes[2] = []byte("a"), []byte("c")
+		expected.Blobs = []string{"", "", "sha256/63"}
+		record, err := store.Get(ctx, "root")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := record, *expected; !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("invalid index", func(t *testing.T) {
+		store := newStore(t)
+		batch := server.Batch{Root: "root", Total: 2}
+		save(t, store, batch, 0, "a")
+		for _, index := range []int{-1, 2} {
+			if err := store.Save(ctx, batch, index, server.FileInfo{}, []byte("x"), ""); err == nil {
+				t.Errorf("save at %d should fail", index)
+			}
+			if _, err := store.Hash(ctx, "root", index); err == nil {
+				t.Errorf("hash at %d should fail", index)
+			}
+		}
+	})
+
+	t.Run("batch is kept from first save", func(t *testing.T) {
+		store := newStore(t)
+		save(t, store, server.Batch{Root: "root", Total: 2}, 0, "a")
+		save(t, store, server.Batch{Root: "root", Total: 2, BlockSize: 8, Hash: merkletree.BLAKE3, OddNode: merkletree.Duplicate}, 1, "b")
+		record, err := store.Get(ctx, "root")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := record.Batch, (server.Batch{Root: "root", Total: 2, Hash: merkletree.SHA256, OddNode: merkletree.Promote}); got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("records are copies", func(t *testing.T) {
+		store := newStore(t)
+		batch := server.Batch{Root: "root", Total: 1}
+		save(t, store, batch, 0, "a")
+		record, err := store.Get(ctx, "root")
+		if err != nil {
+			t.Fatal(err)
+		}
+		record.Hashes[0][0] = 'z'
+		record.Hashes[0] = nil
+		hash, err := store.Hash(ctx, "root", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(hash), "a"; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("list and pending", func(t *testing.T) {
+		store := newStore(t)
+		save(t, store, server.Batch{Root: "c", Total: 1}, 0, "c")
+		save(t, store, server.Batch{Root: "a", Total: 2}, 0, "a")
+		save(t, store, server.Batch{Root: "b", Total: 1}, 0, "b")
+
+		records, err := store.List(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := roots(records), []string{"a", "b", "c"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		pending, err := store.Pending(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := roots(pending), []string{"a"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if got, want := pending[0].Received(), []int{0}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		store := newStore(t)
+		save(t, store, server.Batch{Root: "a", Total: 1}, 0, "a")
+		save(t, store, server.Batch{Root: "b", Total: 1}, 0, "b")
+		if err := store.Delete(ctx, "a"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.Get(ctx, "a"); !errors.Is(err, server.ErrRecordNotFound) {
+			t.Errorf("got %v, want %v", err, server.ErrRecordNotFound)
+		}
+		records, err := store.List(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := roots(records), []string{"b"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+
+		// a deleted root starts over with a new batch
+		save(t, store, server.Batch{Root: "a", Total: 2}, 1, "a")
+		record, err := store.Get(ctx, "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := record.Received(), []int{1}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("concurrent saves", func(t *testing.T) {
+		store := newStore(t)
+		batch := server.Batch{Root: "root", Total: 32}
+		var wg sync.WaitGroup
+		errs := make(chan error, batch.Total)
+		for i := 0; i < batch.Total; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs <- store.Save(ctx, batch, i, server.FileInfo{}, []byte(fmt.Sprint(i)), "")
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		record, err := store.Get(ctx, "root")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !record.Complete() {
+			t.Fatalf("got %d hashes, want %d", len(record.Received()), batch.Total)
+		}
+		for i, h := range record.Hashes {
+			if got, want := string(h), fmt.Sprint(i); got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		}
+	})
+}
+
+func save(t *testing.T, store server.MetadataStore, batch server.Batch, index int, hash string) {
+	t.Helper()
+	if err := store.Save(context.Background(), batch, index, server.FileInfo{}, []byte(hash), ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func roots(records []server.Record) []string {
+	roots := []string{}
+	for _, record := range records {
+		roots = append(roots, record.Root)
+	}
+	return roots
+}
@@ -3,18 +3,18 @@ package tests
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
-	"log"
-	"net/http"
+	"net/http/httptest"
 	"os"
-	"os/signal"
+	"path/filepath"
 	"strconv"
-	"syscall"
+	"strings"
 	"testing"
-	"time"
 
 	"github.com/tclairet/merklestore/client"
 	"github.com/tclairet/merklestore/files"
+	"github.com/tclairet/merklestore/merkletree"
 	"github.com/tclairet/merklestore/server"
 )
 
@@ -22,28 +22,40 @@ func TestE2E(t *testing.T) {
 	t.Cleanup(cleanUp)
 
 	fileHandler := files.OS{}
-	store, err := server.NewJsonStore(fileHandler)
+	store, err := server.NewBoltStore(filepath.Join(t.TempDir(), "merkle.db"))
 	if err != nil {
-		panic(err)
+		t.Fatal(err)
 	}
-	s, err := server.New(fileHandler, store)
+	defer store.Close()
+	s, err := server.New(context.Background(), fileHandler, store)
 	if err != nil {
 		panic(err)
 	}
 	api := server.NewAPI(s)
-	go makeServer(api.Routes())
+	httpServer := httptest.NewServer(api.Routes())
+	t.Cleanup(httpServer.Close)
 
-	serverClient := server.NewClient("http://0.0.0.0:3333")
-	uploader := client.NewUploader(fileHandler, serverClient)
+	serverClient := server.NewClient(httpServer.URL)
 	tests := []struct {
-		nbInputs int
+		nbInputs  int
+		blockSize int
+		hash      string
+		oddNode   merkletree.OddNode
 	}{
-		{1},
-		{5},
-		{50},
+		{1, 0, merkletree.SHA256, merkletree.Promote},
+		{5, 0, merkletree.SHA256, merkletree.Promote},
+		{50, 0, merkletree.SHA256, merkletree.Promote},
+		{1, 1, merkletree.SHA256, merkletree.Promote},
+		{50, 1, merkletree.SHA256, merkletree.Promote},
+		{5, 0, merkletree.BLAKE2b256, merkletree.Promote},
+		{5, 1, merkletree.BLAKE3, merkletree.Promote},
+		{5, 0, merkletree.SHA512, merkletree.Promote},
+		{5, 0, merkletree.SHA256d, merkletree.Duplicate},
+		{5, 1, merkletree.SHA256d, merkletree.Duplicate},
 	}
 	for _, tt := range tests {
-		t.Run(fmt.Sprintf("%d", tt.nbInputs), func(t *testing.T) {
+		t.Run(fmt.Sprintf("%d blocks of %d with %s %s", tt.nbInputs, tt.blockSize, tt.hash, tt.oddNode), func(t *testing.T) {
+			uploader := client.NewUploader(fileHandler, serverClient, client.WithBlockSize(tt.blockSize), client.WithHash(tt.hash), client.WithOddNode(tt.oddNode))
 			var inputs []string
 			for i := 0; i < tt.nbInputs; i++ {
 				if err := fileHandler.Save(strconv.Itoa(i), bytes.NewBuffer([]byte(strconv.Itoa(i)))); err != nil {
@@ -60,54 +72,251 @@ func TestE2E(t *testing.T) {
 				os.RemoveAll(root)
 			})
 
+			var indexes []int
+			for i := 0; i < tt.nbInputs; i++ {
+				indexes = append(indexes, i)
+			}
+			if err := uploader.Download(root, indexes...); err != nil {
+				t.Fatal(err)
+			}
 			for i := 0; i < tt.nbInputs; i++ {
 				if err := uploader.Download(root, i); err != nil {
 					t.Fatal(err)
 				}
+				proof, err := serverClient.Proof(root, i)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := client.VerifyFile(root, proof, strings.NewReader(strconv.Itoa(i)), tt.blockSize); err != nil {
+					t.Error(err)
+				}
+				if tt.blockSize == 0 {
+					continue
+				}
+				if err := uploader.DownloadBlocks(root, i); err != nil {
+					t.Fatal(err)
+				}
+				b, err := os.ReadFile(fmt.Sprintf("%s/%d", root, i))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, want := string(b), strconv.Itoa(i); got != want {
+					t.Errorf("got %v, want %v", got, want)
+				}
+			}
+
+			appended := []string{"appended-0", "appended-1"}
+			for _, name := range appended {
+				if err := fileHandler.Save(name, bytes.NewBuffer([]byte(name))); err != nil {
+					t.Fatal(err)
+				}
+			}
+			extended, err := uploader.Append(root, appended)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() {
+				os.RemoveAll(extended)
+			})
+			err = uploader.VerifyConsistency(root, extended)
+			if got, want := err == nil, tt.oddNode == merkletree.Promote; got != want {
+				t.Errorf("got %v, want success %v", err, want)
+			}
+			if err := uploader.Download(extended, 0, tt.nbInputs, tt.nbInputs+1); err != nil {
+				t.Fatal(err)
 			}
 		})
 	}
-}
-
-func makeServer(handler http.Handler) {
-	server := &http.Server{Addr: "0.0.0.0:3333", Handler: handler}
-	serverCtx, serverStopCtx := context.WithCancel(context.Background())
 
-	// Listen for syscall signals for process to interrupt/quit
-	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
-	go func() {
-		<-sig
-
-		// Shutdown signal with grace period of 30 seconds
-		shutdownCtx, _ := context.WithTimeout(serverCtx, 30*time.Second)
+	t.Run("named", func(t *testing.T) {
+		uploader := client.NewUploader(fileHandler, serverClient, client.WithNames())
+		t.Cleanup(func() {
+			os.RemoveAll("named")
+		})
+		names := []string{"named/a", "named/dir/b", "named/dir/c"}
+		for _, name := range names {
+			if err := fileHandler.Save(name, bytes.NewBuffer([]byte(name))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		root, err := uploader.Upload(names)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			os.RemoveAll(root)
+		})
+		if err := uploader.DownloadNamed(root, names...); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range names {
+			b, err := os.ReadFile(filepath.Join(root, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(b), name; got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		}
+		if err := uploader.DownloadNamed(root, "named/d"); !errors.Is(err, client.ErrAbsent) {
+			t.Errorf("got %v, want %v", err, client.ErrAbsent)
+		}
+	})
 
-		go func() {
-			<-shutdownCtx.Done()
-			if shutdownCtx.Err() == context.DeadlineExceeded {
-				log.Fatal("graceful shutdown timed out.. forcing exit.")
+	t.Run("directory", func(t *testing.T) {
+		uploader := client.NewUploader(fileHandler, serverClient)
+		dir := t.TempDir()
+		modes := map[string]os.FileMode{"a": 0o600, "sub/b": 0o755, "sub/c/d": 0o644}
+		for path, mode := range modes {
+			if err := fileHandler.Save(filepath.Join(dir, path), bytes.NewBufferString(path)); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chmod(filepath.Join(dir, path), mode); err != nil {
+				t.Fatal(err)
 			}
-		}()
+		}
+		manifest, err := client.NewManifest(os.DirFS(dir))
+		if err != nil {
+			t.Fatal(err)
+		}
+		root, err := uploader.UploadDir(dir, manifest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			os.RemoveAll(root)
+		})
+		if err := uploader.DownloadDir(root); err != nil {
+			t.Fatal(err)
+		}
+		for path, mode := range modes {
+			restored := filepath.Join(root, filepath.FromSlash(path))
+			b, err := os.ReadFile(restored)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(b), path; got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+			info, err := os.Stat(restored)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := info.Mode().Perm(), mode; got != want {
+				t.Errorf("%s: got %v, want %v", path, got, want)
+			}
+		}
+	})
 
-		// Trigger graceful shutdown
-		err := server.Shutdown(shutdownCtx)
+	t.Run("metadata", func(t *testing.T) {
+		uploader := client.NewUploader(fileHandler, serverClient, client.WithMetadata())
+		names := []string{"metadata-0.txt", "metadata-1.json", "metadata-2.txt"}
+		for _, name := range names {
+			if err := fileHandler.Save(name, bytes.NewBufferString(name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		root, err := uploader.Upload(names[:2])
 		if err != nil {
-			log.Fatal(err)
+			t.Fatal(err)
 		}
-		serverStopCtx()
-	}()
+		extended, err := uploader.Append(root, names[2:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			os.RemoveAll(root)
+			os.RemoveAll(extended)
+		})
+		if err := uploader.VerifyConsistency(root, extended); err != nil {
+			t.Error(err)
+		}
+		if err := uploader.Download(extended, 0, 1, 2); err != nil {
+			t.Fatal(err)
+		}
+		infos, err := serverClient.Infos(extended)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, name := range names {
+			if got, want := infos[i].Name, name; got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+			if infos[i].ModTime == 0 {
+				t.Errorf("%s: modification time should be kept", name)
+			}
+			if err := uploader.Download(extended, i); err != nil {
+				t.Fatal(err)
+			}
+			proof, err := serverClient.Proof(extended, i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := client.VerifyFileWithInfo(extended, proof, strings.NewReader(name), 0, infos[i]); err != nil {
+				t.Error(err)
+			}
+			if err := client.VerifyFile(extended, proof, strings.NewReader(name), 0); err == nil {
+				t.Error("proof should not verify without the metadata")
+			}
+		}
+	})
 
-	// Run the server
-	err := server.ListenAndServe()
-	if err != nil && err != http.ErrServerClosed {
-		log.Fatal(err)
-	}
+	t.Run("dedup", func(t *testing.T) {
+		uploader := client.NewUploader(fileHandler, serverClient)
+		save := func(names ...string) {
+			for _, name := range names {
+				if err := fileHandler.Save(name, bytes.NewBufferString(name)); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		save("dedup-0", "dedup-1")
+		first, err := uploader.Upload([]string{"dedup-0", "dedup-1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		before, err := serverClient.Stats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		save("dedup-0", "dedup-1", "dedup-2")
+		second, err := uploader.Upload([]string{"dedup-1", "dedup-2", "dedup-0"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			os.RemoveAll(second)
+		})
+		after, err := serverClient.Stats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := after.Blobs-before.Blobs, 1; got != want {
+			t.Errorf("got %v new blobs, want %v", got, want)
+		}
+		if got, want := after.Files-before.Files, 3; got != want {
+			t.Errorf("got %v new files, want %v", got, want)
+		}
 
-	// Wait for server context to be stopped
-	<-serverCtx.Done()
+		if err := serverClient.Delete(first); err != nil {
+			t.Fatal(err)
+		}
+		if err := uploader.Download(second, 0, 1, 2); err != nil {
+			t.Fatal(err)
+		}
+		b, err := os.ReadFile(filepath.Join(second, "2"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(b), "dedup-0"; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
 }
 
 func cleanUp() {
-	os.RemoveAll("backup.json")
 	os.RemoveAll("root.json")
+	os.RemoveAll("blobs")
+	os.RemoveAll("staging")
+	os.RemoveAll("blocks")
 }